@@ -1,18 +1,193 @@
 package main
 
 import (
+	"context"
 	"fetch_take_home/internal/db"
 	"fetch_take_home/internal/receipts"
 	"fetch_take_home/internal/transport/http"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// getEnv returns the value of the environment variable key, or fallback when it is unset.
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// openDB opens the storage backend selected by the DB_DRIVER environment variable.
+func openDB() (receipts.DB, error) {
+	switch driver := getEnv("DB_DRIVER", "memory"); driver {
+	case "memory":
+		return db.NewDB(), nil
+	case "file":
+		return db.NewFileDB(getEnv("DB_PATH", "data/receipts.json"))
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+}
+
+// trustedProxies returns the proxies named by TRUSTED_PROXIES, a comma separated
+// list of addresses or CIDR ranges, none when it is unset.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// loadRules loads the rule set from the file named by RULES_PATH, falling back to the built-in rules.
+func loadRules() (receipts.RuleSet, error) {
+	path := getEnv("RULES_PATH", "")
+	if path == "" {
+		return receipts.DefaultRuleSet(), nil
+	}
+	return receipts.LoadRuleSet(path)
+}
+
+// loadTiers loads the tier set from the file named by TIERS_PATH, falling back to the built-in tiers.
+func loadTiers() (receipts.TierSet, error) {
+	path := getEnv("TIERS_PATH", "")
+	if path == "" {
+		return receipts.DefaultTierSet(), nil
+	}
+	return receipts.LoadTierSet(path)
+}
+
 func Run() error {
-	database := db.NewDB()
-	service := receipts.NewReceiptService(database)
+	database, err := openDB()
+	if err != nil {
+		return err
+	}
+	rules, err := loadRules()
+	if err != nil {
+		return err
+	}
+	tiers, err := loadTiers()
+	if err != nil {
+		return err
+	}
+	tierInterval, err := time.ParseDuration(getEnv("TIER_INTERVAL", "1h"))
+	if err != nil || tierInterval <= 0 {
+		return fmt.Errorf("invalid TIER_INTERVAL %q", getEnv("TIER_INTERVAL", "1h"))
+	}
+	idempotencyWindow, err := time.ParseDuration(getEnv("IDEMPOTENCY_WINDOW", "24h"))
+	if err != nil {
+		return fmt.Errorf("invalid IDEMPOTENCY_WINDOW: %w", err)
+	}
+	maxBatchSize, err := strconv.Atoi(getEnv("BATCH_MAX_SIZE", strconv.Itoa(http.DefaultMaxBatchSize)))
+	if err != nil || maxBatchSize <= 0 {
+		return fmt.Errorf("invalid BATCH_MAX_SIZE %q", getEnv("BATCH_MAX_SIZE", strconv.Itoa(http.DefaultMaxBatchSize)))
+	}
+	jobWorkers, err := strconv.Atoi(getEnv("JOB_WORKERS", "4"))
+	if err != nil || jobWorkers < 0 {
+		return fmt.Errorf("invalid JOB_WORKERS %q", getEnv("JOB_WORKERS", "4"))
+	}
+	jobQueueSize, err := strconv.Atoi(getEnv("JOB_QUEUE_SIZE", strconv.Itoa(receipts.DefaultJobQueueSize)))
+	if err != nil || jobQueueSize <= 0 {
+		return fmt.Errorf("invalid JOB_QUEUE_SIZE %q", getEnv("JOB_QUEUE_SIZE", strconv.Itoa(receipts.DefaultJobQueueSize)))
+	}
+	jobRetention, err := time.ParseDuration(getEnv("JOB_RETENTION", receipts.DefaultJobRetention.String()))
+	if err != nil || jobRetention <= 0 {
+		return fmt.Errorf("invalid JOB_RETENTION %q", getEnv("JOB_RETENTION", receipts.DefaultJobRetention.String()))
+	}
+	cancellationWindow, err := time.ParseDuration(getEnv("REDEMPTION_CANCEL_WINDOW", receipts.DefaultCancellationWindow.String()))
+	if err != nil || cancellationWindow < 0 {
+		return fmt.Errorf("invalid REDEMPTION_CANCEL_WINDOW %q", getEnv("REDEMPTION_CANCEL_WINDOW", receipts.DefaultCancellationWindow.String()))
+	}
+	duplicatePolicy, err := receipts.ParseDuplicatePolicy(getEnv("DUPLICATE_POLICY", string(receipts.DuplicateReject)))
+	if err != nil {
+		return err
+	}
+	reconcilePolicy, err := receipts.ParseReconcilePolicy(getEnv("RECONCILE_POLICY", string(receipts.ReconcileFlag)))
+	if err != nil {
+		return err
+	}
+	reconcileTolerance, err := receipts.ParseMoney(getEnv("RECONCILE_TOLERANCE", "0.00"))
+	if err != nil {
+		return fmt.Errorf("invalid RECONCILE_TOLERANCE: %w", err)
+	}
+	riskThreshold, err := strconv.Atoi(getEnv("RISK_THRESHOLD", strconv.Itoa(receipts.DefaultRiskThreshold)))
+	if err != nil {
+		return fmt.Errorf("invalid RISK_THRESHOLD: %w", err)
+	}
+	expiryMonths, err := strconv.Atoi(getEnv("EXPIRY_MONTHS", "0"))
+	if err != nil {
+		return fmt.Errorf("invalid EXPIRY_MONTHS: %w", err)
+	}
+	expiryBasis, err := receipts.ParseExpiryBasis(getEnv("EXPIRY_BASIS", string(receipts.ExpiryFromPurchase)))
+	if err != nil {
+		return err
+	}
+	expiryPolicy, err := receipts.NewExpiryPolicy(getEnv("EXPIRY_POLICY_VERSION", ""), expiryMonths, expiryBasis)
+	if err != nil {
+		return err
+	}
+	expiryInterval, err := time.ParseDuration(getEnv("EXPIRY_INTERVAL", "1h"))
+	if err != nil || expiryInterval <= 0 {
+		return fmt.Errorf("invalid EXPIRY_INTERVAL %q", getEnv("EXPIRY_INTERVAL", "1h"))
+	}
+	service := receipts.NewReceiptService(database,
+		receipts.WithRuleSet(rules),
+		receipts.WithDuplicatePolicy(duplicatePolicy),
+		receipts.WithReconcilePolicy(reconcilePolicy, reconcileTolerance),
+		receipts.WithRiskChecks(riskThreshold, receipts.DefaultRiskChecks(rules)...),
+		receipts.WithExpiryPolicy(expiryPolicy),
+		receipts.WithTierSet(tiers),
+		receipts.WithJobQueueSize(jobQueueSize),
+		receipts.WithJobRetention(jobRetention),
+		receipts.WithCancellationWindow(cancellationWindow),
+	)
+	// Registers the rules receipts are scored under, so they can be recomputed under them later.
+	if _, err := service.SaveRuleSet(rules); err != nil {
+		return err
+	}
+	// A ledger that does not add up would only drift further, so the server
+	// refuses to start until it is repaired.
+	report, err := service.CheckLedger()
+	if err != nil {
+		return err
+	}
+	if !report.Balanced {
+		for _, discrepancy := range report.Discrepancies {
+			log.WithFields(log.Fields{
+				"receiptId":    discrepancy.ReceiptID,
+				"redemptionId": discrepancy.RedemptionID,
+				"entryId":      discrepancy.EntryID,
+				"account":      discrepancy.Account,
+				"expected":     discrepancy.Expected,
+				"posted":       discrepancy.Posted,
+			}).Error("Ledger discrepancy")
+		}
+		return fmt.Errorf("the ledger has %d discrepancies", len(report.Discrepancies))
+	}
+	// Receipts submitted under an earlier policy may still expire, so the job always runs.
+	go receipts.Every(context.Background(), expiryInterval, func() { _, _ = service.ExpirePoints() })
+	go receipts.Every(context.Background(), tierInterval, func() { _, _ = service.RecalculateTiers() })
+	go receipts.Every(context.Background(), time.Hour, func() { _, _ = service.PruneJobs() })
 	router := gin.New()
-	http.Activate(router, service)
+	// Clients are told apart by IP address for risk scoring, so X-Forwarded-For
+	// is only believed when a trusted proxy sent it.
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	http.Activate(router, service,
+		http.WithIdempotencyWindow(idempotencyWindow),
+		http.WithMaxBatchSize(maxBatchSize),
+		http.WithJobWorkers(jobWorkers),
+		http.WithAdminToken(getEnv("ADMIN_TOKEN", "")),
+		http.WithAuthSecret(getEnv("AUTH_SECRET", "")),
+	)
 	if err := router.Run(":8080"); err != nil {
 		return err
 	}
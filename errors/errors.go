@@ -1,28 +1,209 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+)
+
+// ProblemContentType is the media type of AppError responses, see RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// typeBase prefixes a Code to form the problem type URI reference.
+const typeBase = "/problems/"
+
+// Code is a stable, namespaced identifier of an error that clients can branch on.
+type Code string
 
 const (
-	InternalServerError = "500"
+	Internal Code = "server.internal"
+
+	ReceiptNotFound Code = "receipt.not_found"
+
+	ReceiptInvalid Code = "receipt.invalid"
+
+	ReceiptDuplicate Code = "receipt.duplicate"
+
+	ReceiptTotalMismatch Code = "receipt.total_mismatch"
+
+	ReceiptStatusConflict Code = "receipt.status_conflict"
+
+	ReceiptGone Code = "receipt.gone"
+
+	ReviewInvalid Code = "review.invalid"
+
+	Unauthorized Code = "auth.unauthorized"
+
+	Forbidden Code = "auth.forbidden"
+
+	UserInvalid Code = "user.invalid"
+
+	AdjustmentInvalid Code = "adjustment.invalid"
+
+	RewardNotFound Code = "reward.not_found"
+
+	RewardInvalid Code = "reward.invalid"
+
+	RewardOutOfStock Code = "reward.out_of_stock"
+
+	PointsInsufficient Code = "points.insufficient"
+
+	RedemptionNotFound Code = "redemption.not_found"
+
+	RedemptionCancelled Code = "redemption.cancelled"
+
+	RedemptionFinal Code = "redemption.final"
+
+	CampaignNotFound Code = "campaign.not_found"
+
+	CampaignInvalid Code = "campaign.invalid"
+
+	RuleSetNotFound Code = "ruleset.not_found"
+
+	RuleSetInvalid Code = "ruleset.invalid"
+
+	RuleSetConflict Code = "ruleset.conflict"
+
+	RecomputationNotFound Code = "recomputation.not_found"
 
-	BadRequest = "400"
+	RecomputationInvalid Code = "recomputation.invalid"
 
-	NotFound = "404"
+	RecomputationCommitted Code = "recomputation.committed"
+
+	RecomputationStale Code = "recomputation.stale"
+
+	BatchInvalid Code = "batch.invalid"
+
+	BatchTooLarge Code = "batch.too_large"
+
+	JobNotFound Code = "job.not_found"
+
+	JobQueueFull Code = "job.queue_full"
+
+	JobStatusConflict Code = "job.status_conflict"
+
+	QueryInvalid Code = "query.invalid"
+
+	IdempotencyKeyReused Code = "idempotency.key_reused"
+
+	IdempotencyKeyInFlight Code = "idempotency.key_in_flight"
+
+	RequestTooLarge Code = "request.too_large"
 )
 
+// catalogEntry is the HTTP status and short, fixed summary of a Code.
+type catalogEntry struct {
+	Status int
+	Title  string
+}
+
+var catalog = map[Code]catalogEntry{
+	Internal:               {Status: http.StatusInternalServerError, Title: "Internal server error"},
+	ReceiptNotFound:        {Status: http.StatusNotFound, Title: "No receipt found for that id"},
+	ReceiptInvalid:         {Status: http.StatusBadRequest, Title: "The receipt is invalid"},
+	ReceiptDuplicate:       {Status: http.StatusConflict, Title: "The receipt was already processed"},
+	ReceiptTotalMismatch:   {Status: http.StatusUnprocessableEntity, Title: "The receipt total does not match its items"},
+	ReceiptStatusConflict:  {Status: http.StatusConflict, Title: "The receipt status does not allow this change"},
+	ReceiptGone:            {Status: http.StatusGone, Title: "The receipt was voided or deleted"},
+	ReviewInvalid:          {Status: http.StatusBadRequest, Title: "The review is invalid"},
+	Unauthorized:           {Status: http.StatusUnauthorized, Title: "Missing or invalid credentials"},
+	Forbidden:              {Status: http.StatusForbidden, Title: "The caller may not access this resource"},
+	UserInvalid:            {Status: http.StatusBadRequest, Title: "The user id is invalid"},
+	AdjustmentInvalid:      {Status: http.StatusBadRequest, Title: "The adjustment is invalid"},
+	RewardNotFound:         {Status: http.StatusNotFound, Title: "No reward found for that id"},
+	RewardInvalid:          {Status: http.StatusBadRequest, Title: "The reward is invalid"},
+	RewardOutOfStock:       {Status: http.StatusConflict, Title: "The reward is out of stock"},
+	PointsInsufficient:     {Status: http.StatusUnprocessableEntity, Title: "The balance is too low for this reward"},
+	RedemptionNotFound:     {Status: http.StatusNotFound, Title: "No redemption found for that id"},
+	RedemptionCancelled:    {Status: http.StatusConflict, Title: "The redemption was already cancelled"},
+	RedemptionFinal:        {Status: http.StatusConflict, Title: "The redemption can no longer be cancelled"},
+	CampaignNotFound:       {Status: http.StatusNotFound, Title: "No campaign found for that id"},
+	CampaignInvalid:        {Status: http.StatusBadRequest, Title: "The campaign is invalid"},
+	RuleSetNotFound:        {Status: http.StatusNotFound, Title: "No rule set found for that version"},
+	RuleSetInvalid:         {Status: http.StatusBadRequest, Title: "The rule set is invalid"},
+	RuleSetConflict:        {Status: http.StatusConflict, Title: "The rule set version already has different rules"},
+	RecomputationNotFound:  {Status: http.StatusNotFound, Title: "No recomputation found for that id"},
+	RecomputationInvalid:   {Status: http.StatusBadRequest, Title: "The recomputation is invalid"},
+	RecomputationCommitted: {Status: http.StatusConflict, Title: "The recomputation was already committed"},
+	RecomputationStale:     {Status: http.StatusConflict, Title: "The receipts changed since the recomputation was previewed"},
+	BatchInvalid:           {Status: http.StatusBadRequest, Title: "The batch is invalid"},
+	BatchTooLarge:          {Status: http.StatusRequestEntityTooLarge, Title: "The batch has too many receipts"},
+	JobNotFound:            {Status: http.StatusNotFound, Title: "No job found for that id"},
+	JobQueueFull:           {Status: http.StatusServiceUnavailable, Title: "Too many receipts are waiting to be processed"},
+	JobStatusConflict:      {Status: http.StatusConflict, Title: "The job is not running"},
+	QueryInvalid:           {Status: http.StatusBadRequest, Title: "The query is invalid"},
+	IdempotencyKeyReused:   {Status: http.StatusUnprocessableEntity, Title: "The Idempotency-Key was already used for a different request"},
+	IdempotencyKeyInFlight: {Status: http.StatusConflict, Title: "A request with this Idempotency-Key is still being processed"},
+	RequestTooLarge:        {Status: http.StatusRequestEntityTooLarge, Title: "The request body is too large"},
+}
+
+// Field error codes
+const (
+	FieldRequired = "required"
+
+	FieldInvalidFormat = "invalid_format"
+
+	FieldInvalidType = "invalid_type"
+
+	FieldTooShort = "too_short"
+
+	FieldTooLarge = "too_large"
+
+	FieldInvalid = "invalid"
+)
+
+// AppError is an RFC 7807 problem details object.
+// Type: URI reference identifying the kind of problem, derived from Code
+// Title: Short summary of the kind of problem, the same for every occurrence
+// Status: The HTTP status code
+// Detail: Explanation specific to this occurrence
+// Instance: The request path the problem occurred on
+// Code: Stable, namespaced error code
+// RequestID: ID of the request, also sent in the X-Request-ID header
+// Errors: The invalid fields, if the request failed validation
 type AppError struct {
-	Code        string `json:"code"`
-	Description string `json:"description"`
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError
+// Path: JSON pointer to the offending value, e.g. /items/2/price
+// Code: One of the field error codes
+// Message: Human-readable description of the problem
+type FieldError struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 func (a AppError) Error() string {
-	return fmt.Sprintf("%s: %s", a.Code, a.Description)
+	return fmt.Sprintf("%s: %s", a.Code, a.Detail)
 }
 
-func NewAppError(code string, description string) error {
-	e := &AppError{
-		Code:        code,
-		Description: description,
+// NewAppError returns the problem for code. Codes missing from the catalog are reported as Internal.
+func NewAppError(code Code, detail string) *AppError {
+	entry, ok := catalog[code]
+	if !ok {
+		code = Internal
+		entry = catalog[Internal]
 	}
+	return &AppError{
+		Type:   typeBase + string(code),
+		Title:  entry.Title,
+		Status: entry.Status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// NewValidationError returns a ReceiptInvalid problem listing every field that failed validation.
+func NewValidationError(detail string, fieldErrors []FieldError) *AppError {
+	e := NewAppError(ReceiptInvalid, detail)
+	e.Errors = fieldErrors
 	return e
 }
@@ -2,20 +2,47 @@ package errors
 
 import (
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"testing"
 )
 
 func TestError(t *testing.T) {
 	appError := AppError{
-		Code:        "code",
-		Description: "description",
+		Code:   "code",
+		Detail: "detail",
 	}
-	assert.Equal(t, "code: description", appError.Error())
+	assert.Equal(t, "code: detail", appError.Error())
 }
 
 func TestNewError(t *testing.T) {
-	code := "code"
-	description := "description"
-	appError := NewAppError(code, description)
-	assert.Equal(t, "code: description", appError.Error())
+	appError := NewAppError(ReceiptNotFound, "detail")
+	assert.Equal(t, &AppError{
+		Type:   "/problems/receipt.not_found",
+		Title:  "No receipt found for that id",
+		Status: http.StatusNotFound,
+		Detail: "detail",
+		Code:   ReceiptNotFound,
+	}, appError)
+	assert.Equal(t, "receipt.not_found: detail", appError.Error())
+}
+
+func TestNewErrorUnknownCode(t *testing.T) {
+	appError := NewAppError("unknown", "detail")
+	assert.Equal(t, Internal, appError.Code)
+	assert.Equal(t, http.StatusInternalServerError, appError.Status)
+}
+
+func TestCatalog(t *testing.T) {
+	for code, entry := range catalog {
+		assert.NotEmpty(t, entry.Title, code)
+		assert.NotEmpty(t, http.StatusText(entry.Status), code)
+	}
+}
+
+func TestNewValidationError(t *testing.T) {
+	fieldErrors := []FieldError{{Path: "/total", Code: FieldRequired, Message: "total is required"}}
+	appError := NewValidationError("detail", fieldErrors)
+	assert.Equal(t, ReceiptInvalid, appError.Code)
+	assert.Equal(t, http.StatusBadRequest, appError.Status)
+	assert.Equal(t, fieldErrors, appError.Errors)
 }
@@ -0,0 +1,68 @@
+package db
+
+import "fetch_take_home/internal/receipts"
+
+func (db *Database) Campaigns() ([]receipts.Campaign, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	campaigns := make([]receipts.Campaign, 0, len(db.campaigns))
+	for _, c := range db.campaigns {
+		campaigns = append(campaigns, *c)
+	}
+	return campaigns, nil
+}
+
+func (db *Database) SaveCampaign(c receipts.Campaign) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	previous := db.campaigns[c.ID]
+	db.campaigns[c.ID] = &c
+	if err := db.persist(); err != nil {
+		if previous == nil {
+			delete(db.campaigns, c.ID)
+		} else {
+			db.campaigns[c.ID] = previous
+		}
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeleteCampaign(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	previous := db.campaigns[id]
+	if previous == nil {
+		return receipts.ErrCampaignNotFound
+	}
+	delete(db.campaigns, id)
+	if err := db.persist(); err != nil {
+		db.campaigns[id] = previous
+		return err
+	}
+	return nil
+}
+
+// CampaignUsage adds up the campaign points of the approved and pending
+// receipts, so receipts held for review keep their share of the budget.
+func (db *Database) CampaignUsage(userID string) (receipts.CampaignUsage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	usage := receipts.CampaignUsage{Spent: make(map[string]int64), User: make(map[string]int64)}
+	for id, r := range db.receiptsDB {
+		if r.Status != receipts.StatusApproved && r.Status != receipts.StatusPending {
+			continue
+		}
+		for campaignID, points := range receipts.CampaignPoints(*db.pointsDB[id]) {
+			usage.Spent[campaignID] += points
+			if userID != "" && r.UserID == userID {
+				usage.User[campaignID] += points
+			}
+		}
+	}
+	return usage, nil
+}
@@ -2,44 +2,464 @@ package db
 
 import (
 	"fetch_take_home/internal/receipts"
+	"fmt"
 	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
 )
 
+// Database is a receipts.DB kept in memory. It is safe for concurrent use:
+// lookups share a read lock, while writes and the snapshot taken by persist
+// hold the write lock so they always see a consistent view of every map.
 type Database struct {
+	mu sync.RWMutex
+
 	pointsDB   map[string]*receipts.Points
 	receiptsDB map[string]*receipts.Receipt
+
+	// audit holds the status changes of every receipt, oldest first.
+	audit map[string][]receipts.AuditEntry
+
+	// tombstones records the receipts that were deleted.
+	tombstones map[string]*receipts.Tombstone
+
+	// ledger records every change to the points of a user, in step with the receipts.
+	ledger *ledger
+
+	// rewards is the catalog, and redemptions the rewards users spent points on.
+	rewards     map[string]*receipts.Reward
+	redemptions map[string]*receipts.Redemption
+
+	// tiers holds the loyalty tier of every user.
+	tiers map[string]string
+
+	// campaigns holds the promotions awarding points on top of the rules.
+	campaigns map[string]*receipts.Campaign
+
+	// ruleSets holds every registered rule set by version, and
+	// recomputations the reports of rescoring receipts under one.
+	ruleSets       map[string]*receipts.RuleSet
+	recomputations map[string]*receipts.Recomputation
+
+	// jobs holds the receipts submitted for background processing, and
+	// queue the IDs of those still queued, oldest first.
+	jobs  map[string]*receipts.Job
+	queue []string
+
+	// fingerprints maps a receipt fingerprint to the first receipt stored with it.
+	fingerprints map[string]string
+
+	// path is the snapshot file backing the database, empty for a purely in-memory store.
+	path string
 }
 
 func NewDB() receipts.DB {
+	return newDatabase()
+}
+
+func newDatabase() *Database {
 	pDB := make(map[string]*receipts.Points)
 	rDB := make(map[string]*receipts.Receipt)
 
 	return &Database{
-		pointsDB:   pDB,
-		receiptsDB: rDB,
+		pointsDB:       pDB,
+		receiptsDB:     rDB,
+		audit:          make(map[string][]receipts.AuditEntry),
+		tombstones:     make(map[string]*receipts.Tombstone),
+		ledger:         newLedger(nil),
+		rewards:        make(map[string]*receipts.Reward),
+		redemptions:    make(map[string]*receipts.Redemption),
+		tiers:          make(map[string]string),
+		campaigns:      make(map[string]*receipts.Campaign),
+		ruleSets:       make(map[string]*receipts.RuleSet),
+		recomputations: make(map[string]*receipts.Recomputation),
+		jobs:           make(map[string]*receipts.Job),
+		fingerprints:   make(map[string]string),
 	}
 }
 
 func (db *Database) GetPoints(id string) (receipts.Points, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	if db.pointsDB[id] == nil {
-		return receipts.Points{}, receipts.ErrReceiptNotFound
+		return receipts.Points{}, db.missing(id)
 	}
 	return *db.pointsDB[id], nil
 }
 
+func (db *Database) GetReceipt(id string) (receipts.StoredReceipt, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.receiptsDB[id] == nil {
+		return receipts.StoredReceipt{}, db.missing(id)
+	}
+	return receipts.StoredReceipt{
+		Receipt: *db.receiptsDB[id],
+		Points:  *db.pointsDB[id],
+	}, nil
+}
+
+func (db *Database) List(q receipts.ReceiptQuery) (receipts.ReceiptPage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	all := make([]receipts.StoredReceipt, 0, len(db.receiptsDB))
+	for id, r := range db.receiptsDB {
+		all = append(all, receipts.StoredReceipt{
+			Receipt: *r,
+			Points:  *db.pointsDB[id],
+		})
+	}
+	return q.Paginate(all), nil
+}
+
+// FindByFingerprint returns the earliest receipt stored with the fingerprint.
+func (db *Database) FindByFingerprint(fingerprint string) (receipts.StoredReceipt, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	id, ok := db.fingerprints[fingerprint]
+	if !ok {
+		return receipts.StoredReceipt{}, receipts.ErrReceiptNotFound
+	}
+	return receipts.StoredReceipt{
+		Receipt: *db.receiptsDB[id],
+		Points:  *db.pointsDB[id],
+	}, nil
+}
+
 func (db *Database) Create(r receipts.Receipt, p receipts.Points) (receipts.Receipt, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	var id = uuid.NewString()
-	db.receiptsDB[id] = &receipts.Receipt{
-		ID:           id,
-		Retailer:     r.Retailer,
-		PurchaseDate: r.PurchaseDate,
-		PurchaseTime: r.PurchaseTime,
-		Items:        r.Items,
-		Total:        r.Total,
-	}
-	db.pointsDB[id] = &receipts.Points{
-		ID:     id,
-		Points: p.Points,
-	}
-	return *db.receiptsDB[id], nil
+	stored := r
+	stored.ID = id
+	stored.CreatedAt = time.Now().UTC()
+	db.receiptsDB[id] = &stored
+	p.ID = id
+	db.pointsDB[id] = &p
+	job := db.jobs[stored.JobID]
+	if job != nil && job.Status == receipts.JobRunning {
+		finished := *job
+		finished.Status = receipts.JobSucceeded
+		finished.ReceiptID = id
+		finished.FinishedAt = &stored.CreatedAt
+		db.jobs[job.ID] = &finished
+	}
+	db.audit[id] = []receipts.AuditEntry{{
+		ReceiptID: id,
+		To:        stored.Status,
+		Reason:    "submitted",
+		At:        stored.CreatedAt,
+	}}
+	posted := len(db.ledger.entries)
+	awarded := receipts.StoredReceipt{Receipt: stored, Points: *db.pointsDB[id]}.Awarded()
+	db.postAwarded(stored, 0, awarded, db.audit[id][0])
+	if err := db.persist(); err != nil {
+		delete(db.receiptsDB, id)
+		delete(db.pointsDB, id)
+		delete(db.audit, id)
+		if job != nil {
+			db.jobs[job.ID] = job
+		}
+		db.ledger.truncate(posted)
+		return receipts.Receipt{}, err
+	}
+	db.index(&stored)
+	return stored, nil
+}
+
+func (db *Database) Transition(entry receipts.AuditEntry) (receipts.StoredReceipt, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current := db.receiptsDB[entry.ReceiptID]
+	if current == nil {
+		return receipts.StoredReceipt{}, db.missing(entry.ReceiptID)
+	}
+	if current.Status != entry.From {
+		return receipts.StoredReceipt{}, fmt.Errorf("%w: receipt is %s", receipts.ErrReceiptStatusConflict, current.Status)
+	}
+
+	points := *db.pointsDB[entry.ReceiptID]
+	updated := *current
+	updated.Status = entry.To
+	trail := db.audit[entry.ReceiptID]
+	posted := len(db.ledger.entries)
+	db.receiptsDB[entry.ReceiptID] = &updated
+	reversed := db.postAwarded(updated,
+		receipts.StoredReceipt{Receipt: *current, Points: points}.Awarded(),
+		receipts.StoredReceipt{Receipt: updated, Points: points}.Awarded(),
+		entry)
+	entry.Reversed = reversed
+	db.audit[entry.ReceiptID] = append(trail[:len(trail):len(trail)], entry)
+	if err := db.persist(); err != nil {
+		db.receiptsDB[entry.ReceiptID] = current
+		db.audit[entry.ReceiptID] = trail
+		db.ledger.truncate(posted)
+		return receipts.StoredReceipt{}, err
+	}
+	db.reindex(updated.Fingerprint)
+	return receipts.StoredReceipt{
+		Receipt: updated,
+		Points:  points,
+	}, nil
+}
+
+func (db *Database) Audit(id string) ([]receipts.AuditEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.receiptsDB[id] == nil && db.tombstones[id] == nil {
+		return nil, receipts.ErrReceiptNotFound
+	}
+	return append([]receipts.AuditEntry(nil), db.audit[id]...), nil
+}
+
+func (db *Database) Delete(entry receipts.AuditEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	id := entry.ReceiptID
+	current := db.receiptsDB[id]
+	if current == nil {
+		return db.missing(id)
+	}
+	if current.Status != entry.From {
+		return fmt.Errorf("%w: receipt is %s", receipts.ErrReceiptStatusConflict, current.Status)
+	}
+
+	points := db.pointsDB[id]
+	awarded := receipts.StoredReceipt{Receipt: *current, Points: *points}.Awarded()
+	trail := db.audit[id]
+	posted := len(db.ledger.entries)
+	reversed := db.postAwarded(*current, awarded, 0, entry)
+	entry.Reversed = reversed
+	delete(db.receiptsDB, id)
+	delete(db.pointsDB, id)
+	db.audit[id] = append(trail[:len(trail):len(trail)], entry)
+	db.tombstones[id] = &receipts.Tombstone{
+		ID:        id,
+		Reason:    entry.Reason,
+		Actor:     entry.Actor,
+		DeletedAt: entry.At,
+	}
+	if err := db.persist(); err != nil {
+		db.receiptsDB[id] = current
+		db.pointsDB[id] = points
+		db.audit[id] = trail
+		delete(db.tombstones, id)
+		db.ledger.truncate(posted)
+		return err
+	}
+	db.reindex(current.Fingerprint)
+	return nil
+}
+
+func (db *Database) GetUser(id string, at time.Time) (receipts.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	posted := len(db.ledger.entries)
+	if _, ok := db.expire(id, at); ok {
+		if err := db.persist(); err != nil {
+			db.ledger.truncate(posted)
+			return receipts.User{}, err
+		}
+	}
+	return receipts.User{ID: id, Balance: db.ledger.balance(id), Tier: db.tiers[id]}, nil
+}
+
+func (db *Database) Users(at time.Time) ([]receipts.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	posted := len(db.ledger.entries)
+	for _, id := range db.ledger.users() {
+		db.expire(id, at)
+	}
+	if len(db.ledger.entries) > posted {
+		if err := db.persist(); err != nil {
+			db.ledger.truncate(posted)
+			return nil, err
+		}
+	}
+
+	ids := db.ledger.users()
+	for id := range db.tiers {
+		if _, ok := db.ledger.accounts[receipts.UserAccount(id)]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	users := make([]receipts.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, receipts.User{ID: id, Balance: db.ledger.balance(id), Tier: db.tiers[id]})
+	}
+	return users, nil
+}
+
+func (db *Database) Activity(userID string, since time.Time) (receipts.Activity, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var activity receipts.Activity
+	for id, r := range db.receiptsDB {
+		if r.UserID != userID || r.Status != receipts.StatusApproved || r.CreatedAt.Before(since) {
+			continue
+		}
+		activity.Points += db.pointsDB[id].Base()
+		activity.Receipts++
+	}
+	return activity, nil
+}
+
+func (db *Database) SetTier(userID string, tier string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	previous, ok := db.tiers[userID]
+	db.tiers[userID] = tier
+	if err := db.persist(); err != nil {
+		if ok {
+			db.tiers[userID] = previous
+		} else {
+			delete(db.tiers, userID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (db *Database) Ledger(userID string) ([]receipts.LedgerEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.ledger.history(userID), nil
+}
+
+func (db *Database) Post(entry receipts.LedgerEntry) (receipts.LedgerEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	posted := len(db.ledger.entries)
+	entry = db.ledger.post(entry)
+	if err := db.persist(); err != nil {
+		db.ledger.truncate(posted)
+		return receipts.LedgerEntry{}, err
+	}
+	return entry, nil
+}
+
+func (db *Database) CheckLedger() (receipts.LedgerReport, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.ledger.check(db.receiptsDB, db.pointsDB, db.redemptions), nil
+}
+
+func (db *Database) Expire(now time.Time) ([]receipts.LedgerEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	posted := len(db.ledger.entries)
+	var entries []receipts.LedgerEntry
+	for _, userID := range db.ledger.users() {
+		if entry, ok := db.expire(userID, now); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if err := db.persist(); err != nil {
+		db.ledger.truncate(posted)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// expire posts an expiry entry for the points of userID that expired by now
+// and were not posted yet, if there are any. Callers must hold db.mu.
+func (db *Database) expire(userID string, now time.Time) (receipts.LedgerEntry, bool) {
+	expired, _ := receipts.Expirations(db.ledger.history(userID), now)
+	if expired <= 0 {
+		return receipts.LedgerEntry{}, false
+	}
+	return db.ledger.post(receipts.LedgerEntry{
+		UserID: userID,
+		Type:   receipts.LedgerExpiry,
+		Points: -expired,
+		Reason: "points expired",
+		At:     now,
+	}), true
+}
+
+// unexpired limits entry, a debit of the points earned for a receipt, to
+// the part of them that has not expired by the time of the entry, so
+// expired points are not debited twice. It posts the expiries due by then
+// first. Callers must hold db.mu.
+func (db *Database) unexpired(entry receipts.LedgerEntry) receipts.LedgerEntry {
+	if entry.UserID == "" || entry.ReceiptID == "" || entry.Points >= 0 {
+		return entry
+	}
+	db.expire(entry.UserID, entry.At)
+	expired := receipts.ExpiredPoints(db.ledger.history(entry.UserID), entry.ReceiptID, entry.At)
+	entry.Expired = min(expired, -entry.Points)
+	entry.Points += entry.Expired
+	return entry
+}
+
+// postAwarded posts the change in the points awarded for r from before to
+// after, taking the reason, actor and time from the audit entry of the
+// change, and returns the points it took back. A reversal takes back
+// neither expired points nor more than the balance of the user. Callers
+// must hold db.mu.
+func (db *Database) postAwarded(r receipts.Receipt, before int64, after int64, change receipts.AuditEntry) int64 {
+	entry, ok := receipts.LedgerPoints(r, before, after, change.At)
+	if !ok {
+		return 0
+	}
+	entry.Reason = change.Reason
+	entry.Actor = change.Actor
+	entry = db.ledger.post(db.ledger.capped(db.unexpired(entry)))
+	return max(-entry.Points, 0)
+}
+
+// missing returns the error for an id that is not stored, callers must hold db.mu.
+func (db *Database) missing(id string) error {
+	if db.tombstones[id] != nil {
+		return receipts.ErrReceiptGone
+	}
+	return receipts.ErrReceiptNotFound
+}
+
+// index adds r to the lookup indexes, callers must hold db.mu. Voided
+// receipts are left out, so resubmitting one is not a duplicate.
+func (db *Database) index(r *receipts.Receipt) {
+	if r.Fingerprint == "" || r.Status == receipts.StatusVoided {
+		return
+	}
+	if id, ok := db.fingerprints[r.Fingerprint]; ok && !db.receiptsDB[id].CreatedAt.After(r.CreatedAt) {
+		return
+	}
+	db.fingerprints[r.Fingerprint] = r.ID
+}
+
+// reindex rebuilds the index entry of fingerprint after a receipt with it was
+// voided or deleted, callers must hold db.mu.
+func (db *Database) reindex(fingerprint string) {
+	if fingerprint == "" {
+		return
+	}
+	delete(db.fingerprints, fingerprint)
+	for _, r := range db.receiptsDB {
+		if r.Fingerprint == fingerprint {
+			db.index(r)
+		}
+	}
 }
@@ -1,8 +1,13 @@
 package db
 
 import (
+	"encoding/json"
 	"fetch_take_home/internal/receipts"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -73,9 +78,20 @@ func TestDBCreate(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEqual(t, "", createdReceipt.ID)
 
+	assert.False(t, createdReceipt.CreatedAt.IsZero())
+
 	receipt.ID = createdReceipt.ID
+	receipt.CreatedAt = createdReceipt.CreatedAt
 	assert.Equal(t, receipt, createdReceipt)
 
+	storedReceipt, err := db.GetReceipt(receipt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, receipt, storedReceipt.Receipt)
+	assert.Equal(t, receipt.ID, storedReceipt.Points.ID)
+
+	_, err = db.GetReceipt("invalid")
+	assert.Equal(t, receipts.ErrReceiptNotFound, err)
+
 	createdPoints, err := db.GetPoints(receipt.ID)
 	assert.NoError(t, err)
 	assert.NotEqual(t, "", createdPoints.ID)
@@ -83,3 +99,1013 @@ func TestDBCreate(t *testing.T) {
 	points.ID = createdPoints.ID
 	assert.Equal(t, points, createdPoints)
 }
+
+func TestFileDB(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
+	purchaseTime, _ := time.Parse("15:04", "13:01")
+	receipt := receipts.Receipt{
+		ID:           "",
+		Retailer:     "retailer",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items:        []receipts.Item{{ShortDescription: "item", Price: 100}},
+		Total:        100,
+	}
+	path := filepath.Join(t.TempDir(), "data", "receipts.json")
+
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	breakdown := []receipts.PointsDetail{{Rule: "rule", Points: 42, Reason: "reason"}}
+	createdReceipt, err := db.Create(receipt, receipts.Points{Points: 42, Breakdown: breakdown})
+	assert.NoError(t, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	points, err := reopened.GetPoints(createdReceipt.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.Points{ID: createdReceipt.ID, Points: 42, Breakdown: breakdown}, points)
+
+	_, err = reopened.GetPoints("invalid")
+	assert.Equal(t, receipts.ErrReceiptNotFound, err)
+}
+
+func TestFileDBSchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	for _, version := range []string{`{}`, `{"schemaVersion": 99}`} {
+		assert.NoError(t, os.WriteFile(path, []byte(version), 0o644))
+
+		_, err := NewFileDB(path)
+		assert.Error(t, err)
+	}
+}
+
+func TestDBConcurrentAccess(t *testing.T) {
+	db := NewDB()
+	receipt := receipts.Receipt{Retailer: "retailer"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			createdReceipt, err := db.Create(receipt, receipts.Points{Points: 7})
+			assert.NoError(t, err)
+
+			points, err := db.GetPoints(createdReceipt.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(7), points.Points)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDBList(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 5; i++ {
+		purchaseDate := time.Date(2022, 1, i+1, 0, 0, 0, 0, time.UTC)
+		_, err := db.Create(receipts.Receipt{Retailer: "retailer", PurchaseDate: purchaseDate, Status: receipts.StatusApproved}, receipts.Points{Points: int64(i)})
+		assert.NoError(t, err)
+	}
+
+	query := receipts.ReceiptQuery{SortBy: receipts.SortByPoints, Limit: 2}
+	var points []int64
+	for {
+		assert.NoError(t, query.Validate())
+		page, err := db.List(query)
+		assert.NoError(t, err)
+		for _, stored := range page.Receipts {
+			assert.Equal(t, stored.Receipt.ID, stored.Points.ID)
+			points = append(points, stored.Points.Points)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, points)
+}
+
+func TestDBFindByFingerprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+
+	first, err := db.Create(receipts.Receipt{Retailer: "first", Fingerprint: "fingerprint"}, receipts.Points{})
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{Retailer: "second", Fingerprint: "fingerprint"}, receipts.Points{})
+	assert.NoError(t, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		stored, err := db.FindByFingerprint("fingerprint")
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, stored.Receipt.ID)
+
+		_, err = db.FindByFingerprint("unknown")
+		assert.Equal(t, receipts.ErrReceiptNotFound, err)
+	}
+}
+
+func TestDBTransition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+
+	created, err := db.Create(receipts.Receipt{Retailer: "retailer", Status: receipts.StatusPending}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	approve := receipts.AuditEntry{
+		ReceiptID: created.ID,
+		From:      receipts.StatusPending,
+		To:        receipts.StatusApproved,
+		Reason:    "reason",
+		Actor:     "admin",
+		At:        time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC),
+	}
+
+	stored, err := db.Transition(approve)
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.StatusApproved, stored.Receipt.Status)
+	assert.Equal(t, int64(10), stored.Points.Points)
+
+	_, err = db.Transition(approve)
+	assert.ErrorIs(t, err, receipts.ErrReceiptStatusConflict)
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: "invalid"})
+	assert.Equal(t, receipts.ErrReceiptNotFound, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		stored, err := db.GetReceipt(created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, receipts.StatusApproved, stored.Receipt.Status)
+
+		entries, err := db.Audit(created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []receipts.AuditEntry{
+			{ReceiptID: created.ID, To: receipts.StatusPending, Reason: "submitted", At: created.CreatedAt},
+			approve,
+		}, entries)
+
+		_, err = db.Audit("invalid")
+		assert.Equal(t, receipts.ErrReceiptNotFound, err)
+	}
+}
+
+func TestFileDBLegacyStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	legacy := `{"schemaVersion": 1, "receipts": {"1": {"id": "1", "retailer": "retailer"}}, "points": {"1": {"id": "1", "points": 5}}}`
+	assert.NoError(t, os.WriteFile(path, []byte(legacy), 0o644))
+
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	stored, err := db.GetReceipt("1")
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.StatusApproved, stored.Receipt.Status)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var upgraded snapshot
+	assert.NoError(t, json.Unmarshal(data, &upgraded))
+	assert.Equal(t, schemaVersion, upgraded.SchemaVersion)
+	assert.Equal(t, receipts.StatusApproved, upgraded.Receipts["1"].Status)
+	assert.Len(t, upgraded.Ledger, 1)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	entries, err := reopened.Ledger("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFileDBLedgerLegs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	legacy := `{"schemaVersion": 5,
+		"receipts": {"1": {"id": "1", "userId": "user", "status": "approved"}},
+		"points": {"1": {"id": "1", "points": 5}},
+		"ledger": [
+			{"id": "e1", "userId": "user", "receiptId": "1", "type": "earn", "points": 5},
+			{"id": "e2", "userId": "user", "type": "expiry", "points": -2}
+		]}`
+	assert.NoError(t, os.WriteFile(path, []byte(legacy), 0o644))
+
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		entries, err := db.Ledger("user")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		for _, entry := range entries {
+			assert.Equal(t, receipts.Legs(entry), entry.Legs)
+		}
+
+		user, err := db.GetUser("user", time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), user.Balance)
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced)
+		assert.Equal(t, map[string]int64{"user:user": 3, receipts.AccountIssuance: -5, receipts.AccountExpiry: 2}, report.Accounts)
+	}
+}
+
+func TestDBDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+
+	created, err := db.Create(receipts.Receipt{Retailer: "retailer", Status: receipts.StatusApproved}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	entry := receipts.AuditEntry{
+		ReceiptID: created.ID,
+		From:      receipts.StatusApproved,
+		To:        receipts.StatusVoided,
+		Reason:    "fraud",
+		Actor:     "admin",
+		Reversed:  10,
+		At:        time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC),
+	}
+
+	assert.ErrorIs(t, db.Delete(receipts.AuditEntry{ReceiptID: created.ID, From: receipts.StatusPending}), receipts.ErrReceiptStatusConflict)
+	assert.NoError(t, db.Delete(entry))
+	assert.Equal(t, receipts.ErrReceiptGone, db.Delete(entry))
+	assert.Equal(t, receipts.ErrReceiptNotFound, db.Delete(receipts.AuditEntry{ReceiptID: "invalid"}))
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		_, err := db.GetReceipt(created.ID)
+		assert.Equal(t, receipts.ErrReceiptGone, err)
+		_, err = db.GetPoints(created.ID)
+		assert.Equal(t, receipts.ErrReceiptGone, err)
+
+		entries, err := db.Audit(created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, entry, entries[len(entries)-1])
+
+		page, err := db.List(receipts.ReceiptQuery{Limit: 10, SortBy: receipts.SortByPurchaseDate})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Receipts)
+	}
+}
+
+func TestDBAuditReversed(t *testing.T) {
+	db := NewDB()
+	approved, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 5})
+	assert.NoError(t, err)
+	pending, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusPending}, receipts.Points{Points: 7})
+	assert.NoError(t, err)
+
+	// The audit trail records what was taken back, not what was asked for.
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: approved.ID, From: receipts.StatusApproved, To: receipts.StatusVoided})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Delete(receipts.AuditEntry{ReceiptID: pending.ID, From: receipts.StatusPending, To: receipts.StatusVoided, Reversed: 7}))
+
+	for id, reversed := range map[string]int64{approved.ID: 5, pending.ID: 0} {
+		audit, err := db.Audit(id)
+		assert.NoError(t, err)
+		assert.Equal(t, reversed, audit[len(audit)-1].Reversed)
+	}
+}
+
+func TestDBFindByFingerprintVoided(t *testing.T) {
+	db := NewDB()
+	first, err := db.Create(receipts.Receipt{Retailer: "first", Fingerprint: "fingerprint", Status: receipts.StatusApproved}, receipts.Points{})
+	assert.NoError(t, err)
+	second, err := db.Create(receipts.Receipt{Retailer: "second", Fingerprint: "fingerprint", Status: receipts.StatusApproved}, receipts.Points{})
+	assert.NoError(t, err)
+
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: first.ID, From: receipts.StatusApproved, To: receipts.StatusVoided})
+	assert.NoError(t, err)
+	stored, err := db.FindByFingerprint("fingerprint")
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, stored.Receipt.ID)
+
+	assert.NoError(t, db.Delete(receipts.AuditEntry{ReceiptID: second.ID, From: receipts.StatusApproved, To: receipts.StatusVoided}))
+	_, err = db.FindByFingerprint("fingerprint")
+	assert.Equal(t, receipts.ErrReceiptNotFound, err)
+}
+
+func TestDBUserBalance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	balance := func(db receipts.DB) int64 {
+		user, err := db.GetUser("user", time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "user", user.ID)
+		return user.Balance
+	}
+
+	approved, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	pending, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusPending}, receipts.Points{Points: 5})
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{Status: receipts.StatusApproved}, receipts.Points{Points: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), balance(db))
+
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: pending.ID, From: receipts.StatusPending, To: receipts.StatusApproved})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), balance(db))
+
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: approved.ID, From: receipts.StatusApproved, To: receipts.StatusVoided})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), balance(db))
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), balance(reopened))
+
+	assert.NoError(t, db.Delete(receipts.AuditEntry{ReceiptID: pending.ID, From: receipts.StatusApproved, To: receipts.StatusVoided}))
+	assert.Equal(t, int64(0), balance(db))
+
+	unknown, err := db.GetUser("unknown", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.User{ID: "unknown"}, unknown)
+}
+
+func TestDBLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	at := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+
+	approved, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	pending, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusPending}, receipts.Points{Points: 5})
+	assert.NoError(t, err)
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: pending.ID, From: receipts.StatusPending, To: receipts.StatusApproved, Reason: "checked", Actor: "admin", At: at})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Delete(receipts.AuditEntry{ReceiptID: approved.ID, From: receipts.StatusApproved, To: receipts.StatusVoided, Reason: "fraud", Actor: "admin", At: at}))
+	adjustment, err := db.Post(receipts.LedgerEntry{UserID: "user", Type: receipts.LedgerAdjustment, Points: -2, Reason: "goodwill", Actor: "admin", At: at})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, adjustment.ID)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		entries, err := db.Ledger("user")
+		assert.NoError(t, err)
+		assert.Equal(t, []receipts.LedgerLeg{{Account: "user:user", Points: -10}, {Account: receipts.AccountIssuance, Points: 10}}, entries[2].Legs)
+		for i := range entries {
+			entries[i].ID = ""
+			entries[i].Legs = nil
+		}
+		assert.Equal(t, []receipts.LedgerEntry{
+			{UserID: "user", ReceiptID: approved.ID, Type: receipts.LedgerEarn, Points: 10, Reason: "submitted", At: approved.CreatedAt},
+			{UserID: "user", ReceiptID: pending.ID, Type: receipts.LedgerEarn, Points: 5, Reason: "checked", Actor: "admin", At: at},
+			{UserID: "user", ReceiptID: approved.ID, Type: receipts.LedgerReversal, Points: -10, Reason: "fraud", Actor: "admin", At: at},
+			{UserID: "user", Type: receipts.LedgerAdjustment, Points: -2, Reason: "goodwill", Actor: "admin", At: at},
+		}, entries)
+
+		user, err := db.GetUser("user", time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), user.Balance)
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.Equal(t, receipts.LedgerReport{
+			Entries:  4,
+			Total:    3,
+			Accounts: map[string]int64{"user:user": 3, receipts.AccountIssuance: -3},
+			Balanced: true,
+		}, report)
+	}
+}
+
+func TestDBCheckLedger(t *testing.T) {
+	db := newDatabase()
+	created, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+
+	db.pointsDB[created.ID].Points = 12
+	db.ledger.post(receipts.LedgerEntry{UserID: "other", Type: receipts.LedgerAdjustment, Points: 4})
+	db.ledger.entries[0].Legs[1].Points = -9
+	db.ledger.entries[1].Legs[0].Account = receipts.UserAccount("user")
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	entryID, otherID := db.ledger.entries[0].ID, db.ledger.entries[1].ID
+	discrepancies := []receipts.LedgerDiscrepancy{
+		{EntryID: entryID, Expected: 0, Posted: 1},
+		{EntryID: otherID, Account: "user:other", Expected: 4, Posted: 0},
+		{ReceiptID: created.ID, Expected: 12, Posted: 10},
+	}
+	if otherID < entryID {
+		discrepancies[0], discrepancies[1] = discrepancies[1], discrepancies[0]
+	}
+	assert.Equal(t, receipts.LedgerReport{
+		Entries:       2,
+		Total:         14,
+		Accounts:      map[string]int64{"user:user": 14, receipts.AccountIssuance: -13},
+		Balanced:      false,
+		Discrepancies: discrepancies,
+	}, report)
+}
+
+func TestFileDBOpeningBalance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	legacy := `{"schemaVersion": 1,
+		"receipts": {
+			"1": {"id": "1", "userId": "user", "status": "approved"},
+			"2": {"id": "2", "userId": "user", "status": "pending"},
+			"3": {"id": "3", "userId": "user"}
+		},
+		"points": {"1": {"id": "1", "points": 5}, "2": {"id": "2", "points": 7}, "3": {"id": "3", "points": 4}},
+		"users": {"user": {"id": "user", "balance": 9}}}`
+	assert.NoError(t, os.WriteFile(path, []byte(legacy), 0o644))
+
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	user, err := db.GetUser("user", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), user.Balance)
+
+	report, err := db.CheckLedger()
+	assert.NoError(t, err)
+	assert.True(t, report.Balanced)
+	assert.Equal(t, 2, report.Entries)
+}
+
+func TestDBRedeem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	at := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	balance := func(db receipts.DB) int64 {
+		user, err := db.GetUser("user", time.Now())
+		assert.NoError(t, err)
+		return user.Balance
+	}
+
+	_, err = db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	assert.NoError(t, db.SaveReward(receipts.Reward{ID: "mug", Name: "Mug", Cost: 8, Stock: 1}))
+	assert.NoError(t, db.SaveReward(receipts.Reward{ID: "hat", Name: "Hat", Cost: 20, Stock: 5}))
+
+	_, err = db.Redeem("user", "hat", at)
+	assert.ErrorIs(t, err, receipts.ErrInsufficientPoints)
+	_, err = db.Redeem("user", "unknown", at)
+	assert.Equal(t, receipts.ErrRewardNotFound, err)
+
+	redemption, err := db.Redeem("user", "mug", at)
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.Redemption{ID: redemption.ID, UserID: "user", RewardID: "mug", Cost: 8, Status: receipts.RedemptionCompleted, CreatedAt: at}, redemption)
+	assert.Equal(t, int64(2), balance(db))
+	_, err = db.Redeem("user", "mug", at)
+	assert.Equal(t, receipts.ErrRewardOutOfStock, err)
+
+	_, err = db.CancelRedemption("other", redemption.ID, at, at)
+	assert.Equal(t, receipts.ErrRedemptionNotFound, err)
+	_, err = db.CancelRedemption("user", redemption.ID, at.Add(time.Hour), at.Add(time.Minute))
+	assert.Equal(t, receipts.ErrRedemptionFinal, err)
+	assert.Equal(t, int64(2), balance(db))
+	cancelled, err := db.CancelRedemption("user", redemption.ID, at.Add(time.Hour), at)
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.RedemptionCancelled, cancelled.Status)
+	assert.Equal(t, at.Add(time.Hour), *cancelled.CancelledAt)
+	_, err = db.CancelRedemption("user", redemption.ID, at, at)
+	assert.Equal(t, receipts.ErrRedemptionCancelled, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		assert.Equal(t, int64(10), balance(db))
+		rewards, err := db.Rewards()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []receipts.Reward{
+			{ID: "mug", Name: "Mug", Cost: 8, Stock: 1},
+			{ID: "hat", Name: "Hat", Cost: 20, Stock: 5},
+		}, rewards)
+		redemptions, err := db.Redemptions("user")
+		assert.NoError(t, err)
+		assert.Equal(t, []receipts.Redemption{cancelled}, redemptions)
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced, report.Discrepancies)
+		assert.Equal(t, 3, report.Entries)
+	}
+}
+
+func TestDBRedeemConcurrent(t *testing.T) {
+	db := NewDB()
+	_, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	assert.NoError(t, db.SaveReward(receipts.Reward{ID: "sticker", Name: "Sticker", Cost: 1, Stock: 100}))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.Redeem("user", "sticker", time.Now()); err == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	user, err := db.GetUser("user", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, redeemed)
+	assert.Equal(t, int64(0), user.Balance)
+}
+
+func TestDBExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	policy := &receipts.ExpiryPolicy{Version: "v1", Months: 12, Basis: receipts.ExpiryFromPurchase}
+	purchaseDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err = db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved, PurchaseDate: purchaseDate, Expiry: policy}, receipts.Points{Points: 10})
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved, PurchaseDate: purchaseDate}, receipts.Points{Points: 5})
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{UserID: "other", Status: receipts.StatusApproved, PurchaseDate: purchaseDate.AddDate(1, 0, 0), Expiry: policy}, receipts.Points{Points: 7})
+	assert.NoError(t, err)
+
+	entries, err := db.Expire(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries, err = db.Expire(now)
+	assert.NoError(t, err)
+	assert.Equal(t, []receipts.LedgerEntry{
+		{ID: entries[0].ID, UserID: "user", Type: receipts.LedgerExpiry, Points: -10, Reason: "points expired", At: now,
+			Legs: []receipts.LedgerLeg{{Account: "user:user", Points: -10}, {Account: receipts.AccountExpiry, Points: 10}}},
+	}, entries)
+
+	entries, err = db.Expire(now)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		user, err := db.GetUser("user", now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), user.Balance)
+		other, err := db.GetUser("other", now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), other.Balance)
+
+		history, err := db.Ledger("user")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *history[0].ExpiresAt)
+		assert.Equal(t, "v1", history[0].Policy)
+		assert.Nil(t, history[1].ExpiresAt)
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced)
+	}
+
+	// Points that expired are neither reported nor spent before the
+	// expiry job runs.
+	later := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, db.SaveReward(receipts.Reward{ID: "mug", Name: "Mug", Cost: 7, Stock: 1}))
+	_, err = db.Redeem("other", "mug", later)
+	assert.ErrorIs(t, err, receipts.ErrInsufficientPoints)
+	users, err := db.Users(later)
+	assert.NoError(t, err)
+	assert.Equal(t, []receipts.User{{ID: "other", Balance: 0}, {ID: "user", Balance: 5}}, users)
+	other, err := db.GetUser("other", later)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), other.Balance)
+
+	entries, err = db.Expire(later)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	history, err := db.Ledger("other")
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.LedgerExpiry, history[len(history)-1].Type)
+	assert.Equal(t, later, history[len(history)-1].At)
+}
+
+func TestDBTiers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+
+	_, err = db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 30, Tier: "silver", TierBonus: 10})
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusPending}, receipts.Points{Points: 50})
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{UserID: "other", Status: receipts.StatusApproved}, receipts.Points{Points: 5})
+	assert.NoError(t, err)
+
+	activity, err := db.Activity("user", time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.Activity{Points: 20, Receipts: 1}, activity)
+	activity, err = db.Activity("user", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.Activity{}, activity)
+
+	assert.NoError(t, db.SetTier("user", "silver"))
+	assert.NoError(t, db.SetTier("new", "bronze"))
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		users, err := db.Users(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, []receipts.User{
+			{ID: "new", Tier: "bronze"},
+			{ID: "other", Balance: 5},
+			{ID: "user", Balance: 30, Tier: "silver"},
+		}, users)
+	}
+}
+
+func TestDBCampaigns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	campaign := receipts.Campaign{
+		ID:       "gatorade",
+		Name:     "Gatorade March",
+		Item:     "gatorade",
+		StartsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Bonus:    200,
+	}
+	assert.NoError(t, db.SaveCampaign(campaign))
+	assert.NoError(t, db.SaveCampaign(receipts.Campaign{ID: "ended", Name: "Ended", Bonus: 5}))
+	assert.NoError(t, db.DeleteCampaign("ended"))
+	assert.ErrorIs(t, db.DeleteCampaign("ended"), receipts.ErrCampaignNotFound)
+
+	points := receipts.Points{Points: 228, Breakdown: []receipts.PointsDetail{
+		{Rule: "retailer_name", Points: 28},
+		{Rule: "campaign", Campaign: "gatorade", Points: 200},
+	}}
+	_, err = db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, points)
+	assert.NoError(t, err)
+	_, err = db.Create(receipts.Receipt{UserID: "other", Status: receipts.StatusPending}, points)
+	assert.NoError(t, err)
+	rejected, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusPending}, points)
+	assert.NoError(t, err)
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: rejected.ID, From: receipts.StatusPending, To: receipts.StatusRejected})
+	assert.NoError(t, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		campaigns, err := db.Campaigns()
+		assert.NoError(t, err)
+		assert.Equal(t, []receipts.Campaign{campaign}, campaigns)
+
+		usage, err := db.CampaignUsage("user")
+		assert.NoError(t, err)
+		assert.Equal(t, receipts.CampaignUsage{
+			Spent: map[string]int64{"gatorade": 400},
+			User:  map[string]int64{"gatorade": 200},
+		}, usage)
+	}
+}
+
+func TestDBRuleSets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	v2 := receipts.RuleSet{Version: "v2", Rules: []receipts.Rule{{Name: "retailer_name", Type: receipts.RuleAlphanumericCount, Points: 2}}}
+	assert.NoError(t, db.SaveRuleSet(receipts.DefaultRuleSet()))
+	assert.NoError(t, db.SaveRuleSet(v2))
+	assert.NoError(t, db.SaveRuleSet(v2))
+	assert.ErrorIs(t, db.SaveRuleSet(receipts.RuleSet{Version: "v2"}), receipts.ErrRuleSetConflict)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		ruleSets, err := db.RuleSets()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []receipts.RuleSet{receipts.DefaultRuleSet(), v2}, ruleSets)
+	}
+}
+
+func TestDBRecompute(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	at := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+
+	approved, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10, RuleVersion: "v1"})
+	assert.NoError(t, err)
+	pending, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusPending}, receipts.Points{Points: 5, RuleVersion: "v1"})
+	assert.NoError(t, err)
+	diff := func(r receipts.Receipt, before int64, after int64, delta int64) receipts.RecomputeDiff {
+		return receipts.RecomputeDiff{
+			ReceiptID: r.ID,
+			UserID:    r.UserID,
+			Status:    r.Status,
+			Before:    receipts.Points{ID: r.ID, Points: before, RuleVersion: "v1"},
+			After:     receipts.Points{ID: r.ID, Points: after, RuleVersion: "v2"},
+			Delta:     delta,
+		}
+	}
+	rc := receipts.Recomputation{
+		ID:      "rc",
+		Request: receipts.RecomputeRequest{Version: "v2"},
+		Status:  receipts.RecomputationPreview,
+		Diffs:   []receipts.RecomputeDiff{diff(approved, 10, 14, 4), diff(pending, 5, 8, 0)},
+	}
+	assert.NoError(t, db.SaveRecomputation(rc))
+	_, err = db.Recomputation("missing")
+	assert.ErrorIs(t, err, receipts.ErrRecomputationNotFound)
+
+	stale := rc
+	stale.ID = "stale"
+	stale.Diffs = []receipts.RecomputeDiff{diff(approved, 9, 14, 5)}
+	assert.NoError(t, db.SaveRecomputation(stale))
+	_, err = db.CommitRecomputation("stale", "admin", at)
+	assert.ErrorIs(t, err, receipts.ErrRecomputationStale)
+
+	committed, err := db.CommitRecomputation("rc", "admin", at)
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.RecomputationCommitted, committed.Status)
+	assert.Equal(t, &at, committed.CommittedAt)
+	_, err = db.CommitRecomputation("rc", "admin", at)
+	assert.ErrorIs(t, err, receipts.ErrRecomputationCommitted)
+
+	_, err = db.Transition(receipts.AuditEntry{ReceiptID: pending.ID, From: receipts.StatusPending, To: receipts.StatusApproved, Reason: "checked", Actor: "admin", At: at})
+	assert.NoError(t, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		points, err := db.GetPoints(approved.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, receipts.Points{ID: approved.ID, Points: 14, RuleVersion: "v2"}, points)
+
+		rc, err := db.Recomputation("rc")
+		assert.NoError(t, err)
+		assert.Equal(t, committed, rc)
+
+		entries, err := db.Ledger("user")
+		assert.NoError(t, err)
+		for i := range entries {
+			entries[i].ID = ""
+			entries[i].Legs = nil
+		}
+		assert.Equal(t, []receipts.LedgerEntry{
+			{UserID: "user", ReceiptID: approved.ID, Type: receipts.LedgerEarn, Points: 10, Reason: "submitted", At: approved.CreatedAt},
+			{UserID: "user", ReceiptID: approved.ID, Type: receipts.LedgerRecompute, Points: 4, Reason: "recomputed under rules v2", Actor: "admin", At: at},
+			{UserID: "user", ReceiptID: pending.ID, Type: receipts.LedgerEarn, Points: 8, Reason: "checked", Actor: "admin", At: at},
+		}, entries)
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced)
+		assert.Equal(t, int64(22), report.Total)
+	}
+}
+
+func TestDBJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	created := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	at := created.Add(time.Minute)
+
+	first := receipts.Job{ID: "first", Status: receipts.JobQueued, Receipt: receipts.Receipt{Retailer: "Target"}, CreatedAt: created}
+	second := receipts.Job{ID: "second", Status: receipts.JobQueued, CreatedAt: created.Add(time.Second)}
+	assert.NoError(t, db.EnqueueJob(first, 2))
+	assert.NoError(t, db.EnqueueJob(second, 2))
+	assert.ErrorIs(t, db.EnqueueJob(receipts.Job{ID: "third"}, 2), receipts.ErrJobQueueFull)
+	_, err = db.Job("third")
+	assert.ErrorIs(t, err, receipts.ErrJobNotFound)
+
+	claimed, err := db.ClaimJob(at)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", claimed.ID)
+	assert.Equal(t, receipts.JobRunning, claimed.Status)
+	assert.Equal(t, &at, claimed.StartedAt)
+	assert.Equal(t, 1, claimed.Attempts)
+	assert.Equal(t, first.Receipt, claimed.Receipt)
+
+	assert.NoError(t, db.EnqueueJob(receipts.Job{ID: "third", Status: receipts.JobQueued, CreatedAt: created.Add(2 * time.Second)}, 2))
+	assert.ErrorIs(t, db.SaveJob(receipts.Job{ID: "missing"}), receipts.ErrJobNotFound)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	var order []string
+	for {
+		job, err := reopened.ClaimJob(at)
+		if err != nil {
+			assert.ErrorIs(t, err, receipts.ErrJobNotFound)
+			break
+		}
+		order = append(order, job.ID)
+		if job.ID == "first" {
+			assert.Equal(t, 2, job.Attempts)
+		}
+	}
+	assert.Equal(t, []string{"first", "second", "third"}, order)
+
+	finished := claimed
+	finished.Status = receipts.JobSucceeded
+	finished.ReceiptID = "receipt"
+	finished.FinishedAt = &at
+	assert.NoError(t, db.SaveJob(finished))
+	job, err := db.Job("first")
+	assert.NoError(t, err)
+	assert.Equal(t, finished, job)
+
+	claimed, err = db.ClaimJob(at)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", claimed.ID)
+	stored, err := db.Create(receipts.Receipt{JobID: "second"}, receipts.Points{Points: 5})
+	assert.NoError(t, err)
+
+	reopened, err = NewFileDB(path)
+	assert.NoError(t, err)
+	job, err = reopened.Job("second")
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.JobSucceeded, job.Status)
+	assert.Equal(t, stored.ID, job.ReceiptID)
+	assert.Equal(t, stored.CreatedAt, *job.FinishedAt)
+	claimed, err = reopened.ClaimJob(at)
+	assert.NoError(t, err)
+	assert.Equal(t, "third", claimed.ID)
+
+	pruned, err := reopened.PruneJobs(stored.CreatedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	pruned, err = reopened.PruneJobs(stored.CreatedAt.Add(time.Nanosecond))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	for id, err := range map[string]error{"first": receipts.ErrJobNotFound, "second": receipts.ErrJobNotFound, "third": nil} {
+		_, got := reopened.Job(id)
+		assert.ErrorIs(t, got, err, id)
+	}
+}
+
+func TestDBReversalOverdraft(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	at := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+
+	earned, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 10, RuleVersion: "v1"})
+	assert.NoError(t, err)
+	kept, err := db.Create(receipts.Receipt{UserID: "user", Status: receipts.StatusApproved}, receipts.Points{Points: 4, RuleVersion: "v1"})
+	assert.NoError(t, err)
+	assert.NoError(t, db.SaveReward(receipts.Reward{ID: "mug", Name: "Mug", Cost: 12, Stock: 1}))
+	_, err = db.Redeem("user", "mug", at)
+	assert.NoError(t, err)
+
+	voided, err := db.Transition(receipts.AuditEntry{ReceiptID: earned.ID, From: receipts.StatusApproved, To: receipts.StatusVoided, Reversed: 10, At: at})
+	assert.NoError(t, err)
+	assert.Equal(t, receipts.StatusVoided, voided.Receipt.Status)
+
+	assert.NoError(t, db.SaveRecomputation(receipts.Recomputation{
+		ID:      "rc",
+		Request: receipts.RecomputeRequest{Version: "v2"},
+		Status:  receipts.RecomputationPreview,
+		Diffs: []receipts.RecomputeDiff{{
+			ReceiptID: kept.ID,
+			UserID:    "user",
+			Status:    receipts.StatusApproved,
+			Before:    receipts.Points{ID: kept.ID, Points: 4, RuleVersion: "v1"},
+			After:     receipts.Points{ID: kept.ID, Points: 1, RuleVersion: "v2"},
+			Delta:     -3,
+		}},
+	}))
+	_, err = db.CommitRecomputation("rc", "admin", at)
+	assert.NoError(t, err)
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		user, err := db.GetUser("user", time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), user.Balance)
+
+		entries, err := db.Ledger("user")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 5)
+		assert.Equal(t, receipts.LedgerReversal, entries[3].Type)
+		assert.Equal(t, int64(-2), entries[3].Points)
+		assert.Equal(t, int64(8), entries[3].Shortfall)
+		assert.Equal(t, receipts.LedgerRecompute, entries[4].Type)
+		assert.Equal(t, int64(0), entries[4].Points)
+		assert.Equal(t, int64(3), entries[4].Shortfall)
+
+		audit, err := db.Audit(earned.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), audit[1].Reversed)
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced, report.Discrepancies)
+	}
+}
+
+func TestDBReversalAfterExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.json")
+	db, err := NewFileDB(path)
+	assert.NoError(t, err)
+	policy := &receipts.ExpiryPolicy{Version: "v1", Months: 12, Basis: receipts.ExpiryFromPurchase}
+	purchaseDate := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiredAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	create := func(userID string, expiry *receipts.ExpiryPolicy, points int64) receipts.Receipt {
+		created, err := db.Create(receipts.Receipt{UserID: userID, Status: receipts.StatusApproved, PurchaseDate: purchaseDate, Expiry: expiry}, receipts.Points{Points: points})
+		assert.NoError(t, err)
+		return created
+	}
+
+	// The points of posted expired and were debited before the void, spent
+	// had spent some of them first, and the expiry of pending was not posted
+	// by the time of the void.
+	voided := []receipts.Receipt{create("posted", policy, 10), create("spent", policy, 10)}
+	create("posted", nil, 5)
+	create("spent", nil, 5)
+	assert.NoError(t, db.SaveReward(receipts.Reward{ID: "mug", Name: "Mug", Cost: 4, Stock: 1}))
+	_, err = db.Redeem("spent", "mug", expiredAt.AddDate(0, -1, 0))
+	assert.NoError(t, err)
+	_, err = db.Expire(expiredAt)
+	assert.NoError(t, err)
+	voided = append(voided, create("pending", policy, 10))
+	create("pending", nil, 5)
+
+	for _, r := range voided {
+		_, err := db.Transition(receipts.AuditEntry{ReceiptID: r.ID, From: receipts.StatusApproved, To: receipts.StatusVoided, Reversed: 10, At: at})
+		assert.NoError(t, err)
+	}
+
+	reopened, err := NewFileDB(path)
+	assert.NoError(t, err)
+	for _, db := range []receipts.DB{db, reopened} {
+		for userID, expected := range map[string]struct {
+			balance  int64
+			reversed int64
+			expired  int64
+		}{
+			"posted":  {balance: 5, expired: 10},
+			"spent":   {balance: 1, reversed: 4, expired: 6},
+			"pending": {balance: 5, expired: 10},
+		} {
+			user, err := db.GetUser(userID, time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, expected.balance, user.Balance, userID)
+
+			history, err := db.Ledger(userID)
+			assert.NoError(t, err)
+			reversal := history[len(history)-1]
+			assert.Equal(t, receipts.LedgerReversal, reversal.Type, userID)
+			assert.Equal(t, -expected.reversed, reversal.Points, userID)
+			assert.Equal(t, expected.expired, reversal.Expired, userID)
+			assert.Equal(t, receipts.LedgerExpiry, history[len(history)-2].Type, userID)
+		}
+
+		report, err := db.CheckLedger()
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced, report.Discrepancies)
+	}
+
+	expired, err := db.Expire(at)
+	assert.NoError(t, err)
+	assert.Empty(t, expired)
+}
+
+// BenchmarkFileDBCreate measures a write against file databases of growing
+// size, every write re-encodes and syncs the whole file.
+func BenchmarkFileDBCreate(b *testing.B) {
+	receipt := receipts.Receipt{
+		Retailer:     "retailer",
+		PurchaseDate: time.Now(),
+		PurchaseTime: time.Now(),
+		Items:        []receipts.Item{{ShortDescription: "item", Price: 125}},
+		Total:        125,
+		UserID:       "user",
+	}
+	points := receipts.Points{Points: 10}
+
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("receipts=%d", size), func(b *testing.B) {
+			db := newDatabase()
+			for range size {
+				_, err := db.Create(receipt, points)
+				assert.NoError(b, err)
+			}
+			db.path = filepath.Join(b.TempDir(), "receipts.json")
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := db.Create(receipt, points); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"encoding/json"
+	"fetch_take_home/internal/receipts"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// schemaVersion is bumped whenever the layout of snapshot changes, together
+// with a migration from the previous version in migrations.
+const schemaVersion = 6
+
+// migrations upgrade a database restored from a snapshot of the version they
+// are keyed by to the next version. Callers must own db exclusively.
+var migrations = map[int]func(db *Database, s snapshot){
+	// Version 1 files were written before the version was bumped with the
+	// layout, so they may be from any release up to then: receipts stored
+	// before they had a status were awarded their points straight away, and
+	// databases stored before the ledger carry their balances over as
+	// opening entries.
+	1: func(db *Database, s snapshot) {
+		for _, r := range db.receiptsDB {
+			if r.Status == "" {
+				r.Status = receipts.StatusApproved
+			}
+		}
+		if s.Ledger == nil {
+			db.openLedger()
+		}
+	},
+	// Version 3 added the shortfall of ledger entries, which older entries never had.
+	2: func(db *Database, s snapshot) {},
+	// Version 4 added the expired points of ledger entries, which older entries never had.
+	3: func(db *Database, s snapshot) {},
+	// Version 5 added the job of receipts, which older receipts were not
+	// stored with. Their jobs were finished after the receipt was stored.
+	4: func(db *Database, s snapshot) {},
+	// Version 6 added the legs of ledger entries, older entries are given the
+	// legs they would have been posted with.
+	5: func(db *Database, s snapshot) {
+		entries := db.ledger.entries
+		for i := range entries {
+			entries[i].Legs = receipts.Legs(entries[i])
+		}
+		db.ledger = newLedger(entries)
+	},
+}
+
+// snapshot is the on-disk layout of a file backed Database.
+type snapshot struct {
+	SchemaVersion  int                                `json:"schemaVersion"`
+	Receipts       map[string]*receipts.Receipt       `json:"receipts"`
+	Points         map[string]*receipts.Points        `json:"points"`
+	Audit          map[string][]receipts.AuditEntry   `json:"audit"`
+	Tombstones     map[string]*receipts.Tombstone     `json:"tombstones"`
+	Ledger         []receipts.LedgerEntry             `json:"ledger"`
+	Rewards        map[string]*receipts.Reward        `json:"rewards"`
+	Redemptions    map[string]*receipts.Redemption    `json:"redemptions"`
+	Tiers          map[string]string                  `json:"tiers"`
+	Campaigns      map[string]*receipts.Campaign      `json:"campaigns"`
+	RuleSets       map[string]*receipts.RuleSet       `json:"ruleSets"`
+	Recomputations map[string]*receipts.Recomputation `json:"recomputations"`
+	Jobs           map[string]*receipts.Job           `json:"jobs"`
+}
+
+// NewFileDB opens the database stored at path, creating the file and its
+// directory if they do not exist yet. Every write is flushed to disk before
+// it is acknowledged, so stored receipts survive restarts and crashes.
+func NewFileDB(path string) (receipts.DB, error) {
+	db := newDatabase()
+	db.path = path
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create database directory: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := db.persist(); err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read database file: %w", err)
+	}
+
+	// Collections missing from older files decode as empty.
+	s := db.snapshot()
+	s.SchemaVersion = 0
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("decode database file: %w", err)
+	}
+	if s.SchemaVersion < 1 || s.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("unsupported database schema version %d", s.SchemaVersion)
+	}
+	db.restore(s)
+	if s.SchemaVersion < schemaVersion {
+		for version := s.SchemaVersion; version < schemaVersion; version++ {
+			migrations[version](db, s)
+		}
+		// Writes the upgraded database, so it is only migrated once.
+		if err := db.persist(); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range db.receiptsDB {
+		db.index(r)
+	}
+	db.requeueJobs()
+	return db, nil
+}
+
+// snapshot returns the current state in its on-disk layout, callers must hold db.mu.
+func (db *Database) snapshot() snapshot {
+	return snapshot{
+		SchemaVersion:  schemaVersion,
+		Receipts:       db.receiptsDB,
+		Points:         db.pointsDB,
+		Audit:          db.audit,
+		Tombstones:     db.tombstones,
+		Ledger:         db.ledger.entries,
+		Rewards:        db.rewards,
+		Redemptions:    db.redemptions,
+		Tiers:          db.tiers,
+		Campaigns:      db.campaigns,
+		RuleSets:       db.ruleSets,
+		Recomputations: db.recomputations,
+		Jobs:           db.jobs,
+	}
+}
+
+// restore replaces the state with s, callers must own db exclusively.
+func (db *Database) restore(s snapshot) {
+	db.receiptsDB = s.Receipts
+	db.pointsDB = s.Points
+	db.audit = s.Audit
+	db.tombstones = s.Tombstones
+	db.ledger = newLedger(s.Ledger)
+	db.rewards = s.Rewards
+	db.redemptions = s.Redemptions
+	db.tiers = s.Tiers
+	db.campaigns = s.Campaigns
+	db.ruleSets = s.RuleSets
+	db.recomputations = s.Recomputations
+	db.jobs = s.Jobs
+}
+
+// openLedger posts an earn entry for every approved receipt of a database
+// stored before the ledger, so the balances carry over. Callers must hold
+// db.mu or own db exclusively.
+func (db *Database) openLedger() {
+	ids := make([]string, 0, len(db.receiptsDB))
+	for id := range db.receiptsDB {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return db.receiptsDB[ids[i]].CreatedAt.Before(db.receiptsDB[ids[j]].CreatedAt)
+	})
+	for _, id := range ids {
+		r := db.receiptsDB[id]
+		awarded := receipts.StoredReceipt{Receipt: *r, Points: *db.pointsDB[id]}.Awarded()
+		db.postAwarded(*r, 0, awarded, receipts.AuditEntry{Reason: "opening balance", At: r.CreatedAt})
+	}
+}
+
+// persist writes the current state to db.path, callers must hold db.mu.
+// The snapshot is written to a temporary file and renamed over the old one
+// so a crash never leaves a partially written database behind, and the
+// directory is synced so the rename itself survives a power failure.
+// Every write re-encodes the whole database, so its cost grows with the
+// number of receipts, see BenchmarkFileDBCreate.
+func (db *Database) persist() error {
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(db.snapshot())
+	if err != nil {
+		return fmt.Errorf("encode database file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write database file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write database file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write database file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write database file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), db.path); err != nil {
+		return fmt.Errorf("write database file: %w", err)
+	}
+
+	dir, err := os.Open(filepath.Dir(db.path))
+	if err != nil {
+		return fmt.Errorf("sync database directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("sync database directory: %w", err)
+	}
+	return nil
+}
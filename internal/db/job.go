@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fetch_take_home/internal/receipts"
+	"sort"
+	"time"
+)
+
+func (db *Database) EnqueueJob(job receipts.Job, capacity int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.queue) >= capacity {
+		return receipts.ErrJobQueueFull
+	}
+	db.jobs[job.ID] = &job
+	db.queue = append(db.queue, job.ID)
+	if err := db.persist(); err != nil {
+		delete(db.jobs, job.ID)
+		db.queue = db.queue[:len(db.queue)-1]
+		return err
+	}
+	return nil
+}
+
+func (db *Database) Job(id string) (receipts.Job, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	job := db.jobs[id]
+	if job == nil {
+		return receipts.Job{}, receipts.ErrJobNotFound
+	}
+	return *job, nil
+}
+
+func (db *Database) ClaimJob(at time.Time) (receipts.Job, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.queue) == 0 {
+		return receipts.Job{}, receipts.ErrJobNotFound
+	}
+	queued := db.jobs[db.queue[0]]
+	claimed := *queued
+	claimed.Status = receipts.JobRunning
+	claimed.StartedAt = &at
+	claimed.Attempts++
+	db.jobs[claimed.ID] = &claimed
+	db.queue = db.queue[1:]
+	if err := db.persist(); err != nil {
+		db.jobs[claimed.ID] = queued
+		db.queue = append([]string{claimed.ID}, db.queue...)
+		return receipts.Job{}, err
+	}
+	return claimed, nil
+}
+
+func (db *Database) SaveJob(job receipts.Job) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	previous := db.jobs[job.ID]
+	if previous == nil {
+		return receipts.ErrJobNotFound
+	}
+	db.jobs[job.ID] = &job
+	if err := db.persist(); err != nil {
+		db.jobs[job.ID] = previous
+		return err
+	}
+	return nil
+}
+
+func (db *Database) PruneJobs(before time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pruned := make(map[string]*receipts.Job)
+	for id, job := range db.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(before) {
+			pruned[id] = job
+			delete(db.jobs, id)
+		}
+	}
+	if len(pruned) == 0 {
+		return 0, nil
+	}
+	if err := db.persist(); err != nil {
+		for id, job := range pruned {
+			db.jobs[id] = job
+		}
+		return 0, err
+	}
+	return len(pruned), nil
+}
+
+// requeueJobs rebuilds the queue of a database opened from its snapshot,
+// oldest first. Jobs that were running when it was written were
+// interrupted, so they are queued again. Callers must hold db.mu.
+func (db *Database) requeueJobs() {
+	db.queue = db.queue[:0]
+	for id, job := range db.jobs {
+		if job.Status == receipts.JobRunning {
+			job.Status = receipts.JobQueued
+		}
+		if job.Status == receipts.JobQueued {
+			db.queue = append(db.queue, id)
+		}
+	}
+	sort.Slice(db.queue, func(i, j int) bool {
+		a, b := db.jobs[db.queue[i]], db.jobs[db.queue[j]]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
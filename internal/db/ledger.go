@@ -0,0 +1,197 @@
+package db
+
+import (
+	"fetch_take_home/internal/receipts"
+	"github.com/google/uuid"
+	"sort"
+)
+
+// ledger is the append-only record of points transactions kept beside the
+// receipts of a Database. Every entry moves points between two accounts, the
+// balance of every user is the balance of their account.
+// It is guarded by the lock of the Database it belongs to.
+type ledger struct {
+	entries  []receipts.LedgerEntry
+	accounts map[string]int64
+}
+
+// newLedger returns a ledger holding entries, with the account balances their legs add up to.
+func newLedger(entries []receipts.LedgerEntry) *ledger {
+	l := &ledger{accounts: make(map[string]int64)}
+	for _, entry := range entries {
+		l.append(entry)
+	}
+	return l
+}
+
+// post appends entry with a new ID and its legs, and returns it.
+func (l *ledger) post(entry receipts.LedgerEntry) receipts.LedgerEntry {
+	entry.ID = uuid.NewString()
+	entry.Legs = receipts.Legs(entry)
+	l.append(entry)
+	return entry
+}
+
+func (l *ledger) append(entry receipts.LedgerEntry) {
+	l.entries = append(l.entries, entry)
+	for _, leg := range entry.Legs {
+		l.accounts[leg.Account] += leg.Points
+	}
+}
+
+// balance returns the balance of userID.
+func (l *ledger) balance(userID string) int64 {
+	return l.accounts[receipts.UserAccount(userID)]
+}
+
+// capped limits a debit of a user to their balance, so taking back points
+// that were already spent never overdraws it. What could not be debited is
+// recorded as the shortfall of the entry.
+func (l *ledger) capped(entry receipts.LedgerEntry) receipts.LedgerEntry {
+	if entry.UserID == "" || entry.Points >= 0 {
+		return entry
+	}
+	balance := max(l.balance(entry.UserID), 0)
+	if -entry.Points > balance {
+		entry.Shortfall = -entry.Points - balance
+		entry.Points = -balance
+	}
+	return entry
+}
+
+// truncate drops the entries posted after the first n, undoing writes that
+// could not be persisted. Persisted entries are never removed.
+func (l *ledger) truncate(n int) {
+	for _, entry := range l.entries[n:] {
+		for _, leg := range entry.Legs {
+			l.accounts[leg.Account] -= leg.Points
+		}
+	}
+	l.entries = l.entries[:n:n]
+}
+
+// history returns the entries of userID, oldest first.
+func (l *ledger) history(userID string) []receipts.LedgerEntry {
+	var entries []receipts.LedgerEntry
+	for _, entry := range l.entries {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// users returns the ids of every user with ledger entries.
+func (l *ledger) users() []string {
+	var users []string
+	for account := range l.accounts {
+		if userID, ok := receipts.AccountUser(account); ok {
+			users = append(users, userID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// check verifies that the legs of every entry add up to zero and move its
+// points into the account of its user, and compares the earn, reversal and
+// recompute entries of every receipt, less their shortfalls and expired
+// points, with the points it awards, missing receipts award none, and the
+// redemption and refund entries of every redemption with the points it debits.
+func (l *ledger) check(receiptsDB map[string]*receipts.Receipt, pointsDB map[string]*receipts.Points,
+	redemptions map[string]*receipts.Redemption) receipts.LedgerReport {
+	report := receipts.LedgerReport{Entries: len(l.entries), Accounts: make(map[string]int64)}
+	posted := make(map[string]int64)
+	spent := make(map[string]int64)
+	for _, entry := range l.entries {
+		report.Total += entry.Points
+		if entry.ReceiptID != "" && (entry.Type == receipts.LedgerEarn || entry.Type == receipts.LedgerReversal ||
+			entry.Type == receipts.LedgerRecompute) {
+			posted[entry.ReceiptID] += entry.Points - entry.Shortfall - entry.Expired
+		}
+		if entry.RedemptionID != "" && (entry.Type == receipts.LedgerRedemption || entry.Type == receipts.LedgerRefund) {
+			spent[entry.RedemptionID] += entry.Points
+		}
+
+		account := receipts.UserAccount(entry.UserID)
+		var net, credited int64
+		for _, leg := range entry.Legs {
+			report.Accounts[leg.Account] += leg.Points
+			net += leg.Points
+			if leg.Account == account {
+				credited += leg.Points
+			}
+		}
+		if net != 0 {
+			report.Discrepancies = append(report.Discrepancies, receipts.LedgerDiscrepancy{
+				EntryID:  entry.ID,
+				Expected: 0,
+				Posted:   net,
+			})
+		}
+		if credited != entry.Points {
+			report.Discrepancies = append(report.Discrepancies, receipts.LedgerDiscrepancy{
+				EntryID:  entry.ID,
+				Account:  account,
+				Expected: entry.Points,
+				Posted:   credited,
+			})
+		}
+	}
+
+	expected := make(map[string]int64)
+	for id, r := range receiptsDB {
+		expected[id] = receipts.StoredReceipt{Receipt: *r, Points: *pointsDB[id]}.Awarded()
+	}
+	for id := range posted {
+		if _, ok := expected[id]; !ok {
+			expected[id] = 0
+		}
+	}
+	for id, points := range expected {
+		if posted[id] != points {
+			report.Discrepancies = append(report.Discrepancies, receipts.LedgerDiscrepancy{
+				ReceiptID: id,
+				Expected:  points,
+				Posted:    posted[id],
+			})
+		}
+	}
+	debited := make(map[string]int64)
+	for id, redemption := range redemptions {
+		if redemption.Status == receipts.RedemptionCompleted {
+			debited[id] = -redemption.Cost
+		} else {
+			debited[id] = 0
+		}
+	}
+	for id := range spent {
+		if _, ok := debited[id]; !ok {
+			debited[id] = 0
+		}
+	}
+	for id, points := range debited {
+		if spent[id] != points {
+			report.Discrepancies = append(report.Discrepancies, receipts.LedgerDiscrepancy{
+				RedemptionID: id,
+				Expected:     points,
+				Posted:       spent[id],
+			})
+		}
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		a, b := report.Discrepancies[i], report.Discrepancies[j]
+		if a.ReceiptID != b.ReceiptID {
+			return a.ReceiptID < b.ReceiptID
+		}
+		if a.RedemptionID != b.RedemptionID {
+			return a.RedemptionID < b.RedemptionID
+		}
+		if a.EntryID != b.EntryID {
+			return a.EntryID < b.EntryID
+		}
+		return a.Account < b.Account
+	})
+	report.Balanced = len(report.Discrepancies) == 0
+	return report
+}
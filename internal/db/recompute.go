@@ -0,0 +1,113 @@
+package db
+
+import (
+	"fetch_take_home/internal/receipts"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+func (db *Database) RuleSets() ([]receipts.RuleSet, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ruleSets := make([]receipts.RuleSet, 0, len(db.ruleSets))
+	for _, rules := range db.ruleSets {
+		ruleSets = append(ruleSets, *rules)
+	}
+	return ruleSets, nil
+}
+
+func (db *Database) SaveRuleSet(rules receipts.RuleSet) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if existing := db.ruleSets[rules.Version]; existing != nil {
+		if !reflect.DeepEqual(existing.Rules, rules.Rules) {
+			return fmt.Errorf("%w: %s", receipts.ErrRuleSetConflict, rules.Version)
+		}
+		return nil
+	}
+	db.ruleSets[rules.Version] = &rules
+	if err := db.persist(); err != nil {
+		delete(db.ruleSets, rules.Version)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) Recomputation(id string) (receipts.Recomputation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rc := db.recomputations[id]
+	if rc == nil {
+		return receipts.Recomputation{}, receipts.ErrRecomputationNotFound
+	}
+	return *rc, nil
+}
+
+func (db *Database) SaveRecomputation(rc receipts.Recomputation) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.recomputations[rc.ID] = &rc
+	if err := db.persist(); err != nil {
+		delete(db.recomputations, rc.ID)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) CommitRecomputation(id string, actor string, at time.Time) (receipts.Recomputation, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current := db.recomputations[id]
+	if current == nil {
+		return receipts.Recomputation{}, receipts.ErrRecomputationNotFound
+	}
+	if current.Status == receipts.RecomputationCommitted {
+		return receipts.Recomputation{}, receipts.ErrRecomputationCommitted
+	}
+	for _, diff := range current.Diffs {
+		r, points := db.receiptsDB[diff.ReceiptID], db.pointsDB[diff.ReceiptID]
+		if r == nil || r.Status != diff.Status || points.Points != diff.Before.Points ||
+			points.RuleVersion != diff.Before.RuleVersion {
+			return receipts.Recomputation{}, fmt.Errorf("%w: receipt %s", receipts.ErrRecomputationStale, diff.ReceiptID)
+		}
+	}
+
+	previous := make(map[string]*receipts.Points, len(current.Diffs))
+	posted := len(db.ledger.entries)
+	for _, diff := range current.Diffs {
+		previous[diff.ReceiptID] = db.pointsDB[diff.ReceiptID]
+		after := diff.After
+		db.pointsDB[diff.ReceiptID] = &after
+		if diff.Delta != 0 {
+			db.ledger.post(db.ledger.capped(db.unexpired(receipts.LedgerEntry{
+				UserID:    diff.UserID,
+				ReceiptID: diff.ReceiptID,
+				Type:      receipts.LedgerRecompute,
+				Points:    diff.Delta,
+				Reason:    fmt.Sprintf("recomputed under rules %s", current.Request.Version),
+				Actor:     actor,
+				At:        at,
+			})))
+		}
+	}
+	committed := *current
+	committed.Status = receipts.RecomputationCommitted
+	committed.CommittedAt = &at
+	committed.Actor = actor
+	db.recomputations[id] = &committed
+	if err := db.persist(); err != nil {
+		for receiptID, points := range previous {
+			db.pointsDB[receiptID] = points
+		}
+		db.ledger.truncate(posted)
+		db.recomputations[id] = current
+		return receipts.Recomputation{}, err
+	}
+	return committed, nil
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"fetch_take_home/internal/receipts"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+func (db *Database) Rewards() ([]receipts.Reward, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rewards := make([]receipts.Reward, 0, len(db.rewards))
+	for _, reward := range db.rewards {
+		rewards = append(rewards, *reward)
+	}
+	return rewards, nil
+}
+
+func (db *Database) SaveReward(reward receipts.Reward) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	previous := db.rewards[reward.ID]
+	db.rewards[reward.ID] = &reward
+	if err := db.persist(); err != nil {
+		if previous == nil {
+			delete(db.rewards, reward.ID)
+		} else {
+			db.rewards[reward.ID] = previous
+		}
+		return err
+	}
+	return nil
+}
+
+func (db *Database) Redeem(userID string, rewardID string, at time.Time) (receipts.Redemption, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	reward := db.rewards[rewardID]
+	if reward == nil {
+		return receipts.Redemption{}, receipts.ErrRewardNotFound
+	}
+	if reward.Stock <= 0 {
+		return receipts.Redemption{}, receipts.ErrRewardOutOfStock
+	}
+	posted := len(db.ledger.entries)
+	db.expire(userID, at)
+	if balance := db.ledger.balance(userID); balance < reward.Cost {
+		db.ledger.truncate(posted)
+		return receipts.Redemption{}, fmt.Errorf("%w: the reward costs %d points but the balance is %d",
+			receipts.ErrInsufficientPoints, reward.Cost, balance)
+	}
+
+	redemption := receipts.Redemption{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		RewardID:  rewardID,
+		Cost:      reward.Cost,
+		Status:    receipts.RedemptionCompleted,
+		CreatedAt: at,
+	}
+	db.ledger.post(receipts.LedgerEntry{
+		UserID:       userID,
+		RedemptionID: redemption.ID,
+		Type:         receipts.LedgerRedemption,
+		Points:       -reward.Cost,
+		Reason:       reward.Name,
+		Actor:        userID,
+		At:           at,
+	})
+	reward.Stock--
+	db.redemptions[redemption.ID] = &redemption
+	if err := db.persist(); err != nil {
+		db.ledger.truncate(posted)
+		reward.Stock++
+		delete(db.redemptions, redemption.ID)
+		return receipts.Redemption{}, err
+	}
+	return redemption, nil
+}
+
+func (db *Database) Redemptions(userID string) ([]receipts.Redemption, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var redemptions []receipts.Redemption
+	for _, redemption := range db.redemptions {
+		if redemption.UserID == userID {
+			redemptions = append(redemptions, *redemption)
+		}
+	}
+	return redemptions, nil
+}
+
+func (db *Database) CancelRedemption(userID string, id string, at time.Time, redeemedSince time.Time) (receipts.Redemption, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current := db.redemptions[id]
+	if current == nil || current.UserID != userID {
+		return receipts.Redemption{}, receipts.ErrRedemptionNotFound
+	}
+	if current.Status == receipts.RedemptionCancelled {
+		return receipts.Redemption{}, receipts.ErrRedemptionCancelled
+	}
+	if current.CreatedAt.Before(redeemedSince) {
+		return receipts.Redemption{}, receipts.ErrRedemptionFinal
+	}
+
+	cancelled := *current
+	cancelled.Status = receipts.RedemptionCancelled
+	cancelled.CancelledAt = &at
+	posted := len(db.ledger.entries)
+	db.ledger.post(receipts.LedgerEntry{
+		UserID:       userID,
+		RedemptionID: id,
+		Type:         receipts.LedgerRefund,
+		Points:       current.Cost,
+		Reason:       "redemption cancelled",
+		Actor:        userID,
+		At:           at,
+	})
+	db.redemptions[id] = &cancelled
+	// Rewards removed from the catalog since are not restocked.
+	if reward := db.rewards[current.RewardID]; reward != nil {
+		reward.Stock++
+	}
+	if err := db.persist(); err != nil {
+		db.ledger.truncate(posted)
+		db.redemptions[id] = current
+		if reward := db.rewards[current.RewardID]; reward != nil {
+			reward.Stock--
+		}
+		return receipts.Redemption{}, err
+	}
+	return cancelled, nil
+}
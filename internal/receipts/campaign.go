@@ -0,0 +1,249 @@
+package receipts
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Campaign is a time-boxed promotion awarding points on top of the rules to
+// the receipts it matches.
+// ID: The ID of the campaign, chosen by the admin who creates it.
+// Name: Describes the campaign in the points breakdown.
+// Retailer: Matches receipts whose retailer name contains it, ignoring case. Empty matches every retailer.
+// Item: Matches receipts with an item whose description contains it, ignoring case. Empty matches every receipt.
+// StartsAt: The first purchase time the campaign applies to.
+// EndsAt: The purchase time the campaign ends at, not included.
+// Multiplier: Factor applied to the points of the rules, 0 for none.
+// Bonus: Flat points added to every matching receipt.
+// UserCap: The most points the campaign awards a user, 0 for no cap.
+// Budget: The most points the campaign awards in total, 0 for no budget.
+type Campaign struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Retailer   string    `json:"retailer,omitempty"`
+	Item       string    `json:"item,omitempty"`
+	StartsAt   time.Time `json:"startsAt"`
+	EndsAt     time.Time `json:"endsAt"`
+	Multiplier float64   `json:"multiplier,omitempty"`
+	Bonus      int64     `json:"bonus,omitempty"`
+	UserCap    int64     `json:"userCap,omitempty"`
+	Budget     int64     `json:"budget,omitempty"`
+}
+
+// CampaignUsage
+// Spent: The points each campaign awarded to receipts that were not rejected or voided, by campaign ID.
+// User: The part of Spent awarded to one user.
+type CampaignUsage struct {
+	Spent map[string]int64
+	User  map[string]int64
+}
+
+// CampaignSummary
+// Campaign: The campaign.
+// Spent: The points it awarded to receipts that were not rejected or voided.
+type CampaignSummary struct {
+	Campaign Campaign `json:"campaign"`
+	Spent    int64    `json:"spent"`
+}
+
+// campaignRule is the rule name of the breakdown lines added by campaigns.
+const campaignRule = "campaign"
+
+// purchasedAt combines the purchase date and time printed on a receipt.
+func purchasedAt(receipt Receipt) time.Time {
+	d, t := receipt.PurchaseDate, receipt.PurchaseTime
+	return time.Date(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// matches reports whether the campaign applies to a receipt.
+func (c Campaign) matches(receipt Receipt) bool {
+	at := purchasedAt(receipt)
+	if at.Before(c.StartsAt) || !at.Before(c.EndsAt) {
+		return false
+	}
+	if c.Retailer != "" && !strings.Contains(strings.ToLower(receipt.Retailer), strings.ToLower(c.Retailer)) {
+		return false
+	}
+	if c.Item == "" {
+		return true
+	}
+	for _, item := range receipt.Items {
+		if strings.Contains(strings.ToLower(item.ShortDescription), strings.ToLower(c.Item)) {
+			return true
+		}
+	}
+	return false
+}
+
+// award returns the points the campaign adds to base points of the rules
+// before caps, and why.
+func (c Campaign) award(base int64) (int64, string) {
+	var points int64
+	var parts []string
+	if c.Multiplier > 1 && base > 0 {
+		points += int64(math.Round(float64(base) * (c.Multiplier - 1)))
+		parts = append(parts, fmt.Sprintf("%sx %d points", strconv.FormatFloat(c.Multiplier, 'f', -1, 64), base))
+	}
+	if c.Bonus > 0 {
+		points += c.Bonus
+		parts = append(parts, fmt.Sprintf("%d bonus points", c.Bonus))
+	}
+	return points, fmt.Sprintf("%s campaign: %s", c.Name, strings.Join(parts, ", "))
+}
+
+// applyCampaigns adds the points of every campaign matching a receipt to the
+// points of the rules, within the cap of the user and the budget of the
+// campaign, and records them in the breakdown. Multipliers apply to the
+// points of the rules, not to those of other campaigns.
+func applyCampaigns(campaigns []Campaign, usage CampaignUsage, receipt Receipt, points Points) Points {
+	base := points.Points
+	for _, c := range campaigns {
+		if !c.matches(receipt) {
+			continue
+		}
+		awarded, reason := c.award(base)
+		if c.UserCap > 0 && receipt.UserID != "" && awarded > c.UserCap-usage.User[c.ID] {
+			awarded = max(c.UserCap-usage.User[c.ID], 0)
+			reason += fmt.Sprintf(", capped at %d points per user", c.UserCap)
+		}
+		if c.Budget > 0 && awarded > c.Budget-usage.Spent[c.ID] {
+			awarded = max(c.Budget-usage.Spent[c.ID], 0)
+			reason += fmt.Sprintf(", capped by the budget of %d points", c.Budget)
+		}
+		if awarded <= 0 {
+			continue
+		}
+		points.Points += awarded
+		points.Breakdown = append(points.Breakdown[:len(points.Breakdown):len(points.Breakdown)], PointsDetail{
+			Rule:     campaignRule,
+			Campaign: c.ID,
+			Points:   awarded,
+			Reason:   reason,
+		})
+	}
+	return points
+}
+
+// addCampaigns adds the points of the campaigns running at the purchase
+// time of a receipt, callers must hold createMu so caps are not overspent.
+func (r *receipt) addCampaigns(receipt Receipt, points Points) (Points, error) {
+	campaigns, err := r.db.Campaigns()
+	if err != nil {
+		log.WithError(err).Error("Failed to list campaigns")
+		return Points{}, err
+	}
+	if len(campaigns) == 0 {
+		return points, nil
+	}
+	usage, err := r.db.CampaignUsage(receipt.UserID)
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve campaign usage")
+		return Points{}, err
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].ID < campaigns[j].ID
+	})
+	return applyCampaigns(campaigns, usage, receipt, points), nil
+}
+
+// CampaignPoints returns the points campaigns awarded to a receipt, by campaign ID.
+func CampaignPoints(p Points) map[string]int64 {
+	var awarded map[string]int64
+	for _, detail := range p.Breakdown {
+		if detail.Rule != campaignRule {
+			continue
+		}
+		if awarded == nil {
+			awarded = make(map[string]int64)
+		}
+		awarded[detail.Campaign] += detail.Points
+	}
+	return awarded
+}
+
+// Campaigns returns every campaign with the points it awarded, those that
+// start first first.
+func (r *receipt) Campaigns() ([]CampaignSummary, error) {
+	campaigns, err := r.db.Campaigns()
+	if err != nil {
+		log.WithError(err).Error("Failed to list campaigns")
+		return nil, err
+	}
+	usage, err := r.db.CampaignUsage("")
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve campaign usage")
+		return nil, err
+	}
+
+	sort.SliceStable(campaigns, func(i, j int) bool {
+		if !campaigns[i].StartsAt.Equal(campaigns[j].StartsAt) {
+			return campaigns[i].StartsAt.Before(campaigns[j].StartsAt)
+		}
+		return campaigns[i].ID < campaigns[j].ID
+	})
+	summaries := make([]CampaignSummary, 0, len(campaigns))
+	for _, c := range campaigns {
+		summaries = append(summaries, CampaignSummary{Campaign: c, Spent: usage.Spent[c.ID]})
+	}
+	return summaries, nil
+}
+
+// Campaign returns a campaign with the points it awarded.
+func (r *receipt) Campaign(id string) (CampaignSummary, error) {
+	campaigns, err := r.Campaigns()
+	if err != nil {
+		return CampaignSummary{}, err
+	}
+	for _, summary := range campaigns {
+		if summary.Campaign.ID == id {
+			return summary, nil
+		}
+	}
+	return CampaignSummary{}, ErrCampaignNotFound
+}
+
+// SaveCampaign creates a campaign or replaces the one with its ID. Points
+// already awarded count towards the caps of the replacement.
+func (r *receipt) SaveCampaign(c Campaign) (Campaign, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Retailer = strings.TrimSpace(c.Retailer)
+	c.Item = strings.TrimSpace(c.Item)
+	c.StartsAt = c.StartsAt.UTC()
+	c.EndsAt = c.EndsAt.UTC()
+	switch {
+	case c.Name == "":
+		return Campaign{}, fmt.Errorf("%w: a name is required", ErrCampaignInvalid)
+	case !c.EndsAt.After(c.StartsAt):
+		return Campaign{}, fmt.Errorf("%w: endsAt must be after startsAt", ErrCampaignInvalid)
+	case c.Multiplier < 0 || c.Bonus < 0 || c.UserCap < 0 || c.Budget < 0:
+		return Campaign{}, fmt.Errorf("%w: multiplier, bonus, userCap and budget must not be negative", ErrCampaignInvalid)
+	case c.Multiplier > 0 && c.Multiplier < 1:
+		return Campaign{}, fmt.Errorf("%w: multiplier must be at least 1", ErrCampaignInvalid)
+	case c.Multiplier <= 1 && c.Bonus == 0:
+		return Campaign{}, fmt.Errorf("%w: a multiplier above 1 or a bonus is required", ErrCampaignInvalid)
+	}
+
+	if err := r.db.SaveCampaign(c); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"campaignID": c.ID,
+		}).Error("Failed to save campaign")
+		return Campaign{}, err
+	}
+	return c, nil
+}
+
+// DeleteCampaign ends a campaign. Receipts keep the points it awarded.
+func (r *receipt) DeleteCampaign(id string) error {
+	if err := r.db.DeleteCampaign(id); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"campaignID": id,
+		}).Warn("Failed to delete campaign")
+		return err
+	}
+	return nil
+}
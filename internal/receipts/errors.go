@@ -2,9 +2,68 @@ package receipts
 
 import (
 	"errors"
+	apperrors "fetch_take_home/errors"
+	"fmt"
 )
 
 var (
-	ErrReceiptNotFound = errors.New("No receipt found for that id")
-	ErrReceiptInvalid  = errors.New("The receipt is invalid")
+	ErrReceiptNotFound  = errors.New("No receipt found for that id")
+	ErrReceiptInvalid   = errors.New("The receipt is invalid")
+	ErrQueryInvalid     = errors.New("The query is invalid")
+	ErrReceiptDuplicate = errors.New("The receipt was already processed")
+	// ErrReceiptTotalMismatch is returned under ReconcileReject when the total does not add up.
+	ErrReceiptTotalMismatch = errors.New("The receipt total does not match its items")
+	// ErrReceiptPending is returned for the points of a receipt that is held for review.
+	ErrReceiptPending = errors.New("The receipt is pending review")
+	// ErrReceiptRejected is returned for the points of a receipt that was rejected in review.
+	ErrReceiptRejected = errors.New("The receipt was rejected in review")
+	// ErrReceiptStatusConflict is returned for a status change the receipt is not in a state for.
+	ErrReceiptStatusConflict = errors.New("The receipt status does not allow this change")
+	ErrReviewInvalid         = errors.New("The review is invalid")
+	// ErrReceiptGone is returned for a receipt that was voided or deleted.
+	ErrReceiptGone = errors.New("The receipt was voided or deleted")
+	// ErrAdjustmentInvalid is returned for a manual points adjustment without points or a reason.
+	ErrAdjustmentInvalid = errors.New("The adjustment is invalid")
+	ErrRewardNotFound    = errors.New("No reward found for that id")
+	ErrRewardInvalid     = errors.New("The reward is invalid")
+	// ErrRewardOutOfStock is returned for a redemption of a reward with no stock left.
+	ErrRewardOutOfStock = errors.New("The reward is out of stock")
+	// ErrInsufficientPoints is returned for a redemption costing more than the balance of the user.
+	ErrInsufficientPoints = errors.New("The balance is too low for this reward")
+	ErrRedemptionNotFound = errors.New("No redemption found for that id")
+	// ErrRedemptionCancelled is returned for cancelling a redemption that was already cancelled.
+	ErrRedemptionCancelled = errors.New("The redemption was already cancelled")
+	// ErrRedemptionFinal is returned for cancelling a redemption after its cancellation window.
+	ErrRedemptionFinal  = errors.New("The redemption can no longer be cancelled")
+	ErrCampaignNotFound = errors.New("No campaign found for that id")
+	ErrCampaignInvalid  = errors.New("The campaign is invalid")
+	ErrRuleSetNotFound  = errors.New("No rule set found for that version")
+	ErrRuleSetInvalid   = errors.New("The rule set is invalid")
+	// ErrRuleSetConflict is returned for saving different rules under a version that is already taken.
+	ErrRuleSetConflict       = errors.New("The rule set version already has different rules")
+	ErrRecomputationNotFound = errors.New("No recomputation found for that id")
+	ErrRecomputationInvalid  = errors.New("The recomputation is invalid")
+	// ErrRecomputationCommitted is returned for committing a recomputation twice.
+	ErrRecomputationCommitted = errors.New("The recomputation was already committed")
+	// ErrRecomputationStale is returned for committing a recomputation after its receipts changed.
+	ErrRecomputationStale = errors.New("The receipts changed since the recomputation was previewed")
+	ErrJobNotFound        = errors.New("No job found for that id")
+	// ErrJobQueueFull is returned for a receipt submitted while too many jobs are queued.
+	ErrJobQueueFull = errors.New("Too many receipts are waiting to be processed")
+	// ErrJobStatusConflict is returned for finishing a job that is not running.
+	ErrJobStatusConflict = errors.New("The job is not running")
 )
+
+// ValidationError lists every problem found in a submitted receipt.
+// It matches ErrReceiptInvalid with errors.Is.
+type ValidationError struct {
+	Fields []apperrors.FieldError
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %d invalid fields", ErrReceiptInvalid, len(v.Fields))
+}
+
+func (v *ValidationError) Is(target error) bool {
+	return target == ErrReceiptInvalid
+}
@@ -0,0 +1,186 @@
+package receipts
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// ExpiryBasis is the date the expiry period of points is counted from.
+type ExpiryBasis string
+
+const (
+	// ExpiryFromPurchase counts from the purchase date printed on the receipt.
+	ExpiryFromPurchase ExpiryBasis = "purchase"
+	// ExpiryFromAward counts from when the points were awarded.
+	ExpiryFromAward ExpiryBasis = "award"
+)
+
+// ParseExpiryBasis returns the ExpiryBasis named s.
+func ParseExpiryBasis(s string) (ExpiryBasis, error) {
+	switch basis := ExpiryBasis(s); basis {
+	case ExpiryFromPurchase, ExpiryFromAward:
+		return basis, nil
+	default:
+		return "", fmt.Errorf("unknown expiry basis %q", s)
+	}
+}
+
+// ExpiryPolicy decides when the points of a receipt expire. Receipts keep the
+// policy they were submitted under, so changing it only affects new receipts.
+// Version: Names the policy in the ledger, derived from Months and Basis if empty.
+// Months: How long points last, 0 for points that never expire.
+// Basis: The date the months are counted from.
+type ExpiryPolicy struct {
+	Version string      `json:"version"`
+	Months  int         `json:"months"`
+	Basis   ExpiryBasis `json:"basis"`
+}
+
+// NewExpiryPolicy returns the policy expiring points months after basis.
+func NewExpiryPolicy(version string, months int, basis ExpiryBasis) (ExpiryPolicy, error) {
+	if months < 0 {
+		return ExpiryPolicy{}, fmt.Errorf("expiry months must not be negative, got %d", months)
+	}
+	if version == "" {
+		version = fmt.Sprintf("%dm-%s", months, basis)
+	}
+	return ExpiryPolicy{Version: version, Months: months, Basis: basis}, nil
+}
+
+// ExpiresAt returns when points awarded at awardedAt for a purchase on
+// purchaseDate expire, or nil if they never do.
+func (p ExpiryPolicy) ExpiresAt(purchaseDate time.Time, awardedAt time.Time) *time.Time {
+	if p.Months == 0 {
+		return nil
+	}
+	from := purchaseDate
+	if p.Basis == ExpiryFromAward {
+		from = awardedAt
+	}
+	expiresAt := from.AddDate(0, p.Months, 0).UTC()
+	return &expiresAt
+}
+
+// Expiration
+// Points: The points that expire.
+// ExpiresAt: When they expire.
+type Expiration struct {
+	Points    int64     `json:"points"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// pointsLot is a credit that is spent or expires as a whole.
+type pointsLot struct {
+	points    int64
+	expiresAt *time.Time
+}
+
+// spendLots builds the lots credited by the ledger entries of a user and
+// spends their debits on them. Debits spend the points that expire first,
+// credits that never expire last. Reversals and recomputations change the
+// points of the receipt they are for. Expiry entries are left out unless
+// withExpiry is set. It returns the lots soonest to expire first, and the
+// lot earned for each receipt.
+func spendLots(entries []LedgerEntry, withExpiry bool) ([]*pointsLot, map[string]*pointsLot) {
+	var lots []*pointsLot
+	earned := make(map[string]*pointsLot)
+	var debits int64
+	for _, entry := range entries {
+		switch {
+		case entry.Type == LedgerExpiry && !withExpiry:
+		case entry.Type == LedgerEarn:
+			lot := &pointsLot{points: entry.Points, expiresAt: entry.ExpiresAt}
+			lots = append(lots, lot)
+			if entry.ReceiptID != "" {
+				earned[entry.ReceiptID] = lot
+			}
+		case (entry.Type == LedgerReversal || entry.Type == LedgerRecompute) && earned[entry.ReceiptID] != nil:
+			earned[entry.ReceiptID].points += entry.Points
+		case entry.Type == LedgerRefund, entry.Points < 0:
+			debits -= entry.Points
+		default:
+			lots = append(lots, &pointsLot{points: entry.Points})
+		}
+	}
+
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i].expiresAt, lots[j].expiresAt
+		return a != nil && (b == nil || a.Before(*b))
+	})
+	for _, lot := range lots {
+		spent := min(lot.points, max(debits, 0))
+		lot.points -= spent
+		debits -= spent
+	}
+	return lots, earned
+}
+
+// Expirations works out which points of a user have expired by now and which
+// will, from their ledger entries.
+// expired is what has expired and was not posted as an expiry entry yet,
+// upcoming what is still to expire, soonest first.
+func Expirations(entries []LedgerEntry, now time.Time) (expired int64, upcoming []Expiration) {
+	lots, _ := spendLots(entries, true)
+	for _, lot := range lots {
+		if lot.points <= 0 || lot.expiresAt == nil {
+			continue
+		}
+		if !lot.expiresAt.After(now) {
+			expired += lot.points
+			continue
+		}
+		if n := len(upcoming); n > 0 && upcoming[n-1].ExpiresAt.Equal(*lot.expiresAt) {
+			upcoming[n-1].Points += lot.points
+			continue
+		}
+		upcoming = append(upcoming, Expiration{Points: lot.points, ExpiresAt: *lot.expiresAt})
+	}
+	return expired, upcoming
+}
+
+// ExpiredPoints returns how many of the points earned for receiptID had
+// expired by now, from the ledger entries of its user: what is left of them
+// past their expiry once everything but expiries was spent.
+func ExpiredPoints(entries []LedgerEntry, receiptID string, now time.Time) int64 {
+	_, earned := spendLots(entries, false)
+	lot := earned[receiptID]
+	if lot == nil || lot.expiresAt == nil || lot.expiresAt.After(now) {
+		return 0
+	}
+	return max(lot.points, 0)
+}
+
+// Expirations returns the points of a user that are still to expire, soonest first.
+func (r *receipt) Expirations(userID string) ([]Expiration, error) {
+	entries, err := r.db.Ledger(userID)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"userID": userID,
+		}).Error("Failed to retrieve ledger")
+		return nil, err
+	}
+	_, upcoming := Expirations(entries, time.Now().UTC())
+	return upcoming, nil
+}
+
+// ExpirePoints posts an expiry entry for every user with points that expired.
+func (r *receipt) ExpirePoints() ([]LedgerEntry, error) {
+	entries, err := r.db.Expire(time.Now().UTC())
+	if err != nil {
+		log.WithError(err).Error("Failed to expire points")
+		return nil, err
+	}
+	if len(entries) > 0 {
+		var points int64
+		for _, entry := range entries {
+			points -= entry.Points
+		}
+		log.WithFields(log.Fields{
+			"users":  len(entries),
+			"points": points,
+		}).Info("Points expired")
+	}
+	return entries, nil
+}
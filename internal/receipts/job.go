@@ -0,0 +1,153 @@
+package receipts
+
+import (
+	apperrors "fetch_take_home/errors"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// DefaultJobQueueSize is the most jobs waiting to be processed at once.
+const DefaultJobQueueSize = 1000
+
+// DefaultJobRetention is how long finished jobs are kept for their status to be looked up.
+const DefaultJobRetention = 24 * time.Hour
+
+// JobStatus is the state of a job.
+type JobStatus string
+
+const (
+	// JobQueued is a job waiting for a worker.
+	JobQueued JobStatus = "queued"
+	// JobRunning is a job a worker is processing.
+	JobRunning JobStatus = "running"
+	// JobSucceeded is a job whose receipt was processed.
+	JobSucceeded JobStatus = "succeeded"
+	// JobFailed is a job whose receipt could not be processed.
+	JobFailed JobStatus = "failed"
+)
+
+// Job is a receipt submitted to be processed in the background.
+// ID: The ID of the job.
+// Status: queued, running, succeeded or failed.
+// Receipt: The receipt to process, validated when it was submitted.
+// ReceiptID: The ID of the processed receipt, once it succeeded.
+// Error: Why the receipt could not be processed, once it failed.
+// Attempts: How many times a worker started on the job, more than 1 if a restart interrupted it.
+// CreatedAt: When it was submitted.
+// StartedAt: When a worker last started on it.
+// FinishedAt: When it succeeded or failed.
+type Job struct {
+	ID         string              `json:"id"`
+	Status     JobStatus           `json:"status"`
+	Receipt    Receipt             `json:"receipt"`
+	ReceiptID  string              `json:"receiptId,omitempty"`
+	Error      *apperrors.AppError `json:"error,omitempty"`
+	Attempts   int                 `json:"attempts"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	StartedAt  *time.Time          `json:"startedAt,omitempty"`
+	FinishedAt *time.Time          `json:"finishedAt,omitempty"`
+}
+
+// WithJobQueueSize sets the most jobs waiting to be processed before
+// Enqueue returns ErrJobQueueFull, instead of DefaultJobQueueSize.
+func WithJobQueueSize(size int) Option {
+	return func(r *receipt) {
+		r.jobQueueSize = size
+	}
+}
+
+// WithJobRetention sets how long finished jobs are kept before PruneJobs
+// removes them, instead of DefaultJobRetention.
+func WithJobRetention(retention time.Duration) Option {
+	return func(r *receipt) {
+		r.jobRetention = retention
+	}
+}
+
+// Enqueue stores a receipt to be processed by a worker, or returns
+// ErrJobQueueFull if too many are waiting already.
+func (r *receipt) Enqueue(receipt Receipt) (Job, error) {
+	job := Job{
+		ID:        uuid.NewString(),
+		Status:    JobQueued,
+		Receipt:   receipt,
+		CreatedAt: time.Now().UTC(),
+	}
+	job.Receipt.JobID = job.ID
+	if err := r.db.EnqueueJob(job, r.jobQueueSize); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"retailer": receipt.Retailer,
+		}).Warn("Failed to queue receipt")
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Job returns a job and its result.
+func (r *receipt) Job(id string) (Job, error) {
+	job, err := r.db.Job(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ID": id,
+		}).Warn("Failed to retrieve job")
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimJob marks the oldest queued job running and returns it, or returns
+// ErrJobNotFound if none is queued.
+func (r *receipt) ClaimJob() (Job, error) {
+	return r.db.ClaimJob(time.Now().UTC())
+}
+
+// FinishJob records the receipt a job stored, or the problem that kept it
+// from being stored. Storing the receipt of a job already finished it.
+func (r *receipt) FinishJob(id string, receiptID string, problem *apperrors.AppError) (Job, error) {
+	job, err := r.db.Job(id)
+	if err != nil {
+		return Job{}, err
+	}
+	if job.Status == JobSucceeded && problem == nil && receiptID != "" && job.ReceiptID == receiptID {
+		return job, nil
+	}
+	if job.Status != JobRunning {
+		return Job{}, ErrJobStatusConflict
+	}
+
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Status, job.ReceiptID = JobSucceeded, receiptID
+	if problem != nil {
+		job.Status, job.Error = JobFailed, problem
+	}
+	if err := r.db.SaveJob(job); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID": id,
+		}).Error("Failed to save job")
+		return Job{}, err
+	}
+	log.WithFields(log.Fields{
+		"ID":        id,
+		"status":    job.Status,
+		"receiptID": receiptID,
+		"attempts":  job.Attempts,
+	}).Info("Job finished")
+	return job, nil
+}
+
+// PruneJobs removes the jobs that finished longer ago than the retention.
+func (r *receipt) PruneJobs() (int, error) {
+	pruned, err := r.db.PruneJobs(time.Now().UTC().Add(-r.jobRetention))
+	if err != nil {
+		log.WithError(err).Error("Failed to prune jobs")
+		return 0, err
+	}
+	if pruned > 0 {
+		log.WithFields(log.Fields{
+			"jobs": pruned,
+		}).Info("Jobs pruned")
+	}
+	return pruned, nil
+}
@@ -0,0 +1,22 @@
+package receipts
+
+import (
+	"context"
+	"time"
+)
+
+// Every calls job straight away and then every interval until ctx is done,
+// for background jobs such as ExpirePoints and RecalculateTiers. The service
+// logs their errors, and the job is retried on the next tick.
+func Every(ctx context.Context, interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package receipts
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// LedgerEntryType is the kind of a points transaction.
+type LedgerEntryType string
+
+const (
+	// LedgerEarn credits the points of a receipt when it is approved.
+	LedgerEarn LedgerEntryType = "earn"
+	// LedgerReversal debits the points of an approved receipt that was voided or deleted.
+	LedgerReversal LedgerEntryType = "reversal"
+	// LedgerAdjustment is a manual correction by an admin, in either direction.
+	LedgerAdjustment LedgerEntryType = "adjustment"
+	// LedgerRedemption debits the points spent on a reward.
+	LedgerRedemption LedgerEntryType = "redemption"
+	// LedgerRefund credits the points of a cancelled redemption.
+	LedgerRefund LedgerEntryType = "refund"
+	// LedgerExpiry debits points that expired unspent.
+	LedgerExpiry LedgerEntryType = "expiry"
+	// LedgerRecompute corrects the points of an approved receipt recomputed
+	// under another rule set, in either direction.
+	LedgerRecompute LedgerEntryType = "recompute"
+)
+
+// Ledger accounts the points of users move between, besides the account of
+// every user.
+const (
+	// AccountIssuance is where the points earned on receipts come from, and
+	// where reversed, recomputed and adjusted points go back to.
+	AccountIssuance = "issuance"
+	// AccountRedemptions receives the points spent on rewards and returns refunds.
+	AccountRedemptions = "redemptions"
+	// AccountExpiry receives the points that expired unspent.
+	AccountExpiry = "expiry"
+	// AccountAnonymous holds the points of receipts submitted without a user.
+	AccountAnonymous = "anonymous"
+)
+
+// userAccountPrefix starts the account of every user, followed by their id.
+const userAccountPrefix = "user:"
+
+// UserAccount returns the account holding the balance of userID, the
+// anonymous account when there is no user.
+func UserAccount(userID string) string {
+	if userID == "" {
+		return AccountAnonymous
+	}
+	return userAccountPrefix + userID
+}
+
+// AccountUser returns the user whose balance account holds, if it is the
+// account of a user.
+func AccountUser(account string) (string, bool) {
+	return strings.CutPrefix(account, userAccountPrefix)
+}
+
+// LedgerLeg is one side of a ledger entry.
+// Account: The account the points move into, or out of when negative.
+// Points: The change in the balance of the account.
+type LedgerLeg struct {
+	Account string `json:"account"`
+	Points  int64  `json:"points"`
+}
+
+// Legs returns the legs that post entry: its points move into the account of
+// its user out of the account its type is drawn from, so they add up to zero.
+func Legs(entry LedgerEntry) []LedgerLeg {
+	contra := AccountIssuance
+	switch entry.Type {
+	case LedgerRedemption, LedgerRefund:
+		contra = AccountRedemptions
+	case LedgerExpiry:
+		contra = AccountExpiry
+	}
+	return []LedgerLeg{
+		{Account: UserAccount(entry.UserID), Points: entry.Points},
+		{Account: contra, Points: -entry.Points},
+	}
+}
+
+// LedgerEntry is a points transaction. Entries are only ever appended, a
+// mistake is corrected by another entry.
+// ID: The ID of the entry.
+// UserID: The user whose balance the entry changes, empty for anonymous receipts.
+// ReceiptID: The receipt the points were earned or reversed for, if any.
+// RedemptionID: The redemption the points were spent or refunded for, if any.
+// Type: The kind of transaction.
+// Points: The change in balance, negative for debits.
+// Reason: Why the points changed.
+// Actor: Who changed the points.
+// At: When the entry was posted.
+// ExpiresAt: When the points of an earn entry expire, nil if they never do.
+// Policy: The version of the expiry policy ExpiresAt was set by.
+// Shortfall: The points a reversal or recompute entry could not debit
+// because they were already spent, the balance never goes below zero.
+// Expired: The points a reversal or recompute entry did not debit because
+// they had expired, and were debited by an expiry entry instead.
+// Legs: The accounts the points move between, see Legs.
+type LedgerEntry struct {
+	ID           string          `json:"id"`
+	UserID       string          `json:"userId,omitempty"`
+	ReceiptID    string          `json:"receiptId,omitempty"`
+	RedemptionID string          `json:"redemptionId,omitempty"`
+	Type         LedgerEntryType `json:"type"`
+	Points       int64           `json:"points"`
+	Reason       string          `json:"reason,omitempty"`
+	Actor        string          `json:"actor,omitempty"`
+	At           time.Time       `json:"at"`
+	ExpiresAt    *time.Time      `json:"expiresAt,omitempty"`
+	Policy       string          `json:"policy,omitempty"`
+	Shortfall    int64           `json:"shortfall,omitempty"`
+	Expired      int64           `json:"expired,omitempty"`
+	Legs         []LedgerLeg     `json:"legs"`
+}
+
+// UserLedger
+// User: The user and their balance.
+// Entries: The ledger entries of the user, oldest first.
+type UserLedger struct {
+	User    User
+	Entries []LedgerEntry
+}
+
+// LedgerDiscrepancy
+// ReceiptID: The receipt whose earn and reversal entries, with their shortfalls and expired points, do not add up to its points, if any.
+// RedemptionID: The redemption whose redemption and refund entries do not add up to its cost, if any.
+// EntryID: The entry whose legs do not add up to zero, or whose leg in Account does not match its points, if any.
+// Account: The account of the user of the entry, when its leg there does not match its points.
+// Expected: The points awarded for the receipt, the points debited for the redemption, 0 for the legs of the entry, or its points.
+// Posted: The sum of the entries of the receipt, less their shortfalls and expired points, or of the redemption, or of the legs of the entry, or its leg in Account.
+type LedgerDiscrepancy struct {
+	ReceiptID    string `json:"receiptId,omitempty"`
+	RedemptionID string `json:"redemptionId,omitempty"`
+	EntryID      string `json:"entryId,omitempty"`
+	Account      string `json:"account,omitempty"`
+	Expected     int64  `json:"expected"`
+	Posted       int64  `json:"posted"`
+}
+
+// LedgerReport
+// Entries: The number of entries checked.
+// Total: The sum of every entry.
+// Accounts: The balance of every account after the legs of every entry, they add up to zero.
+// Balanced: Whether no discrepancies were found.
+// Discrepancies: What does not add up.
+type LedgerReport struct {
+	Entries       int                 `json:"entries"`
+	Total         int64               `json:"total"`
+	Accounts      map[string]int64    `json:"accounts"`
+	Balanced      bool                `json:"balanced"`
+	Discrepancies []LedgerDiscrepancy `json:"discrepancies,omitempty"`
+}
+
+// LedgerPoints returns the entry that posts for receipt, when the points it
+// awards change from before to after at time at, and whether there is a
+// change. Earned points expire under the expiry policy of the receipt. The
+// entry has no ID yet.
+func LedgerPoints(receipt Receipt, before int64, after int64, at time.Time) (LedgerEntry, bool) {
+	delta := after - before
+	if delta == 0 {
+		return LedgerEntry{}, false
+	}
+	entry := LedgerEntry{
+		UserID:    receipt.UserID,
+		ReceiptID: receipt.ID,
+		Type:      LedgerReversal,
+		Points:    delta,
+		At:        at,
+	}
+	if delta > 0 {
+		entry.Type = LedgerEarn
+		if receipt.Expiry != nil {
+			entry.ExpiresAt = receipt.Expiry.ExpiresAt(receipt.PurchaseDate, at)
+			entry.Policy = receipt.Expiry.Version
+		}
+	}
+	return entry, true
+}
+
+// Ledger returns the balance and points transactions of a user.
+func (r *receipt) Ledger(userID string) (UserLedger, error) {
+	user, err := r.db.GetUser(userID, time.Now().UTC())
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"userID": userID,
+		}).Error("Failed to retrieve user")
+		return UserLedger{}, err
+	}
+	entries, err := r.db.Ledger(userID)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"userID": userID,
+		}).Error("Failed to retrieve ledger")
+		return UserLedger{}, err
+	}
+	return UserLedger{User: user, Entries: entries}, nil
+}
+
+// Adjust posts a manual correction of points to the balance of a user.
+func (r *receipt) Adjust(userID string, points int64, reason string, actor string) (LedgerEntry, error) {
+	if points == 0 {
+		return LedgerEntry{}, fmt.Errorf("%w: points must not be 0", ErrAdjustmentInvalid)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return LedgerEntry{}, fmt.Errorf("%w: a reason is required", ErrAdjustmentInvalid)
+	}
+
+	entry, err := r.db.Post(LedgerEntry{
+		UserID: userID,
+		Type:   LedgerAdjustment,
+		Points: points,
+		Reason: reason,
+		Actor:  actor,
+		At:     time.Now().UTC(),
+	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"userID": userID,
+		}).Error("Failed to adjust points")
+		return LedgerEntry{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"userID": userID,
+		"points": points,
+		"actor":  actor,
+	}).Info("Points adjusted")
+	return entry, nil
+}
+
+// CheckLedger compares the ledger with the stored receipts and balances.
+func (r *receipt) CheckLedger() (LedgerReport, error) {
+	report, err := r.db.CheckLedger()
+	if err != nil {
+		log.WithError(err).Error("Failed to check ledger")
+		return LedgerReport{}, err
+	}
+	if !report.Balanced {
+		log.WithFields(log.Fields{
+			"discrepancies": report.Discrepancies,
+		}).Warn("Ledger does not match the stored points")
+	}
+	return report, nil
+}
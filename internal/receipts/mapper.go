@@ -1,54 +1,53 @@
 package receipts
 
 import (
-	"math"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
-	"time"
 )
 
-var twoPM, _ = time.Parse("15:04", "14:00")
-var fourPM, _ = time.Parse("15:04", "16:00")
 var alphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
 
-func toPoints(receipt Receipt) Points {
+func toPoints(rules RuleSet, receipt Receipt) Points {
 	var points int64 = 0
+	breakdown := []PointsDetail{}
 
-	// Add points for alphanumeric characters
-	points += int64(len(alphanumeric.ReplaceAllString(receipt.Retailer, "")))
-
-	// Add points if total has no cents
-	if receipt.Total%100 == 0 {
-		points += 50
-	}
-
-	// Add points if total is a multiple of 0.25
-	if receipt.Total%25 == 0 {
-		points += 25
-	}
-
-	// Add points for every two items
-	points += int64(len(receipt.Items) / 2 * 5)
-
-	// Add points for eligible items
-	for _, item := range receipt.Items {
-		if len(strings.TrimSpace(item.ShortDescription))%3 == 0 {
-			points += int64(math.Ceil(float64(item.Price) / 500))
+	for _, rule := range rules.Rules {
+		detail := rule.apply(receipt)
+		if detail.Points == 0 {
+			continue
 		}
+		points += detail.Points
+		breakdown = append(breakdown, detail)
 	}
 
-	// Add points for odd purchase date
-	if receipt.PurchaseDate.Day()%2 == 1 {
-		points += 6
-	}
-
-	// Add points for time of purchase
-	if receipt.PurchaseTime.Before(fourPM) && receipt.PurchaseTime.After(twoPM) {
-		points += 10
+	return Points{
+		ID:        "",
+		Points:    points,
+		Breakdown: breakdown,
 	}
+}
 
-	return Points{
-		ID:     "",
-		Points: points,
+// toFingerprint returns a digest of the content of receipt that is the same for
+// every submission of the same paper receipt: retailer and descriptions are
+// compared ignoring case and surrounding whitespace, and item order is ignored.
+func toFingerprint(receipt Receipt) string {
+	items := make([]string, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items = append(items, fmt.Sprintf("%q:%d", strings.ToLower(strings.TrimSpace(item.ShortDescription)), item.Price))
 	}
+	sort.Strings(items)
+
+	canonical := fmt.Sprintf("%q|%s|%s|%s|%d",
+		strings.ToLower(strings.TrimSpace(receipt.Retailer)),
+		receipt.PurchaseDate.Format("2006-01-02"),
+		receipt.PurchaseTime.Format("15:04"),
+		strings.Join(items, ","),
+		receipt.Total,
+	)
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
 }
@@ -1,6 +1,9 @@
 package receipts
 
-import "time"
+import (
+	apperrors "fetch_take_home/errors"
+	"time"
+)
 
 // Receipt
 // ID: UUID of the receipt
@@ -9,13 +12,63 @@ import "time"
 // PurchaseTime: The time of the purchase printed on the receipt (24-hour format).
 // Items: List of items purchased.
 // Total: The total amount paid on the receipt.
+// Tax: The tax charged on the receipt, if printed separately.
+// Discount: The discount taken off the receipt, if any.
+// Tip: The tip added to the receipt, if any.
+// Reconciliation: How Total compares to the items, tax, tip and discount.
+// UserID: The user who submitted the receipt and is awarded its points, empty for anonymous receipts.
+// ClientID: Identifies the client that submitted the receipt, used by risk checks.
+// BatchID: Identifies the batch the receipt was submitted in, risk checks count a batch as one submission. It is not stored.
+// Risk: The risk score and signals found when the receipt was submitted.
+// Status: Whether the points of the receipt are awarded or held for review.
+// Expiry: The expiry policy in force when the receipt was submitted, nil for points that never expire.
+// CreatedAt: When the receipt was stored.
+// Fingerprint: Digest of the receipt content, equal for duplicate submissions.
+// DuplicateOf: ID of the receipt this one duplicates, if any.
+// JobID: The job the receipt was submitted through, if it was processed in the background.
 type Receipt struct {
-	ID           string    `json:"id"`
-	Retailer     string    `json:"retailer"`
-	PurchaseDate time.Time `json:"purchaseDate"`
-	PurchaseTime time.Time `json:"purchaseTime"`
-	Items        []Item    `json:"items"`
-	Total        int64     `json:"total"`
+	ID             string         `json:"id"`
+	Retailer       string         `json:"retailer"`
+	PurchaseDate   time.Time      `json:"purchaseDate"`
+	PurchaseTime   time.Time      `json:"purchaseTime"`
+	Items          []Item         `json:"items"`
+	Total          Money          `json:"total"`
+	Tax            Money          `json:"tax,omitempty"`
+	Discount       Money          `json:"discount,omitempty"`
+	Tip            Money          `json:"tip,omitempty"`
+	Reconciliation Reconciliation `json:"reconciliation"`
+	UserID         string         `json:"userId,omitempty"`
+	ClientID       string         `json:"clientId,omitempty"`
+	BatchID        string         `json:"-"`
+	Risk           Risk           `json:"risk"`
+	Status         ReceiptStatus  `json:"status,omitempty"`
+	Expiry         *ExpiryPolicy  `json:"expiry,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	Fingerprint    string         `json:"fingerprint"`
+	DuplicateOf    string         `json:"duplicateOf,omitempty"`
+	JobID          string         `json:"jobId,omitempty"`
+}
+
+// ReceiptStatus is the review state of a receipt.
+type ReceiptStatus string
+
+const (
+	// StatusApproved receipts have their points awarded.
+	StatusApproved ReceiptStatus = "approved"
+	// StatusPending receipts are held for review and have no points yet.
+	StatusPending ReceiptStatus = "pending"
+	// StatusRejected receipts failed review and are awarded no points.
+	StatusRejected ReceiptStatus = "rejected"
+	// StatusVoided receipts were withdrawn and their points reversed.
+	StatusVoided ReceiptStatus = "voided"
+)
+
+// StoredReceipt
+// Receipt: The stored receipt.
+// Points: The points awarded for the receipt.
+type StoredReceipt struct {
+	Receipt Receipt `json:"receipt"`
+	Points  Points  `json:"points"`
 }
 
 // Item
@@ -23,7 +76,7 @@ type Receipt struct {
 // Price: The total price paid for this item in cents
 type Item struct {
 	ShortDescription string `json:"shortDescription"`
-	Price            int64  `json:"price"`
+	Price            Money  `json:"price"`
 }
 
 // ReceiptDTO - Data Transfer Object for a receipt
@@ -31,8 +84,76 @@ type ReceiptDTO struct {
 	Retailer     string    `json:"retailer" binding:"required"`
 	PurchaseDate string    `json:"purchaseDate" binding:"required"`
 	PurchaseTime string    `json:"purchaseTime" binding:"required"`
-	Items        []ItemDTO `json:"items" binding:"required"`
+	Items        []ItemDTO `json:"items" binding:"required,min=1,dive"`
 	Total        string    `json:"total" binding:"required"`
+	Tax          string    `json:"tax"`
+	Discount     string    `json:"discount"`
+	Tip          string    `json:"tip"`
+}
+
+// ReviewDTO - Data Transfer Object for approving or rejecting a receipt
+type ReviewDTO struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// AdjustmentDTO - Data Transfer Object for a manual points adjustment
+type AdjustmentDTO struct {
+	Points int64  `json:"points" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// RewardDTO - Data Transfer Object for adding or replacing a reward in the catalog
+type RewardDTO struct {
+	Name  string `json:"name" binding:"required"`
+	Cost  int64  `json:"cost"`
+	Stock int    `json:"stock"`
+}
+
+// CampaignDTO - Data Transfer Object for creating or replacing a campaign
+type CampaignDTO struct {
+	Name       string    `json:"name" binding:"required"`
+	Retailer   string    `json:"retailer"`
+	Item       string    `json:"item"`
+	StartsAt   time.Time `json:"startsAt" binding:"required"`
+	EndsAt     time.Time `json:"endsAt" binding:"required"`
+	Multiplier float64   `json:"multiplier"`
+	Bonus      int64     `json:"bonus"`
+	UserCap    int64     `json:"userCap"`
+	Budget     int64     `json:"budget"`
+}
+
+// RuleSetDTO - Data Transfer Object for registering a rule set
+type RuleSetDTO struct {
+	Rules []Rule `json:"rules" binding:"required"`
+}
+
+// RecomputeDTO - Data Transfer Object for previewing a recomputation
+type RecomputeDTO struct {
+	Version          string `json:"version" binding:"required"`
+	PurchaseDateFrom string `json:"purchaseDateFrom"`
+	PurchaseDateTo   string `json:"purchaseDateTo"`
+	FromVersion      string `json:"fromVersion"`
+}
+
+// RedemptionDTO - Data Transfer Object for redeeming a reward
+type RedemptionDTO struct {
+	RewardID string `json:"rewardId" binding:"required"`
+}
+
+// ReceiptQueryDTO - Data Transfer Object for the query parameters of a receipt search
+type ReceiptQueryDTO struct {
+	Retailer         string `form:"retailer"`
+	RetailerContains string `form:"retailerContains"`
+	PurchaseDateFrom string `form:"purchaseDateFrom"`
+	PurchaseDateTo   string `form:"purchaseDateTo"`
+	MinTotal         string `form:"minTotal"`
+	MaxTotal         string `form:"maxTotal"`
+	MinPoints        string `form:"minPoints"`
+	UserID           string `form:"userId"`
+	Sort             string `form:"sort"`
+	Order            string `form:"order"`
+	Limit            string `form:"limit"`
+	Cursor           string `form:"cursor"`
 }
 
 // ItemDTO
@@ -45,10 +166,47 @@ type ItemDTO struct {
 
 // Points
 // ID: The ID of the receipt
-// Points: The number of points awarded
+// Points: The number of points awarded, including campaigns and the tier bonus
+// Breakdown: The points awarded by each rule and campaign, and the tier bonus
+// Tier: The tier of the user when the receipt was submitted, empty for anonymous receipts
+// TierBonus: The points added by the tier on top of the base points of the rules and campaigns
+// RuleVersion: The version of the rule set the points were scored under, empty for points scored before rule sets had versions
 type Points struct {
-	ID     string `json:"id"`
-	Points int64  `json:"points"`
+	ID          string         `json:"id"`
+	Points      int64          `json:"points"`
+	Breakdown   []PointsDetail `json:"breakdown"`
+	Tier        string         `json:"tier,omitempty"`
+	TierBonus   int64          `json:"tierBonus,omitempty"`
+	RuleVersion string         `json:"ruleVersion,omitempty"`
+}
+
+// Base returns the points awarded by the rules and campaigns, without the tier bonus.
+func (p Points) Base() int64 {
+	return p.Points - p.TierBonus
+}
+
+// PointsDetail
+// Rule: The name of the rule that awarded the points, campaign for campaigns.
+// Campaign: The ID of the campaign that awarded the points, if it was one.
+// Points: The number of points awarded by the rule.
+// Reason: Human-readable explanation of why the rule awarded the points.
+// Items: The items that contributed to the points, if the rule looks at items.
+type PointsDetail struct {
+	Rule     string       `json:"rule"`
+	Campaign string       `json:"campaign,omitempty"`
+	Points   int64        `json:"points"`
+	Reason   string       `json:"reason"`
+	Items    []ItemPoints `json:"items,omitempty"`
+}
+
+// ItemPoints
+// Index: Position of the item on the receipt, starting at 0.
+// ShortDescription: The Short Product Description for the item.
+// Points: The number of points the item contributed.
+type ItemPoints struct {
+	Index            int    `json:"index"`
+	ShortDescription string `json:"shortDescription"`
+	Points           int64  `json:"points"`
 }
 
 // CreateResponse
@@ -57,8 +215,216 @@ type CreateResponse struct {
 	ID string `json:"id"`
 }
 
+// BatchResponse
+// results: The result of every receipt of the batch, in the order they were sent
+// succeeded: The number of receipts processed
+// failed: The number of receipts that were not
+type BatchResponse struct {
+	Results   []BatchResultResponse `json:"results"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+}
+
+// BatchResultResponse
+// index: The position of the receipt in the batch, from 0
+// id: The id of the processed receipt, omitted if it failed
+// error: Why the receipt failed, in the format of an error response, omitted if it was processed
+type BatchResultResponse struct {
+	Index int                 `json:"index"`
+	ID    string              `json:"id,omitempty"`
+	Error *apperrors.AppError `json:"error,omitempty"`
+}
+
+// JobResponse
+// id: The ID of the job
+// status: queued, running, succeeded or failed
+// receiptId: The ID of the processed receipt, once the job succeeded
+// error: Why the receipt could not be processed, in the format of an error response, once the job failed
+// attempts: How many times a worker started on the job
+// createdAt, startedAt, finishedAt: When the job was submitted, last started and finished
+type JobResponse struct {
+	ID         string              `json:"id"`
+	Status     JobStatus           `json:"status"`
+	ReceiptID  string              `json:"receiptId,omitempty"`
+	Error      *apperrors.AppError `json:"error,omitempty"`
+	Attempts   int                 `json:"attempts"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	StartedAt  *time.Time          `json:"startedAt,omitempty"`
+	FinishedAt *time.Time          `json:"finishedAt,omitempty"`
+}
+
 // PointsResponse
 // points: The number of points awarded
+// status: pending or rejected when the points are withheld
+// tier: How the points split into base points and tier bonus, omitted for anonymous receipts
 type PointsResponse struct {
-	Points int64 `json:"points"`
+	Points int64               `json:"points"`
+	Status ReceiptStatus       `json:"status,omitempty"`
+	Tier   *TierPointsResponse `json:"tier,omitempty"`
+}
+
+// TierPointsResponse
+// name: The tier of the user when the receipt was submitted
+// basePoints: The points awarded by the rules
+// bonus: The points added by the tier
+type TierPointsResponse struct {
+	Name       string `json:"name"`
+	BasePoints int64  `json:"basePoints"`
+	Bonus      int64  `json:"bonus"`
+}
+
+// SimulationResponse
+// points: The number of points the receipt would be awarded
+// breakdown: The points awarded by each rule, campaign and the tier bonus
+// ruleVersion: The version of the rules it was scored under
+// status: approved, or pending if it would be held for review
+// reconciliation: How the total compares to the other lines
+// tier: How the points split into base points and tier bonus, omitted for anonymous receipts
+// duplicateOf: The stored receipt it duplicates, its points are 0
+type SimulationResponse struct {
+	Points         int64                   `json:"points"`
+	Breakdown      []PointsDetail          `json:"breakdown"`
+	RuleVersion    string                  `json:"ruleVersion"`
+	Status         ReceiptStatus           `json:"status"`
+	Reconciliation *ReconciliationResponse `json:"reconciliation,omitempty"`
+	Tier           *TierPointsResponse     `json:"tier,omitempty"`
+	DuplicateOf    string                  `json:"duplicateOf,omitempty"`
+}
+
+// ReceiptResponse
+// id: The ID of the receipt
+// userId: The user who submitted the receipt, omitted for anonymous receipts
+// retailer, purchaseDate, purchaseTime, items, total: The receipt in the format it was submitted in
+// tax, discount, tip: The optional receipt lines, omitted when zero
+// reconciliation: How the total compares to the other lines, omitted for receipts stored before reconciliation
+// status: approved, or pending while the receipt is held for review
+// risk: The risk score and the signals that contributed to it, only in admin review responses
+// points: The number of points awarded, 0 while the receipt is pending
+// tier: How the points split into base points and tier bonus, omitted for anonymous and pending receipts
+// createdAt: When the receipt was processed
+type ReceiptResponse struct {
+	ID             string                  `json:"id"`
+	UserID         string                  `json:"userId,omitempty"`
+	Retailer       string                  `json:"retailer"`
+	PurchaseDate   string                  `json:"purchaseDate"`
+	PurchaseTime   string                  `json:"purchaseTime"`
+	Items          []ItemDTO               `json:"items"`
+	Total          string                  `json:"total"`
+	Tax            string                  `json:"tax,omitempty"`
+	Discount       string                  `json:"discount,omitempty"`
+	Tip            string                  `json:"tip,omitempty"`
+	Reconciliation *ReconciliationResponse `json:"reconciliation,omitempty"`
+	Status         ReceiptStatus           `json:"status,omitempty"`
+	Risk           *Risk                   `json:"risk,omitempty"`
+	Points         int64                   `json:"points"`
+	Tier           *TierPointsResponse     `json:"tier,omitempty"`
+	CreatedAt      time.Time               `json:"createdAt"`
+}
+
+// ReconciliationResponse
+// itemsTotal: The sum of the item prices
+// expected: itemsTotal plus tax and tip, less the discount
+// difference: total less expected
+// status: matched or mismatch
+type ReconciliationResponse struct {
+	ItemsTotal string               `json:"itemsTotal"`
+	Expected   string               `json:"expected"`
+	Difference string               `json:"difference"`
+	Status     ReconciliationStatus `json:"status"`
+}
+
+// ListResponse
+// receipts: The receipts in the page
+// nextCursor: Cursor for the next page, omitted on the last page
+type ListResponse struct {
+	Receipts   []ReceiptResponse `json:"receipts"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+// UserPointsResponse
+// userId: The ID of the user
+// balance: The points of the user, the sum of their ledger entries
+// tier: The loyalty tier of the user, omitted until their first receipt
+// recentReceipts: The latest receipts of the user by purchase date
+type UserPointsResponse struct {
+	UserID         string            `json:"userId"`
+	Balance        int64             `json:"balance"`
+	Tier           string            `json:"tier,omitempty"`
+	RecentReceipts []ReceiptResponse `json:"recentReceipts"`
+}
+
+// LedgerResponse
+// userId: The ID of the user
+// balance: The sum of the entries
+// entries: The points transactions of the user, oldest first
+type LedgerResponse struct {
+	UserID  string        `json:"userId"`
+	Balance int64         `json:"balance"`
+	Entries []LedgerEntry `json:"entries"`
+}
+
+// RewardsResponse
+// rewards: The catalog, cheapest first
+type RewardsResponse struct {
+	Rewards []Reward `json:"rewards"`
+}
+
+// RedemptionsResponse
+// redemptions: The redemptions of the user, newest first
+type RedemptionsResponse struct {
+	Redemptions []Redemption `json:"redemptions"`
+}
+
+// ExpirationsResponse
+// userId: The ID of the user
+// expirations: The points of the user that are still to expire, soonest first
+type ExpirationsResponse struct {
+	UserID      string       `json:"userId"`
+	Expirations []Expiration `json:"expirations"`
+}
+
+// ExpiryRunResponse
+// entries: The expiry entries posted, one for every user with points that expired
+type ExpiryRunResponse struct {
+	Entries []LedgerEntry `json:"entries"`
+}
+
+// CampaignsResponse
+// campaigns: Every campaign with the points it awarded, those that start first first
+type CampaignsResponse struct {
+	Campaigns []CampaignSummary `json:"campaigns"`
+}
+
+// RuleSetsResponse
+// ruleSets: Every registered rule set, ordered by version
+type RuleSetsResponse struct {
+	RuleSets []RuleSet `json:"ruleSets"`
+}
+
+// TierRunResponse
+// changed: The number of users whose tier changed
+type TierRunResponse struct {
+	Changed int `json:"changed"`
+}
+
+// TokenResponse
+// token: Bearer token authenticating as the user
+type TokenResponse struct {
+	Token string `json:"token"`
+}
+
+// AuditResponse
+// entries: The status changes of the receipt, oldest first
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// BreakdownResponse
+// points: The number of points awarded
+// breakdown: The points awarded by each rule, and the tier bonus
+// tier: How the points split into base points and tier bonus, omitted for anonymous receipts
+type BreakdownResponse struct {
+	Points    int64               `json:"points"`
+	Breakdown []PointsDetail      `json:"breakdown"`
+	Tier      *TierPointsResponse `json:"tier,omitempty"`
 }
@@ -0,0 +1,59 @@
+package receipts
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of US dollars in integer cents.
+type Money int64
+
+var (
+	ErrMoneyInvalid  = errors.New("amount must be dollars and cents, e.g. 6.49")
+	ErrMoneyOverflow = errors.New("amount is too large")
+)
+
+var moneyPattern = regexp.MustCompile(`^\d+\.\d{2}$`)
+
+// ParseMoney parses an amount in the format of the receipt spec, one or more
+// digits, a dot and exactly two digits, e.g. "6.49". Signs, exponents and
+// other precisions are rejected instead of being rounded.
+func ParseMoney(s string) (Money, error) {
+	if !moneyPattern.MatchString(s) {
+		return 0, ErrMoneyInvalid
+	}
+
+	dollarsText, centsText, _ := strings.Cut(s, ".")
+	dollars, err := strconv.ParseInt(dollarsText, 10, 64)
+	if err != nil {
+		return 0, ErrMoneyOverflow
+	}
+	cents, _ := strconv.ParseInt(centsText, 10, 64)
+	if dollars > (math.MaxInt64-cents)/100 {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(dollars*100 + cents), nil
+}
+
+// Add returns m + o, or ErrMoneyOverflow if the sum does not fit.
+func (m Money) Add(o Money) (Money, error) {
+	if (o > 0 && m > math.MaxInt64-o) || (o < 0 && m < math.MinInt64-o) {
+		return 0, ErrMoneyOverflow
+	}
+	return m + o, nil
+}
+
+// String formats m as dollars and cents, e.g. 649 as "6.49".
+func (m Money) String() string {
+	sign := ""
+	cents := uint64(m)
+	if m < 0 {
+		sign = "-"
+		cents = uint64(-(m + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,209 @@
+package receipts
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortField is the field a ReceiptQuery orders results by.
+type SortField string
+
+const (
+	SortByPurchaseDate SortField = "purchaseDate"
+	SortByPoints       SortField = "points"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ReceiptQuery
+// Retailer: Only receipts whose retailer is exactly this value.
+// RetailerContains: Only receipts whose retailer contains this value, ignoring case.
+// PurchasedFrom, PurchasedTo: Inclusive purchase date range, zero values are unbounded.
+// MinTotal, MaxTotal: Inclusive total range, nil values are unbounded.
+// MinPoints: Only receipts awarded at least this many points.
+// UserID: Only receipts submitted by this user.
+// Status: Only receipts in this status, receipts in any status but voided when empty.
+// SortBy: The field results are ordered by, ties are broken by receipt id.
+// Descending: Order results from largest to smallest.
+// Limit: The maximum number of receipts in a page.
+// Cursor: The NextCursor of the previous page, empty for the first page.
+type ReceiptQuery struct {
+	Retailer         string
+	RetailerContains string
+	PurchasedFrom    time.Time
+	PurchasedTo      time.Time
+	MinTotal         *Money
+	MaxTotal         *Money
+	MinPoints        *int64
+	UserID           string
+	Status           ReceiptStatus
+	SortBy           SortField
+	Descending       bool
+	Limit            int
+	Cursor           string
+}
+
+// ReceiptPage
+// Receipts: The receipts in the page.
+// NextCursor: Cursor for the next page, empty when this is the last page.
+type ReceiptPage struct {
+	Receipts   []StoredReceipt
+	NextCursor string
+}
+
+// cursor is the decoded form of ReceiptQuery.Cursor, the position of the last receipt of a page.
+type cursor struct {
+	SortBy     SortField `json:"s"`
+	Descending bool      `json:"d"`
+	Key        int64     `json:"k"`
+	ID         string    `json:"i"`
+}
+
+// Validate checks the query is well-formed and fills in defaults.
+func (q *ReceiptQuery) Validate() error {
+	if q.SortBy == "" {
+		q.SortBy = SortByPurchaseDate
+	}
+	if q.SortBy != SortByPurchaseDate && q.SortBy != SortByPoints {
+		return ErrQueryInvalid
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit < 0 || q.Limit > MaxPageSize {
+		return ErrQueryInvalid
+	}
+	if !q.PurchasedFrom.IsZero() && !q.PurchasedTo.IsZero() && q.PurchasedFrom.After(q.PurchasedTo) {
+		return ErrQueryInvalid
+	}
+	if q.MinTotal != nil && q.MaxTotal != nil && *q.MinTotal > *q.MaxTotal {
+		return ErrQueryInvalid
+	}
+	if q.Cursor != "" {
+		if _, err := q.decodeCursor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches reports whether stored passes every filter of the query.
+func (q ReceiptQuery) Matches(stored StoredReceipt) bool {
+	r := stored.Receipt
+	if q.Retailer != "" && r.Retailer != q.Retailer {
+		return false
+	}
+	if q.RetailerContains != "" && !strings.Contains(strings.ToLower(r.Retailer), strings.ToLower(q.RetailerContains)) {
+		return false
+	}
+	if !q.PurchasedFrom.IsZero() && r.PurchaseDate.Before(q.PurchasedFrom) {
+		return false
+	}
+	if !q.PurchasedTo.IsZero() && r.PurchaseDate.After(q.PurchasedTo) {
+		return false
+	}
+	if q.MinTotal != nil && r.Total < *q.MinTotal {
+		return false
+	}
+	if q.MaxTotal != nil && r.Total > *q.MaxTotal {
+		return false
+	}
+	if q.MinPoints != nil && stored.Awarded() < *q.MinPoints {
+		return false
+	}
+	if q.UserID != "" && r.UserID != q.UserID {
+		return false
+	}
+	if q.Status == "" && r.Status == StatusVoided || q.Status != "" && r.Status != q.Status {
+		return false
+	}
+	return true
+}
+
+// Paginate filters, sorts and pages candidates according to the query. Backends
+// that cannot push the query down to their storage can list every receipt and
+// hand it to Paginate. The query must have been validated.
+func (q ReceiptQuery) Paginate(candidates []StoredReceipt) ReceiptPage {
+	var matches []StoredReceipt
+	for _, stored := range candidates {
+		if q.Matches(stored) {
+			matches = append(matches, stored)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return q.less(matches[i], matches[j])
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		c, _ := q.decodeCursor()
+		start = sort.Search(len(matches), func(i int) bool {
+			return q.lessKey(c.Key, c.ID, q.sortKey(matches[i]), matches[i].Receipt.ID)
+		})
+	}
+
+	page := ReceiptPage{Receipts: []StoredReceipt{}}
+	end := start + q.Limit
+	if end >= len(matches) {
+		end = len(matches)
+	} else {
+		page.NextCursor = q.encodeCursor(matches[end-1])
+	}
+	page.Receipts = append(page.Receipts, matches[start:end]...)
+	return page
+}
+
+func (q ReceiptQuery) sortKey(stored StoredReceipt) int64 {
+	switch q.SortBy {
+	case SortByPoints:
+		return stored.Awarded()
+	default:
+		r := stored.Receipt
+		return r.PurchaseDate.Unix() + int64(r.PurchaseTime.Hour()*3600+r.PurchaseTime.Minute()*60)
+	}
+}
+
+func (q ReceiptQuery) less(a, b StoredReceipt) bool {
+	return q.lessKey(q.sortKey(a), a.Receipt.ID, q.sortKey(b), b.Receipt.ID)
+}
+
+func (q ReceiptQuery) lessKey(aKey int64, aID string, bKey int64, bID string) bool {
+	if aKey != bKey {
+		return (aKey < bKey) != q.Descending
+	}
+	if aID == bID {
+		return false
+	}
+	return (aID < bID) != q.Descending
+}
+
+func (q ReceiptQuery) encodeCursor(last StoredReceipt) string {
+	data, _ := json.Marshal(cursor{
+		SortBy:     q.SortBy,
+		Descending: q.Descending,
+		Key:        q.sortKey(last),
+		ID:         last.Receipt.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q ReceiptQuery) decodeCursor() (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return cursor{}, ErrQueryInvalid
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return cursor{}, ErrQueryInvalid
+	}
+	if c.SortBy != q.SortBy || c.Descending != q.Descending {
+		return cursor{}, ErrQueryInvalid
+	}
+	return c, nil
+}
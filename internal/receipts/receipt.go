@@ -1,45 +1,417 @@
 package receipts
 
 import (
+	"errors"
+	apperrors "fetch_take_home/errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
 )
 
 type DB interface {
 	GetPoints(id string) (Points, error)
+	GetReceipt(id string) (StoredReceipt, error)
+	List(q ReceiptQuery) (ReceiptPage, error)
+	// FindByFingerprint returns the first receipt stored with the fingerprint, or ErrReceiptNotFound.
+	FindByFingerprint(fingerprint string) (StoredReceipt, error)
+	// Create stores the receipt and its points and starts its audit trail.
+	// A receipt with the JobID of a running job finishes the job with it in
+	// the same write, so the job is never run again once it is stored.
 	Create(r Receipt, p Points) (Receipt, error)
+	// Transition changes the status of entry.ReceiptID from entry.From to
+	// entry.To and appends entry to its audit trail, with Reversed set to
+	// the points it took back. It returns ErrReceiptStatusConflict if the
+	// receipt is not in entry.From.
+	Transition(entry AuditEntry) (StoredReceipt, error)
+	// Audit returns the status changes of a receipt, oldest first.
+	Audit(id string) ([]AuditEntry, error)
+	// Delete removes entry.ReceiptID and its points, appends entry to its
+	// audit trail with Reversed set to the points it took back and leaves a
+	// tombstone, so later lookups return ErrReceiptGone. It returns
+	// ErrReceiptStatusConflict if the receipt is not in entry.From.
+	Delete(entry AuditEntry) error
+	// GetUser returns the balance of a user at, a zero balance for unknown
+	// users. It posts the expiries of the user due by at first.
+	GetUser(id string, at time.Time) (User, error)
+	// Ledger returns the ledger entries of a user, oldest first.
+	Ledger(userID string) ([]LedgerEntry, error)
+	// Post appends entry to the ledger, assigning its ID.
+	Post(entry LedgerEntry) (LedgerEntry, error)
+	// CheckLedger reports where the ledger does not add up to the points
+	// awarded for each receipt or to the balance of each user.
+	CheckLedger() (LedgerReport, error)
+	Rewards() ([]Reward, error)
+	// SaveReward adds reward to the catalog or replaces the reward with its ID.
+	SaveReward(reward Reward) error
+	// Redeem debits the cost of a reward from the balance of a user and takes
+	// one from its stock, or returns ErrInsufficientPoints or
+	// ErrRewardOutOfStock without changing either. Points that expired by at
+	// cannot be spent.
+	Redeem(userID string, rewardID string, at time.Time) (Redemption, error)
+	// Redemptions returns the redemptions of a user.
+	Redemptions(userID string) ([]Redemption, error)
+	// CancelRedemption refunds a completed redemption of the user and returns
+	// the reward to stock. It returns ErrRedemptionCancelled if the
+	// redemption was already cancelled and ErrRedemptionFinal if it was made
+	// before redeemedSince.
+	CancelRedemption(userID string, id string, at time.Time, redeemedSince time.Time) (Redemption, error)
+	// Expire posts an expiry entry for every user whose points expired by now.
+	Expire(now time.Time) ([]LedgerEntry, error)
+	// Users returns every user with ledger entries or a tier, posting the
+	// expiries due by at first.
+	Users(at time.Time) ([]User, error)
+	// Activity returns the base points and number of the approved receipts
+	// of a user stored since.
+	Activity(userID string, since time.Time) (Activity, error)
+	// SetTier places a user in a tier.
+	SetTier(userID string, tier string) error
+	Campaigns() ([]Campaign, error)
+	SaveCampaign(c Campaign) error
+	DeleteCampaign(id string) error
+	// CampaignUsage returns the points awarded by every campaign, and to
+	// userID unless it is empty.
+	CampaignUsage(userID string) (CampaignUsage, error)
+	RuleSets() ([]RuleSet, error)
+	// SaveRuleSet registers a rule set, or returns ErrRuleSetConflict if its
+	// version is registered with other rules.
+	SaveRuleSet(rules RuleSet) error
+	Recomputation(id string) (Recomputation, error)
+	SaveRecomputation(rc Recomputation) error
+	// CommitRecomputation replaces the points of every receipt in the
+	// recomputation at once and posts the changes to the ledger.
+	CommitRecomputation(id string, actor string, at time.Time) (Recomputation, error)
+	// EnqueueJob stores a queued job, or returns ErrJobQueueFull if capacity
+	// jobs are queued already.
+	EnqueueJob(job Job, capacity int) error
+	Job(id string) (Job, error)
+	// ClaimJob marks the oldest queued job running as of at and returns it,
+	// or returns ErrJobNotFound if none is queued.
+	ClaimJob(at time.Time) (Job, error)
+	// SaveJob replaces a job.
+	SaveJob(job Job) error
+	// PruneJobs removes the jobs that finished before before and returns how many.
+	PruneJobs(before time.Time) (int, error)
 }
 
 type Service interface {
 	GetPoints(id string) (Points, error)
+	GetReceipt(id string) (StoredReceipt, error)
+	List(q ReceiptQuery) (ReceiptPage, error)
 	Create(receipt Receipt) (Receipt, error)
+	Simulate(receipt Receipt, req SimulationRequest) (Simulation, error)
+	Review(id string, decision ReceiptStatus, reason string, actor string) (StoredReceipt, error)
+	Audit(id string) ([]AuditEntry, error)
+	Void(id string, reason string, actor string) error
+	Delete(id string, reason string, actor string) error
+	GetUserPoints(id string) (UserPoints, error)
+	Ledger(userID string) (UserLedger, error)
+	Adjust(userID string, points int64, reason string, actor string) (LedgerEntry, error)
+	CheckLedger() (LedgerReport, error)
+	Rewards() ([]Reward, error)
+	SaveReward(reward Reward) (Reward, error)
+	Redeem(userID string, rewardID string) (Redemption, error)
+	Redemptions(userID string) ([]Redemption, error)
+	CancelRedemption(userID string, id string) (Redemption, error)
+	Expirations(userID string) ([]Expiration, error)
+	ExpirePoints() ([]LedgerEntry, error)
+	RecalculateTiers() (int, error)
+	Campaigns() ([]CampaignSummary, error)
+	Campaign(id string) (CampaignSummary, error)
+	SaveCampaign(c Campaign) (Campaign, error)
+	DeleteCampaign(id string) error
+	RuleSets() ([]RuleSet, error)
+	SaveRuleSet(rules RuleSet) (RuleSet, error)
+	PreviewRecompute(req RecomputeRequest) (Recomputation, error)
+	Recomputation(id string) (Recomputation, error)
+	CommitRecompute(id string, actor string) (Recomputation, error)
+	Enqueue(receipt Receipt) (Job, error)
+	Job(id string) (Job, error)
+	ClaimJob() (Job, error)
+	FinishJob(id string, receiptID string, problem *apperrors.AppError) (Job, error)
+	PruneJobs() (int, error)
+}
+
+// DuplicatePolicy decides what happens when a receipt with the same content is submitted again.
+type DuplicatePolicy string
+
+const (
+	// DuplicateReject fails the submission with ErrReceiptDuplicate.
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateReturnOriginal returns the original receipt instead of storing a new one.
+	DuplicateReturnOriginal DuplicatePolicy = "original"
+	// DuplicateZeroPoints stores the receipt but awards it no points.
+	DuplicateZeroPoints DuplicatePolicy = "zero"
+)
+
+// ParseDuplicatePolicy returns the DuplicatePolicy named s.
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(s); policy {
+	case DuplicateReject, DuplicateReturnOriginal, DuplicateZeroPoints:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown duplicate policy %q", s)
+	}
 }
 
 type receipt struct {
-	db DB
+	db         DB
+	rules      RuleSet
+	duplicates DuplicatePolicy
+	reconcile  ReconcilePolicy
+	tolerance  Money
+	riskChecks []RiskCheck
+	threshold  int
+	expiry     ExpiryPolicy
+	tiers      TierSet
+
+	jobQueueSize int
+	jobRetention time.Duration
+
+	cancellationWindow time.Duration
+
+	// createMu serialises the duplicate check and tier change with the write
+	// that follows them.
+	createMu sync.Mutex
 }
 
-func NewReceiptService(db DB) Service {
-	return &receipt{
-		db: db}
+// Option configures optional behaviour of the receipt service.
+type Option func(*receipt)
+
+// WithRuleSet scores receipts with rules instead of DefaultRuleSet.
+func WithRuleSet(rules RuleSet) Option {
+	return func(r *receipt) {
+		r.rules = rules
+	}
+}
+
+// WithDuplicatePolicy handles duplicate submissions with policy instead of DuplicateReject.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(r *receipt) {
+		r.duplicates = policy
+	}
 }
 
+// WithReconcilePolicy handles receipts whose total is more than tolerance away
+// from their items, tax, tip and discount with policy instead of ReconcileFlag.
+func WithReconcilePolicy(policy ReconcilePolicy, tolerance Money) Option {
+	return func(r *receipt) {
+		r.reconcile = policy
+		r.tolerance = tolerance
+	}
+}
+
+// WithRiskChecks scores receipts with checks instead of DefaultRiskChecks and
+// holds receipts scoring threshold or more for review.
+func WithRiskChecks(threshold int, checks ...RiskCheck) Option {
+	return func(r *receipt) {
+		r.threshold = threshold
+		r.riskChecks = checks
+	}
+}
+
+// WithExpiryPolicy expires the points of receipts submitted from now on under
+// policy. By default points never expire.
+func WithExpiryPolicy(policy ExpiryPolicy) Option {
+	return func(r *receipt) {
+		r.expiry = policy
+	}
+}
+
+// WithTierSet places users in tiers instead of DefaultTierSet. A tier set
+// without tiers awards no tier bonus.
+func WithTierSet(tiers TierSet) Option {
+	return func(r *receipt) {
+		r.tiers = tiers
+	}
+}
+
+func NewReceiptService(db DB, opts ...Option) Service {
+	r := &receipt{
+		db:         db,
+		rules:      DefaultRuleSet(),
+		duplicates: DuplicateReject,
+		reconcile:  ReconcileFlag,
+		threshold:  DefaultRiskThreshold,
+		tiers:      DefaultTierSet(),
+
+		jobQueueSize: DefaultJobQueueSize,
+		jobRetention: DefaultJobRetention,
+
+		cancellationWindow: DefaultCancellationWindow,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.rules = r.rules.versioned()
+	if r.riskChecks == nil {
+		r.riskChecks = DefaultRiskChecks(r.rules)
+	}
+	return r
+}
+
+// GetPoints returns the points awarded for a receipt, ErrReceiptPending while
+// the receipt is held for review, ErrReceiptRejected if it was rejected or
+// ErrReceiptGone if it was voided.
 func (r *receipt) GetPoints(id string) (Points, error) {
-	points, err := r.db.GetPoints(id)
+	stored, err := r.db.GetReceipt(id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"ID": id,
 		}).Error("Failed to retrieve points for receipt")
 		return Points{}, err
 	}
-	return points, nil
+	switch stored.Receipt.Status {
+	case StatusPending:
+		return Points{}, ErrReceiptPending
+	case StatusRejected:
+		return Points{}, ErrReceiptRejected
+	case StatusVoided:
+		return Points{}, ErrReceiptGone
+	}
+	return stored.Points, nil
+}
+
+// GetReceipt returns a stored receipt, or ErrReceiptGone if it was voided or deleted.
+func (r *receipt) GetReceipt(id string) (StoredReceipt, error) {
+	stored, err := r.db.GetReceipt(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ID": id,
+		}).Error("Failed to retrieve receipt")
+		return StoredReceipt{}, err
+	}
+	if stored.Receipt.Status == StatusVoided {
+		return StoredReceipt{}, ErrReceiptGone
+	}
+	return stored, nil
+}
+
+func (r *receipt) List(q ReceiptQuery) (ReceiptPage, error) {
+	if err := q.Validate(); err != nil {
+		return ReceiptPage{}, err
+	}
+	page, err := r.db.List(q)
+	if err != nil {
+		log.WithError(err).Error("Failed to list receipts")
+		return ReceiptPage{}, err
+	}
+	return page, nil
+}
+
+// assess reconciles a receipt, scores its risk and decides its status and
+// expiry policy, the checks every submission goes through before scoring.
+func (r *receipt) assess(receipt Receipt) (Receipt, error) {
+	reconciliation, err := reconcile(receipt, r.tolerance)
+	if err != nil {
+		return Receipt{}, err
+	}
+	receipt.Reconciliation = reconciliation
+	if reconciliation.Status == ReconciliationMismatch {
+		log.WithFields(log.Fields{
+			"retailer":   receipt.Retailer,
+			"total":      receipt.Total,
+			"expected":   reconciliation.Expected,
+			"difference": reconciliation.Difference,
+			"policy":     r.reconcile,
+		}).Warn("Receipt total does not match its items")
+
+		if r.reconcile == ReconcileReject {
+			return Receipt{}, fmt.Errorf("%w: items, tax and tip less discount come to %s but the total is %s",
+				ErrReceiptTotalMismatch, reconciliation.Expected, receipt.Total)
+		}
+	}
+
+	receipt.Risk = scoreRisk(r.riskChecks, receipt)
+	receipt.Status = StatusApproved
+	if receipt.Risk.Score >= r.threshold {
+		log.WithFields(log.Fields{
+			"retailer": receipt.Retailer,
+			"score":    receipt.Risk.Score,
+			"signals":  receipt.Risk.Signals,
+		}).Warn("Receipt held for review")
+		receipt.Status = StatusPending
+	}
+
+	if r.expiry.Months > 0 {
+		policy := r.expiry
+		receipt.Expiry = &policy
+	}
+	return receipt, nil
 }
 
 func (r *receipt) Create(receipt Receipt) (Receipt, error) {
-	pointsObj := toPoints(receipt)
+	receipt, err := r.assess(receipt)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	r.createMu.Lock()
+	defer r.createMu.Unlock()
+
+	pointsObj := toPoints(r.rules, receipt)
+	pointsObj.RuleVersion = r.rules.Version
+
+	receipt.Fingerprint = toFingerprint(receipt)
+	original, err := r.db.FindByFingerprint(receipt.Fingerprint)
+	switch {
+	case errors.Is(err, ErrReceiptNotFound):
+	case err != nil:
+		log.WithError(err).Error("Failed to look up duplicate receipts")
+		return Receipt{}, err
+	default:
+		log.WithFields(log.Fields{
+			"ID":          original.Receipt.ID,
+			"fingerprint": receipt.Fingerprint,
+			"policy":      r.duplicates,
+		}).Warn("Duplicate receipt submitted")
+
+		switch r.duplicates {
+		case DuplicateReturnOriginal:
+			// The original is only returned to whoever submitted it, other
+			// users must not learn its id.
+			if original.Receipt.UserID != receipt.UserID {
+				return Receipt{}, ErrReceiptDuplicate
+			}
+			return original.Receipt, nil
+		case DuplicateZeroPoints:
+			receipt.DuplicateOf = original.Receipt.ID
+			pointsObj = Points{
+				RuleVersion: r.rules.Version,
+				Breakdown: []PointsDetail{{
+					Rule:   "duplicate",
+					Reason: fmt.Sprintf("duplicate of receipt %s, no points awarded", original.Receipt.ID),
+				}},
+			}
+		default:
+			return Receipt{}, ErrReceiptDuplicate
+		}
+	}
+
+	// Only a submission that is stored may change the tier of its user.
+	var tier Tier
+	if receipt.UserID != "" && len(r.tiers.Tiers) > 0 {
+		tier, err = r.refreshTier(receipt.UserID, time.Now().UTC())
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"userID": receipt.UserID,
+			}).Error("Failed to work out the tier of the user")
+			return Receipt{}, err
+		}
+	}
+
+	if receipt.DuplicateOf == "" {
+		pointsObj, err = r.addCampaigns(receipt, pointsObj)
+		if err != nil {
+			return Receipt{}, err
+		}
+	}
+	pointsObj = tier.apply(pointsObj)
 
 	createdReceipt, err := r.db.Create(receipt, pointsObj)
 	if err != nil {
-		return Receipt{}, ErrReceiptInvalid
+		log.WithError(err).Error("Failed to store receipt")
+		return Receipt{}, err
 	}
 
 	return createdReceipt, nil
@@ -1,8 +1,13 @@
 package receipts
 
 import (
+	"context"
+	apperrors "fetch_take_home/errors"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -11,114 +16,1974 @@ type dbMock struct {
 	GetPointsResult Points
 	GetError        error
 
+	GetReceiptResult StoredReceipt
+
+	ListQuery  ReceiptQuery
+	ListResult ReceiptPage
+	ListError  error
+
 	CreateResult Receipt
 	CreateError  error
 
-	CreatePoints Points
+	CreateReceipt Receipt
+	CreatePoints  Points
+
+	FindResult StoredReceipt
+
+	TransitionEntry  AuditEntry
+	TransitionResult StoredReceipt
+	TransitionError  error
+
+	AuditResult []AuditEntry
+
+	DeleteEntry AuditEntry
+	DeleteError error
+
+	GetUserResult User
+	LedgerResult  []LedgerEntry
+	PostEntry     LedgerEntry
+	PostError     error
+	CheckResult   LedgerReport
+
+	RewardsResult     []Reward
+	SavedReward       Reward
+	RedeemResult      Redemption
+	RedeemError       error
+	RedemptionsResult []Redemption
+	CancelResult      Redemption
+	CancelError       error
+	CancelWindow      time.Duration
+
+	ExpireNow    time.Time
+	ExpireResult []LedgerEntry
+
+	UsersResult    []User
+	ActivitySince  time.Time
+	ActivityResult Activity
+	SetTierUser    string
+	SetTierTier    string
+
+	CampaignsResult   []Campaign
+	SavedCampaign     Campaign
+	UsageResult       CampaignUsage
+	UsageUser         string
+	DeletedCampaignID string
+
+	RuleSetsResult      []RuleSet
+	SavedRuleSet        RuleSet
+	RecomputationResult Recomputation
+	SavedRecomputation  Recomputation
+	CommittedID         string
+
+	EnqueuedJob   Job
+	QueueCapacity int
+	JobResult     Job
+	SavedJob      Job
+	PrunedBefore  time.Time
+}
+
+func (db *dbMock) GetPoints(id string) (Points, error) {
+	return db.GetPointsResult, db.GetError
+}
+
+func (db *dbMock) GetReceipt(id string) (StoredReceipt, error) {
+	return db.GetReceiptResult, db.GetError
+}
+
+func (db *dbMock) List(q ReceiptQuery) (ReceiptPage, error) {
+	db.ListQuery = q
+	return db.ListResult, db.ListError
+}
+
+func (db *dbMock) FindByFingerprint(fingerprint string) (StoredReceipt, error) {
+	if db.FindResult.Receipt.ID == "" {
+		return StoredReceipt{}, ErrReceiptNotFound
+	}
+	return db.FindResult, nil
+}
+
+func (db *dbMock) Create(r Receipt, p Points) (Receipt, error) {
+	db.CreateReceipt = r
+	db.CreatePoints = p
+	return db.CreateResult, db.CreateError
+}
+
+func (db *dbMock) Transition(entry AuditEntry) (StoredReceipt, error) {
+	db.TransitionEntry = entry
+	return db.TransitionResult, db.TransitionError
+}
+
+func (db *dbMock) Audit(id string) ([]AuditEntry, error) {
+	return db.AuditResult, db.GetError
+}
+
+func (db *dbMock) Delete(entry AuditEntry) error {
+	db.DeleteEntry = entry
+	return db.DeleteError
+}
+
+func (db *dbMock) GetUser(id string, at time.Time) (User, error) {
+	return db.GetUserResult, db.GetError
+}
+
+func (db *dbMock) Ledger(userID string) ([]LedgerEntry, error) {
+	return db.LedgerResult, db.GetError
+}
+
+func (db *dbMock) Post(entry LedgerEntry) (LedgerEntry, error) {
+	db.PostEntry = entry
+	if db.PostError != nil {
+		return LedgerEntry{}, db.PostError
+	}
+	entry.ID = "entry"
+	return entry, nil
+}
+
+func (db *dbMock) CheckLedger() (LedgerReport, error) {
+	return db.CheckResult, db.GetError
+}
+
+func (db *dbMock) Rewards() ([]Reward, error) {
+	return db.RewardsResult, db.GetError
+}
+
+func (db *dbMock) SaveReward(reward Reward) error {
+	db.SavedReward = reward
+	return db.CreateError
+}
+
+func (db *dbMock) Redeem(userID string, rewardID string, at time.Time) (Redemption, error) {
+	return db.RedeemResult, db.RedeemError
+}
+
+func (db *dbMock) Redemptions(userID string) ([]Redemption, error) {
+	return db.RedemptionsResult, db.GetError
+}
+
+func (db *dbMock) Expire(now time.Time) ([]LedgerEntry, error) {
+	db.ExpireNow = now
+	return db.ExpireResult, db.CreateError
+}
+
+func (db *dbMock) Users(at time.Time) ([]User, error) {
+	return db.UsersResult, db.GetError
+}
+
+func (db *dbMock) Activity(userID string, since time.Time) (Activity, error) {
+	db.ActivitySince = since
+	return db.ActivityResult, db.GetError
+}
+
+func (db *dbMock) SetTier(userID string, tier string) error {
+	db.SetTierUser = userID
+	db.SetTierTier = tier
+	return db.CreateError
+}
+
+func (db *dbMock) Campaigns() ([]Campaign, error) {
+	return db.CampaignsResult, db.GetError
+}
+
+func (db *dbMock) SaveCampaign(c Campaign) error {
+	db.SavedCampaign = c
+	return db.CreateError
+}
+
+func (db *dbMock) DeleteCampaign(id string) error {
+	db.DeletedCampaignID = id
+	return db.CreateError
+}
+
+func (db *dbMock) CampaignUsage(userID string) (CampaignUsage, error) {
+	db.UsageUser = userID
+	return db.UsageResult, db.GetError
+}
+
+func (db *dbMock) RuleSets() ([]RuleSet, error) {
+	return db.RuleSetsResult, db.GetError
+}
+
+func (db *dbMock) SaveRuleSet(rules RuleSet) error {
+	db.SavedRuleSet = rules
+	return db.CreateError
+}
+
+func (db *dbMock) Recomputation(id string) (Recomputation, error) {
+	return db.RecomputationResult, db.GetError
+}
+
+func (db *dbMock) SaveRecomputation(rc Recomputation) error {
+	db.SavedRecomputation = rc
+	return db.CreateError
+}
+
+func (db *dbMock) CommitRecomputation(id string, actor string, at time.Time) (Recomputation, error) {
+	db.CommittedID = id
+	return db.RecomputationResult, db.TransitionError
+}
+
+func (db *dbMock) EnqueueJob(job Job, capacity int) error {
+	db.EnqueuedJob = job
+	db.QueueCapacity = capacity
+	return db.CreateError
+}
+
+func (db *dbMock) Job(id string) (Job, error) {
+	return db.JobResult, db.GetError
+}
+
+func (db *dbMock) ClaimJob(at time.Time) (Job, error) {
+	return db.JobResult, db.GetError
+}
+
+func (db *dbMock) SaveJob(job Job) error {
+	db.SavedJob = job
+	return db.CreateError
+}
+
+func (db *dbMock) PruneJobs(before time.Time) (int, error) {
+	db.PrunedBefore = before
+	return 1, db.CreateError
+}
+
+func (db *dbMock) CancelRedemption(userID string, id string, at time.Time, redeemedSince time.Time) (Redemption, error) {
+	db.CancelWindow = at.Sub(redeemedSince)
+	return db.CancelResult, db.CancelError
+}
+
+func TestReceiptServiceGetPoints(t *testing.T) {
+	id := uuid.NewString()
+	tests := map[string]struct {
+		db     DB
+		result Points
+		err    error
+	}{
+		"Successfully retrieves Points": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusApproved}, Points: Points{ID: id}},
+				GetError:         nil,
+			},
+			result: Points{ID: id},
+			err:    nil,
+		},
+		"Receipt without status": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id}, Points: Points{ID: id, Points: 10}},
+			},
+			result: Points{ID: id, Points: 10},
+			err:    nil,
+		},
+		"Receipt pending review": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusPending}, Points: Points{ID: id, Points: 10}},
+			},
+			result: Points{},
+			err:    ErrReceiptPending,
+		},
+		"Receipt rejected": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusRejected}, Points: Points{ID: id, Points: 10}},
+			},
+			result: Points{},
+			err:    ErrReceiptRejected,
+		},
+		"Receipt voided": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusVoided}, Points: Points{ID: id, Points: 10}},
+			},
+			result: Points{},
+			err:    ErrReceiptGone,
+		},
+		"Receipt not found": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{},
+				GetError:         ErrReceiptNotFound,
+			},
+			result: Points{},
+			err:    ErrReceiptNotFound,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			response, err := service.GetPoints(id)
+
+			assert.Equal(t, test.result, response)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestReceiptServiceGetReceipt(t *testing.T) {
+	id := uuid.NewString()
+	stored := StoredReceipt{
+		Receipt: Receipt{ID: id, Retailer: "retailer", Total: 500},
+		Points:  Points{ID: id, Points: 10},
+	}
+	tests := map[string]struct {
+		db     DB
+		result StoredReceipt
+		err    error
+	}{
+		"Successfully retrieves receipt": {
+			db: &dbMock{
+				GetReceiptResult: stored,
+				GetError:         nil,
+			},
+			result: stored,
+			err:    nil,
+		},
+		"Receipt voided": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusVoided}},
+			},
+			result: StoredReceipt{},
+			err:    ErrReceiptGone,
+		},
+		"Receipt not found": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{},
+				GetError:         ErrReceiptNotFound,
+			},
+			result: StoredReceipt{},
+			err:    ErrReceiptNotFound,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			response, err := service.GetReceipt(id)
+
+			assert.Equal(t, test.result, response)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestReceiptServiceList(t *testing.T) {
+	page := ReceiptPage{Receipts: []StoredReceipt{{Receipt: Receipt{ID: uuid.NewString()}}}}
+	tests := map[string]struct {
+		db     DB
+		query  ReceiptQuery
+		result ReceiptPage
+		err    error
+	}{
+		"Successfully lists receipts": {
+			db:     &dbMock{ListResult: page},
+			query:  ReceiptQuery{RetailerContains: "target"},
+			result: page,
+			err:    nil,
+		},
+		"Invalid sort": {
+			db:     &dbMock{ListResult: page},
+			query:  ReceiptQuery{SortBy: "total"},
+			result: ReceiptPage{},
+			err:    ErrQueryInvalid,
+		},
+		"Limit too large": {
+			db:     &dbMock{ListResult: page},
+			query:  ReceiptQuery{Limit: MaxPageSize + 1},
+			result: ReceiptPage{},
+			err:    ErrQueryInvalid,
+		},
+		"Invalid cursor": {
+			db:     &dbMock{ListResult: page},
+			query:  ReceiptQuery{Cursor: "not a cursor"},
+			result: ReceiptPage{},
+			err:    ErrQueryInvalid,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			response, err := service.List(test.query)
+
+			assert.Equal(t, test.result, response)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestReceiptServiceCreate(t *testing.T) {
+	id := uuid.NewString()
+	purchaseDate, _ := time.Parse("2006-01-02", "2024-09-14")
+	purchaseTime, _ := time.Parse("15:04", "14:00")
+
+	validItems := []Item{
+		{
+			ShortDescription: "chicken",
+			Price:            500,
+		},
+	}
+
+	validReceipt := Receipt{
+		ID:           "",
+		Retailer:     "retailer",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items:        validItems,
+		Total:        500,
+	}
+
+	createdReceipt := Receipt{
+		ID:           id,
+		Retailer:     "retailer",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items:        validItems,
+		Total:        500,
+	}
+
+	tests := map[string]struct {
+		db     DB
+		result Receipt
+		input  Receipt
+		err    error
+	}{
+		"Success": {
+			db: &dbMock{
+				CreateResult: createdReceipt,
+				CreateError:  nil,
+			},
+			input:  validReceipt,
+			result: createdReceipt,
+			err:    nil,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			response, err := service.Create(test.input)
+
+			assert.Equal(t, test.result, response)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestReceiptServiceCreateDuplicate(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2024-09-14")
+	purchaseTime, _ := time.Parse("15:04", "14:00")
+	input := Receipt{
+		Retailer:     "retailer",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items:        []Item{{ShortDescription: "chicken", Price: 500}},
+		Total:        500,
+	}
+	original := input
+	original.ID = uuid.NewString()
+	duplicate := input
+	duplicate.ID = uuid.NewString()
+
+	tests := map[string]struct {
+		policy      DuplicatePolicy
+		userID      string
+		result      Receipt
+		err         error
+		created     bool
+		duplicateOf string
+		points      int64
+	}{
+		"Reject": {
+			policy:  DuplicateReject,
+			result:  Receipt{},
+			err:     ErrReceiptDuplicate,
+			created: false,
+		},
+		"Return original": {
+			policy:  DuplicateReturnOriginal,
+			result:  original,
+			err:     nil,
+			created: false,
+		},
+		"Original of another user": {
+			policy:  DuplicateReturnOriginal,
+			userID:  "user-2",
+			result:  Receipt{},
+			err:     ErrReceiptDuplicate,
+			created: false,
+		},
+		"Zero points": {
+			policy:      DuplicateZeroPoints,
+			result:      duplicate,
+			err:         nil,
+			created:     true,
+			duplicateOf: original.ID,
+			points:      0,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{
+				FindResult:   StoredReceipt{Receipt: original},
+				CreateResult: duplicate,
+			}
+			service := NewReceiptService(db, WithDuplicatePolicy(test.policy))
+			submitted := input
+			submitted.UserID = test.userID
+			response, err := service.Create(submitted)
+
+			assert.Equal(t, test.result, response)
+			assert.Equal(t, test.err, err)
+			if test.created {
+				assert.Equal(t, toFingerprint(input), db.CreateReceipt.Fingerprint)
+				assert.Equal(t, test.duplicateOf, db.CreateReceipt.DuplicateOf)
+				assert.Equal(t, test.points, db.CreatePoints.Points)
+				assert.Equal(t, DefaultRuleSet().Version, db.CreatePoints.RuleVersion)
+			} else {
+				assert.Equal(t, Receipt{}, db.CreateReceipt)
+			}
+		})
+	}
+}
+
+func TestReceiptServiceCreateReconcile(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2024-09-14")
+	purchaseTime, _ := time.Parse("15:04", "14:00")
+	newReceipt := func(total, tax, discount, tip Money) Receipt {
+		return Receipt{
+			Retailer:     "retailer",
+			PurchaseDate: purchaseDate,
+			PurchaseTime: purchaseTime,
+			Items:        []Item{{ShortDescription: "chicken", Price: 500}, {ShortDescription: "rice", Price: 250}},
+			Total:        total,
+			Tax:          tax,
+			Discount:     discount,
+			Tip:          tip,
+		}
+	}
+
+	tests := map[string]struct {
+		input          Receipt
+		policy         ReconcilePolicy
+		tolerance      Money
+		reconciliation Reconciliation
+		err            error
+	}{
+		"Matched": {
+			input:          newReceipt(750, 0, 0, 0),
+			policy:         ReconcileReject,
+			reconciliation: Reconciliation{ItemsTotal: 750, Expected: 750, Difference: 0, Status: ReconciliationMatched},
+		},
+		"Matched with tax, discount and tip": {
+			input:          newReceipt(810, 60, 100, 100),
+			policy:         ReconcileReject,
+			reconciliation: Reconciliation{ItemsTotal: 750, Expected: 810, Difference: 0, Status: ReconciliationMatched},
+		},
+		"Within tolerance": {
+			input:          newReceipt(745, 0, 0, 0),
+			policy:         ReconcileReject,
+			tolerance:      5,
+			reconciliation: Reconciliation{ItemsTotal: 750, Expected: 750, Difference: -5, Status: ReconciliationMatched},
+		},
+		"Mismatch flagged": {
+			input:          newReceipt(50000, 0, 0, 0),
+			policy:         ReconcileFlag,
+			reconciliation: Reconciliation{ItemsTotal: 750, Expected: 750, Difference: 49250, Status: ReconciliationMismatch},
+		},
+		"Mismatch rejected": {
+			input:  newReceipt(50000, 0, 0, 0),
+			policy: ReconcileReject,
+			err:    ErrReceiptTotalMismatch,
+		},
+		"Overflow": {
+			input:  newReceipt(math.MaxInt64, math.MaxInt64, 0, 0),
+			policy: ReconcileFlag,
+			err:    ErrReceiptInvalid,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{}
+			service := NewReceiptService(db, WithReconcilePolicy(test.policy, test.tolerance))
+			_, err := service.Create(test.input)
+
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.Equal(t, Receipt{}, db.CreateReceipt)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.reconciliation, db.CreateReceipt.Reconciliation)
+		})
+	}
+}
+
+func TestReceiptServiceCreateRisk(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2024-09-14")
+	purchaseTime, _ := time.Parse("15:04", "14:00")
+	input := Receipt{
+		Retailer:     "retailer",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items:        []Item{{ShortDescription: "chicken", Price: 500}},
+		Total:        500,
+	}
+	signal := func(score int) RiskCheck {
+		return RiskCheckFunc(func(receipt Receipt) []RiskSignal {
+			return []RiskSignal{{Check: "test", Score: score, Reason: "test signal"}}
+		})
+	}
+
+	tests := map[string]struct {
+		checks []RiskCheck
+		risk   Risk
+		status ReceiptStatus
+	}{
+		"No signals": {
+			checks: []RiskCheck{},
+			risk:   Risk{},
+			status: StatusApproved,
+		},
+		"Below threshold": {
+			checks: []RiskCheck{signal(20), signal(29)},
+			risk: Risk{Score: 49, Signals: []RiskSignal{
+				{Check: "test", Score: 20, Reason: "test signal"},
+				{Check: "test", Score: 29, Reason: "test signal"},
+			}},
+			status: StatusApproved,
+		},
+		"At threshold": {
+			checks: []RiskCheck{signal(30), signal(20)},
+			risk: Risk{Score: 50, Signals: []RiskSignal{
+				{Check: "test", Score: 30, Reason: "test signal"},
+				{Check: "test", Score: 20, Reason: "test signal"},
+			}},
+			status: StatusPending,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{}
+			service := NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold, test.checks...))
+			_, err := service.Create(input)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.risk, db.CreateReceipt.Risk)
+			assert.Equal(t, test.status, db.CreateReceipt.Status)
+		})
+	}
+}
+
+func TestReceiptServiceCreateExpiry(t *testing.T) {
+	input := Receipt{Retailer: "retailer", Items: []Item{{ShortDescription: "chicken", Price: 500}}, Total: 500}
+	policy, err := NewExpiryPolicy("", 12, ExpiryFromAward)
+	assert.NoError(t, err)
+	assert.Equal(t, ExpiryPolicy{Version: "12m-award", Months: 12, Basis: ExpiryFromAward}, policy)
+
+	db := &dbMock{}
+	_, err = NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold), WithExpiryPolicy(policy)).Create(input)
+	assert.NoError(t, err)
+	assert.Equal(t, &policy, db.CreateReceipt.Expiry)
+
+	db = &dbMock{}
+	_, err = NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.NoError(t, err)
+	assert.Nil(t, db.CreateReceipt.Expiry)
+
+	_, err = NewExpiryPolicy("", -1, ExpiryFromAward)
+	assert.Error(t, err)
+}
+
+func TestReceiptServiceCreateTier(t *testing.T) {
+	input := Receipt{UserID: "user-1", Retailer: "retailer", Items: []Item{{ShortDescription: "chicken", Price: 500}}, Total: 500}
+
+	db := &dbMock{GetUserResult: User{ID: "user-1", Tier: "bronze"}, ActivityResult: Activity{Points: 2500, Receipts: 3}}
+	_, err := NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", db.SetTierUser)
+	assert.Equal(t, "gold", db.SetTierTier)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, -DefaultTierWindow), db.ActivitySince, time.Minute)
+	assert.Equal(t, "gold", db.CreatePoints.Tier)
+	assert.Equal(t, int64(89), db.CreatePoints.Base())
+	assert.Equal(t, int64(89+22+25), db.CreatePoints.Points)
+	last := db.CreatePoints.Breakdown[len(db.CreatePoints.Breakdown)-1]
+	assert.Equal(t, PointsDetail{Rule: "tier", Points: 47, Reason: "gold tier: 1.25x 89 base points, 25 bonus points"}, last)
+
+	db = &dbMock{GetUserResult: User{ID: "user-1", Tier: "bronze"}}
+	_, err = NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.NoError(t, err)
+	assert.Empty(t, db.SetTierUser)
+	assert.Equal(t, "bronze", db.CreatePoints.Tier)
+	assert.Zero(t, db.CreatePoints.TierBonus)
+
+	// A rejected duplicate does not change the tier.
+	db = &dbMock{GetUserResult: User{ID: "user-1", Tier: "bronze"}, ActivityResult: Activity{Points: 2500, Receipts: 3},
+		FindResult: StoredReceipt{Receipt: Receipt{ID: uuid.NewString(), UserID: "user-1"}}}
+	_, err = NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.ErrorIs(t, err, ErrReceiptDuplicate)
+	assert.Empty(t, db.SetTierUser)
+
+	input.UserID = ""
+	db = &dbMock{}
+	_, err = NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.NoError(t, err)
+	assert.Empty(t, db.CreatePoints.Tier)
+}
+
+func TestReceiptServiceSimulate(t *testing.T) {
+	input := Receipt{
+		UserID:       "user-1",
+		ClientID:     "10.0.0.1",
+		Retailer:     "retailer",
+		PurchaseDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Items:        []Item{{ShortDescription: "gatorade", Price: 500}},
+		Total:        500,
+	}
+	v2 := RuleSet{Version: "v2", Rules: []Rule{{Name: "retailer_name", Type: RuleAlphanumericCount, Points: 2}}}
+	march := Campaign{
+		ID:       "march",
+		Name:     "March",
+		StartsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Bonus:    100,
+	}
+	gatorade := march
+	gatorade.ID, gatorade.Name, gatorade.Item, gatorade.Bonus = "gatorade", "Gatorade", "gatorade", 200
+
+	tests := map[string]struct {
+		db          *dbMock
+		req         SimulationRequest
+		duplicates  DuplicatePolicy
+		points      int64
+		ruleVersion string
+		tier        string
+		duplicateOf string
+		err         error
+	}{
+		"Rules in force": {
+			db:          &dbMock{},
+			points:      89,
+			ruleVersion: "v1",
+			tier:        "bronze",
+		},
+		"Named rule set": {
+			db:          &dbMock{RuleSetsResult: []RuleSet{DefaultRuleSet(), v2}},
+			req:         SimulationRequest{Version: "v2"},
+			points:      16,
+			ruleVersion: "v2",
+			tier:        "bronze",
+		},
+		"Unknown rule set": {
+			db:  &dbMock{RuleSetsResult: []RuleSet{DefaultRuleSet()}},
+			req: SimulationRequest{Version: "v2"},
+			err: ErrRuleSetNotFound,
+		},
+		"Every campaign": {
+			db:          &dbMock{CampaignsResult: []Campaign{march, gatorade}},
+			points:      89 + 100 + 200,
+			ruleVersion: "v1",
+			tier:        "bronze",
+		},
+		"Named campaign": {
+			db:          &dbMock{CampaignsResult: []Campaign{march, gatorade}},
+			req:         SimulationRequest{CampaignID: "gatorade"},
+			points:      89 + 200,
+			ruleVersion: "v1",
+			tier:        "bronze",
+		},
+		"Unknown campaign": {
+			db:  &dbMock{CampaignsResult: []Campaign{march}},
+			req: SimulationRequest{CampaignID: "gatorade"},
+			err: ErrCampaignNotFound,
+		},
+		"Tier the user qualifies for": {
+			db:          &dbMock{GetUserResult: User{ID: "user-1", Tier: "bronze"}, ActivityResult: Activity{Points: 2500, Receipts: 3}},
+			points:      89 + 22 + 25,
+			ruleVersion: "v1",
+			tier:        "gold",
+		},
+		"Duplicate": {
+			db:          &dbMock{FindResult: StoredReceipt{Receipt: Receipt{ID: "original"}}},
+			duplicates:  DuplicateZeroPoints,
+			ruleVersion: "v1",
+			duplicateOf: "original",
+		},
+		"Duplicate of own receipt": {
+			db:          &dbMock{FindResult: StoredReceipt{Receipt: Receipt{ID: "original", UserID: "user-1"}}},
+			duplicates:  DuplicateReturnOriginal,
+			ruleVersion: "v1",
+			duplicateOf: "original",
+		},
+		"Duplicate of another user": {
+			db:         &dbMock{FindResult: StoredReceipt{Receipt: Receipt{ID: "original", UserID: "user-2"}}},
+			duplicates: DuplicateReturnOriginal,
+			err:        ErrReceiptDuplicate,
+		},
+		"Duplicate rejected": {
+			db:  &dbMock{FindResult: StoredReceipt{Receipt: Receipt{ID: "original"}}},
+			err: ErrReceiptDuplicate,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			opts := []Option{WithRiskChecks(DefaultRiskThreshold, SubmissionRateCheck(0, time.Hour, time.Now))}
+			if test.duplicates != "" {
+				opts = append(opts, WithDuplicatePolicy(test.duplicates))
+			}
+			simulation, err := NewReceiptService(test.db, opts...).Simulate(input, test.req)
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.points, simulation.Points.Points)
+			assert.Equal(t, test.ruleVersion, simulation.Points.RuleVersion)
+			assert.Equal(t, test.tier, simulation.Points.Tier)
+			assert.Equal(t, test.duplicateOf, simulation.Receipt.DuplicateOf)
+			if test.err == nil {
+				assert.Equal(t, StatusApproved, simulation.Receipt.Status)
+				assert.Equal(t, ReconciliationMatched, simulation.Receipt.Reconciliation.Status)
+			}
+			assert.Empty(t, test.db.CreateReceipt.Retailer)
+			assert.Empty(t, test.db.SetTierUser)
+		})
+	}
+}
+
+func TestReceiptServiceRecalculateTiers(t *testing.T) {
+	db := &dbMock{
+		UsersResult:    []User{{ID: "user-1", Tier: "gold"}},
+		GetUserResult:  User{ID: "user-1", Tier: "gold"},
+		ActivityResult: Activity{Points: 600},
+	}
+	changed, err := NewReceiptService(db).RecalculateTiers()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Equal(t, "silver", db.SetTierTier)
+
+	db.GetError = os.ErrClosed
+	_, err = NewReceiptService(db).RecalculateTiers()
+	assert.Error(t, err)
+}
+
+func TestTierSet(t *testing.T) {
+	tiers := DefaultTierSet()
+	points := Points{Points: 80, Breakdown: []PointsDetail{{Rule: "retailer_name", Points: 80}}}
+
+	tests := map[string]struct {
+		activity Activity
+		points   Points
+		tier     string
+		bonus    int64
+		reason   string
+	}{
+		"No activity": {
+			points: points,
+			tier:   "bronze",
+		},
+		"Qualifies on points": {
+			activity: Activity{Points: 500},
+			points:   points,
+			tier:     "silver",
+			bonus:    8,
+			reason:   "silver tier: 1.1x 80 base points",
+		},
+		"Qualifies on receipts": {
+			activity: Activity{Points: 10, Receipts: 20},
+			points:   points,
+			tier:     "gold",
+			bonus:    45,
+			reason:   "gold tier: 1.25x 80 base points, 25 bonus points",
+		},
+		"Rounds to the nearest point": {
+			activity: Activity{Receipts: 5},
+			points:   Points{Points: 15},
+			tier:     "silver",
+			bonus:    2,
+			reason:   "silver tier: 1.1x 15 base points",
+		},
+		"No bonus without base points": {
+			activity: Activity{Receipts: 20},
+			points:   Points{},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			tier := tiers.Qualify(test.activity)
+			applied := tier.apply(test.points)
+
+			assert.Equal(t, test.tier, applied.Tier)
+			assert.Equal(t, test.bonus, applied.TierBonus)
+			assert.Equal(t, test.points.Points+test.bonus, applied.Points)
+			assert.Equal(t, test.points.Points, applied.Base())
+			if test.reason == "" {
+				assert.Equal(t, test.points.Breakdown, applied.Breakdown)
+				return
+			}
+			assert.Equal(t, PointsDetail{Rule: "tier", Points: test.bonus, Reason: test.reason},
+				applied.Breakdown[len(applied.Breakdown)-1])
+			assert.Len(t, points.Breakdown, 1)
+		})
+	}
+
+	assert.Equal(t, Tier{}, TierSet{}.Qualify(Activity{Points: 100}))
+}
+
+func TestLoadTierSet(t *testing.T) {
+	tiers, err := LoadTierSet("../../tiers.json")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTierSet(), tiers)
+
+	invalid := map[string]string{
+		"Window":    `{"tiers": [{"name": "bronze"}]}`,
+		"Name":      `{"windowDays": 30, "tiers": [{"minPoints": 10}]}`,
+		"Duplicate": `{"windowDays": 30, "tiers": [{"name": "bronze"}, {"name": "bronze"}]}`,
+		"Negative":  `{"windowDays": 30, "tiers": [{"name": "bronze", "bonus": -5}]}`,
+	}
+	for testName, data := range invalid {
+		t.Run(testName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tiers.json")
+			assert.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+			_, err := LoadTierSet(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpirations(t *testing.T) {
+	date := func(month time.Month) *time.Time {
+		t := time.Date(2024, month, 1, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	earn := func(receiptID string, points int64, expiresAt *time.Time) LedgerEntry {
+		return LedgerEntry{ReceiptID: receiptID, Type: LedgerEarn, Points: points, ExpiresAt: expiresAt}
+	}
+	now := *date(time.February)
+
+	tests := map[string]struct {
+		entries  []LedgerEntry
+		expired  int64
+		upcoming []Expiration
+	}{
+		"Never expires": {
+			entries: []LedgerEntry{earn("1", 10, nil)},
+		},
+		"Expired and upcoming": {
+			entries:  []LedgerEntry{earn("1", 10, date(time.January)), earn("2", 5, date(time.March))},
+			expired:  10,
+			upcoming: []Expiration{{Points: 5, ExpiresAt: *date(time.March)}},
+		},
+		"Expires now": {
+			entries: []LedgerEntry{earn("1", 10, date(time.February))},
+			expired: 10,
+		},
+		"Spent before expiry": {
+			entries: []LedgerEntry{earn("1", 10, date(time.January)), {Type: LedgerRedemption, Points: -8}},
+			expired: 2,
+		},
+		"Spent from the points expiring first": {
+			entries: []LedgerEntry{
+				earn("1", 10, date(time.April)),
+				earn("2", 10, date(time.March)),
+				{Type: LedgerRedemption, Points: -15},
+			},
+			upcoming: []Expiration{{Points: 5, ExpiresAt: *date(time.April)}},
+		},
+		"Refunded redemption": {
+			entries: []LedgerEntry{
+				earn("1", 10, date(time.January)),
+				{Type: LedgerRedemption, Points: -8},
+				{Type: LedgerRefund, Points: 8},
+			},
+			expired: 10,
+		},
+		"Expiry already posted": {
+			entries: []LedgerEntry{earn("1", 10, date(time.January)), {Type: LedgerExpiry, Points: -10}},
+		},
+		"Reversed receipt": {
+			entries: []LedgerEntry{earn("1", 10, date(time.January)), {ReceiptID: "1", Type: LedgerReversal, Points: -10}},
+		},
+		"Recomputed receipt": {
+			entries: []LedgerEntry{
+				earn("1", 10, date(time.March)),
+				{ReceiptID: "1", Type: LedgerRecompute, Points: -4},
+				{ReceiptID: "2", Type: LedgerRecompute, Points: 3},
+			},
+			upcoming: []Expiration{{Points: 6, ExpiresAt: *date(time.March)}},
+		},
+		"Credits that never expire are spent last": {
+			entries: []LedgerEntry{
+				{Type: LedgerAdjustment, Points: 5},
+				earn("1", 10, date(time.March)),
+				{Type: LedgerRedemption, Points: -5},
+			},
+			upcoming: []Expiration{{Points: 5, ExpiresAt: *date(time.March)}},
+		},
+		"Same expiry date": {
+			entries:  []LedgerEntry{earn("1", 10, date(time.March)), earn("2", 5, date(time.March))},
+			upcoming: []Expiration{{Points: 15, ExpiresAt: *date(time.March)}},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			expired, upcoming := Expirations(test.entries, now)
+
+			assert.Equal(t, test.expired, expired)
+			assert.Equal(t, test.upcoming, upcoming)
+		})
+	}
+}
+
+func TestExpiredPoints(t *testing.T) {
+	date := func(month time.Month) *time.Time {
+		t := time.Date(2024, month, 1, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	earn := func(receiptID string, points int64, expiresAt *time.Time) LedgerEntry {
+		return LedgerEntry{ReceiptID: receiptID, Type: LedgerEarn, Points: points, ExpiresAt: expiresAt}
+	}
+	now := *date(time.February)
+
+	tests := map[string]struct {
+		entries []LedgerEntry
+		expired int64
+	}{
+		"Not earned": {
+			entries: []LedgerEntry{earn("2", 10, date(time.January))},
+		},
+		"Never expires": {
+			entries: []LedgerEntry{earn("1", 10, nil)},
+		},
+		"Not expired yet": {
+			entries: []LedgerEntry{earn("1", 10, date(time.March))},
+		},
+		"Expired": {
+			entries: []LedgerEntry{earn("1", 10, date(time.January))},
+			expired: 10,
+		},
+		"Expiry posted": {
+			entries: []LedgerEntry{earn("1", 10, date(time.January)), {Type: LedgerExpiry, Points: -10}},
+			expired: 10,
+		},
+		"Partly spent before expiry": {
+			entries: []LedgerEntry{
+				earn("1", 10, date(time.January)),
+				{Type: LedgerRedemption, Points: -8},
+				{Type: LedgerExpiry, Points: -2},
+			},
+			expired: 2,
+		},
+		"Spent from the points expiring first": {
+			entries: []LedgerEntry{
+				earn("1", 10, date(time.January)),
+				earn("2", 10, date(time.December)),
+				{Type: LedgerRedemption, Points: -12},
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, test.expired, ExpiredPoints(test.entries, "1", now))
+		})
+	}
+}
+
+func TestEvery(t *testing.T) {
+	db := &dbMock{}
+	service := NewReceiptService(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	Every(ctx, time.Hour, func() { _, _ = service.ExpirePoints() })
+	assert.False(t, db.ExpireNow.IsZero())
+}
+
+func TestRiskChecks(t *testing.T) {
+	now := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	items := func(descriptions ...string) []Item {
+		var items []Item
+		for _, d := range descriptions {
+			items = append(items, Item{ShortDescription: d, Price: 100})
+		}
+		return items
+	}
+
+	tests := map[string]struct {
+		check   RiskCheck
+		receipt Receipt
+		checks  []string
+	}{
+		"Purchase date today": {
+			check:   PurchaseDateCheck(10, clock),
+			receipt: Receipt{PurchaseDate: date("2024-09-14")},
+		},
+		"Purchase date tomorrow": {
+			check:   PurchaseDateCheck(10, clock),
+			receipt: Receipt{PurchaseDate: date("2024-09-15")},
+		},
+		"Purchase date in the future": {
+			check:   PurchaseDateCheck(10, clock),
+			receipt: Receipt{PurchaseDate: date("2024-09-16")},
+			checks:  []string{"purchase_date"},
+		},
+		"Purchase date decades ago": {
+			check:   PurchaseDateCheck(10, clock),
+			receipt: Receipt{PurchaseDate: date("1994-09-14")},
+			checks:  []string{"purchase_date"},
+		},
+		"Total at limit": {
+			check:   LargeTotalCheck(100000),
+			receipt: Receipt{Total: 100000},
+		},
+		"Total above limit": {
+			check:   LargeTotalCheck(100000),
+			receipt: Receipt{Total: 100001},
+			checks:  []string{"large_total"},
+		},
+		"Plain descriptions": {
+			check:   DescriptionPaddingCheck(3),
+			receipt: Receipt{Items: items("Mountain Dew 12PK", "   Klarbrunn 12-PK 12 FL OZ  ", "Diet  Pepsi")},
+		},
+		"Padded descriptions": {
+			check:   DescriptionPaddingCheck(3),
+			receipt: Receipt{Items: items("Diet   Pepsi", "Chips!!!!", "Gatorade")},
+			checks:  []string{"description_padding", "description_padding"},
+		},
+		"Padding to an unused multiple": {
+			check:   DescriptionPaddingCheck(5),
+			receipt: Receipt{Items: items("Diet   Pepsi")},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var checks []string
+			for _, signal := range test.check.Check(test.receipt) {
+				assert.NotEmpty(t, signal.Reason)
+				checks = append(checks, signal.Check)
+			}
+			assert.Equal(t, test.checks, checks)
+		})
+	}
+}
+
+func TestSubmissionRateCheck(t *testing.T) {
+	now := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	check := SubmissionRateCheck(2, time.Minute, func() time.Time { return now })
+
+	assert.Empty(t, check.Check(Receipt{ClientID: "a"}))
+	assert.Empty(t, check.Check(Receipt{ClientID: "a"}))
+	assert.Empty(t, check.Check(Receipt{ClientID: "b"}))
+	assert.Len(t, check.Check(Receipt{ClientID: "a"}), 1)
+	assert.Empty(t, check.Check(Receipt{}))
+
+	now = now.Add(time.Minute)
+	assert.Empty(t, check.Check(Receipt{ClientID: "a"}))
+
+	now = now.Add(time.Minute)
+	for range 12 {
+		assert.Empty(t, check.Check(Receipt{ClientID: "a", BatchID: "batch-1"}))
+	}
+	assert.Empty(t, check.Check(Receipt{ClientID: "a"}))
+	assert.Len(t, check.Check(Receipt{ClientID: "a", BatchID: "batch-2"}), 1)
+	assert.Len(t, check.Check(Receipt{ClientID: "a", BatchID: "batch-2"}), 1)
+}
+
+func TestReceiptServiceCancelRedemption(t *testing.T) {
+	tests := map[string]struct {
+		opts   []Option
+		db     *dbMock
+		window time.Duration
+		err    error
+	}{
+		"Default window": {
+			db:     &dbMock{CancelResult: Redemption{ID: "1", Status: RedemptionCancelled}},
+			window: DefaultCancellationWindow,
+		},
+		"Configured window": {
+			opts:   []Option{WithCancellationWindow(time.Hour)},
+			db:     &dbMock{CancelResult: Redemption{ID: "1", Status: RedemptionCancelled}},
+			window: time.Hour,
+		},
+		"Window passed": {
+			db:     &dbMock{CancelError: ErrRedemptionFinal},
+			window: DefaultCancellationWindow,
+			err:    ErrRedemptionFinal,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			redemption, err := NewReceiptService(test.db, test.opts...).CancelRedemption("user", "1")
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.window, test.db.CancelWindow)
+			if test.err == nil {
+				assert.Equal(t, test.db.CancelResult, redemption)
+			}
+		})
+	}
+}
+
+func TestReceiptServiceReview(t *testing.T) {
+	id := uuid.NewString()
+	reviewed := StoredReceipt{Receipt: Receipt{ID: id, Status: StatusApproved}}
+
+	tests := map[string]struct {
+		decision ReceiptStatus
+		reason   string
+		db       *dbMock
+		result   StoredReceipt
+		err      error
+	}{
+		"Approve": {
+			decision: StatusApproved,
+			reason:   "checked with the retailer",
+			db:       &dbMock{TransitionResult: reviewed},
+			result:   reviewed,
+		},
+		"Reject": {
+			decision: StatusRejected,
+			reason:   "receipt is forged",
+			db:       &dbMock{TransitionResult: reviewed},
+			result:   reviewed,
+		},
+		"Invalid decision": {
+			decision: StatusVoided,
+			reason:   "reason",
+			db:       &dbMock{},
+			err:      ErrReviewInvalid,
+		},
+		"Missing reason": {
+			decision: StatusApproved,
+			reason:   "  ",
+			db:       &dbMock{},
+			err:      ErrReviewInvalid,
+		},
+		"Not pending": {
+			decision: StatusApproved,
+			reason:   "reason",
+			db:       &dbMock{TransitionError: ErrReceiptStatusConflict},
+			err:      ErrReceiptStatusConflict,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			response, err := service.Review(id, test.decision, test.reason, "admin")
+
+			assert.Equal(t, test.result, response)
+			assert.ErrorIs(t, err, test.err)
+			if test.db.TransitionEntry.ReceiptID != "" {
+				entry := test.db.TransitionEntry
+				assert.False(t, entry.At.IsZero())
+				entry.At = time.Time{}
+				assert.Equal(t, AuditEntry{
+					ReceiptID: id,
+					From:      StatusPending,
+					To:        test.decision,
+					Reason:    test.reason,
+					Actor:     "admin",
+				}, entry)
+			}
+		})
+	}
+}
+
+func TestReceiptServiceVoid(t *testing.T) {
+	id := uuid.NewString()
+	stored := func(status ReceiptStatus) StoredReceipt {
+		return StoredReceipt{Receipt: Receipt{ID: id, Status: status}, Points: Points{ID: id, Points: 28}}
+	}
+
+	tests := map[string]struct {
+		stored   StoredReceipt
+		err      error
+		reversed int64
+	}{
+		"Approved": {
+			stored:   stored(StatusApproved),
+			reversed: 28,
+		},
+		"Pending": {
+			stored:   stored(StatusPending),
+			reversed: 0,
+		},
+		"Rejected": {
+			stored: stored(StatusRejected),
+			err:    ErrReceiptStatusConflict,
+		},
+		"Already voided": {
+			stored: stored(StatusVoided),
+			err:    ErrReceiptGone,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{GetReceiptResult: test.stored}
+			service := NewReceiptService(db)
+			err := service.Void(id, "mistake", "client")
+
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.Equal(t, AuditEntry{}, db.TransitionEntry)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.stored.Receipt.Status, db.TransitionEntry.From)
+			assert.Equal(t, StatusVoided, db.TransitionEntry.To)
+			assert.Equal(t, "mistake", db.TransitionEntry.Reason)
+			assert.Equal(t, test.reversed, db.TransitionEntry.Reversed)
+		})
+	}
+}
+
+func TestReceiptServiceReversed(t *testing.T) {
+	at := time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)
+	entry := AuditEntry{ReceiptID: "id", From: StatusApproved, To: StatusVoided, Reversed: 28, At: at}
+	db := &dbMock{AuditResult: []AuditEntry{
+		{ReceiptID: "id", To: StatusApproved, At: at.Add(-time.Hour)},
+		{ReceiptID: "id", From: StatusApproved, To: StatusVoided, Reversed: 10, At: at},
+	}}
+	service := NewReceiptService(db).(*receipt)
+
+	assert.Equal(t, int64(10), service.reversed(entry))
+	db.AuditResult = nil
+	assert.Equal(t, int64(0), service.reversed(entry))
+}
+
+func TestReceiptServiceDelete(t *testing.T) {
+	id := uuid.NewString()
+	tests := map[string]struct {
+		db       *dbMock
+		err      error
+		reversed int64
+	}{
+		"Approved": {
+			db:       &dbMock{GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusApproved}, Points: Points{Points: 28}}},
+			reversed: 28,
+		},
+		"Voided": {
+			db:       &dbMock{GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusVoided}, Points: Points{Points: 28}}},
+			reversed: 0,
+		},
+		"Already deleted": {
+			db:  &dbMock{GetError: ErrReceiptGone},
+			err: ErrReceiptGone,
+		},
+		"Storage error": {
+			db: &dbMock{
+				GetReceiptResult: StoredReceipt{Receipt: Receipt{ID: id, Status: StatusApproved}},
+				DeleteError:      ErrReceiptStatusConflict,
+			},
+			err: ErrReceiptStatusConflict,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			err := service.Delete(id, "fraud", "admin")
+
+			assert.ErrorIs(t, err, test.err)
+			if test.err == nil {
+				assert.Equal(t, id, test.db.DeleteEntry.ReceiptID)
+				assert.Equal(t, StatusVoided, test.db.DeleteEntry.To)
+				assert.Equal(t, test.reversed, test.db.DeleteEntry.Reversed)
+			}
+		})
+	}
+}
+
+func TestReceiptServiceGetUserPoints(t *testing.T) {
+	recent := []StoredReceipt{{Receipt: Receipt{ID: uuid.NewString(), UserID: "user"}}}
+	tests := map[string]struct {
+		db     *dbMock
+		result UserPoints
+		err    error
+	}{
+		"Balance and recent receipts": {
+			db: &dbMock{
+				GetUserResult: User{ID: "user", Balance: 28},
+				ListResult:    ReceiptPage{Receipts: recent},
+			},
+			result: UserPoints{User: User{ID: "user", Balance: 28}, Recent: recent},
+		},
+		"Storage error": {
+			db:     &dbMock{ListError: ErrQueryInvalid},
+			result: UserPoints{},
+			err:    ErrQueryInvalid,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			response, err := service.GetUserPoints("user")
+
+			assert.Equal(t, test.result, response)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, "user", test.db.ListQuery.UserID)
+			assert.True(t, test.db.ListQuery.Descending)
+		})
+	}
+}
+
+func TestReceiptServiceAdjust(t *testing.T) {
+	tests := map[string]struct {
+		points int64
+		reason string
+		db     *dbMock
+		err    error
+	}{
+		"Credit": {
+			points: 50,
+			reason: "missing receipt",
+			db:     &dbMock{},
+		},
+		"Debit": {
+			points: -20,
+			reason: "points awarded twice",
+			db:     &dbMock{},
+		},
+		"No points": {
+			points: 0,
+			reason: "reason",
+			db:     &dbMock{},
+			err:    ErrAdjustmentInvalid,
+		},
+		"Missing reason": {
+			points: 10,
+			reason: " ",
+			db:     &dbMock{},
+			err:    ErrAdjustmentInvalid,
+		},
+		"Storage error": {
+			points: 10,
+			reason: "reason",
+			db:     &dbMock{PostError: ErrQueryInvalid},
+			err:    ErrQueryInvalid,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := NewReceiptService(test.db)
+			entry, err := service.Adjust("user", test.points, test.reason, "admin")
+
+			assert.ErrorIs(t, err, test.err)
+			if test.err != nil {
+				assert.Equal(t, LedgerEntry{}, entry)
+				return
+			}
+			assert.False(t, entry.At.IsZero())
+			entry.At = time.Time{}
+			assert.Equal(t, LedgerEntry{
+				ID:     "entry",
+				UserID: "user",
+				Type:   LedgerAdjustment,
+				Points: test.points,
+				Reason: test.reason,
+				Actor:  "admin",
+			}, entry)
+		})
+	}
 }
 
-func (db *dbMock) GetPoints(id string) (Points, error) {
-	return db.GetPointsResult, db.GetError
+func TestReceiptServiceSaveReward(t *testing.T) {
+	tests := map[string]struct {
+		reward Reward
+		saved  Reward
+		err    error
+	}{
+		"Valid": {
+			reward: Reward{ID: "mug", Name: " Mug ", Cost: 500, Stock: 10},
+			saved:  Reward{ID: "mug", Name: "Mug", Cost: 500, Stock: 10},
+		},
+		"Out of stock": {
+			reward: Reward{ID: "mug", Name: "Mug", Cost: 500},
+			saved:  Reward{ID: "mug", Name: "Mug", Cost: 500},
+		},
+		"Missing name": {
+			reward: Reward{ID: "mug", Name: " ", Cost: 500, Stock: 10},
+			err:    ErrRewardInvalid,
+		},
+		"Free": {
+			reward: Reward{ID: "mug", Name: "Mug", Stock: 10},
+			err:    ErrRewardInvalid,
+		},
+		"Negative stock": {
+			reward: Reward{ID: "mug", Name: "Mug", Cost: 500, Stock: -1},
+			err:    ErrRewardInvalid,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{}
+			service := NewReceiptService(db)
+			saved, err := service.SaveReward(test.reward)
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.saved, saved)
+			assert.Equal(t, test.saved, db.SavedReward)
+		})
+	}
 }
 
-func (db *dbMock) Create(r Receipt, p Points) (Receipt, error) {
-	return db.CreateResult, db.CreateError
+func TestReceiptServiceSaveCampaign(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		campaign Campaign
+		saved    Campaign
+		err      error
+	}{
+		"Valid": {
+			campaign: Campaign{ID: "gatorade", Name: " Gatorade March ", Item: " gatorade ", StartsAt: start, EndsAt: end, Bonus: 200},
+			saved:    Campaign{ID: "gatorade", Name: "Gatorade March", Item: "gatorade", StartsAt: start, EndsAt: end, Bonus: 200},
+		},
+		"Missing name": {
+			campaign: Campaign{ID: "gatorade", StartsAt: start, EndsAt: end, Bonus: 200},
+			err:      ErrCampaignInvalid,
+		},
+		"Ends before it starts": {
+			campaign: Campaign{ID: "gatorade", Name: "Gatorade", StartsAt: end, EndsAt: start, Bonus: 200},
+			err:      ErrCampaignInvalid,
+		},
+		"Negative budget": {
+			campaign: Campaign{ID: "gatorade", Name: "Gatorade", StartsAt: start, EndsAt: end, Bonus: 200, Budget: -1},
+			err:      ErrCampaignInvalid,
+		},
+		"Multiplier below 1": {
+			campaign: Campaign{ID: "target", Name: "Target", StartsAt: start, EndsAt: end, Multiplier: 0.5, Bonus: 10},
+			err:      ErrCampaignInvalid,
+		},
+		"Awards nothing": {
+			campaign: Campaign{ID: "target", Name: "Target", StartsAt: start, EndsAt: end, Multiplier: 1},
+			err:      ErrCampaignInvalid,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{}
+			service := NewReceiptService(db)
+			saved, err := service.SaveCampaign(test.campaign)
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.saved, saved)
+			assert.Equal(t, test.saved, db.SavedCampaign)
+		})
+	}
 }
 
-func TestReceiptServiceGetPoints(t *testing.T) {
-	id := uuid.NewString()
+func TestReceiptServiceCampaigns(t *testing.T) {
+	march := Campaign{ID: "march", StartsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	weekend := Campaign{ID: "weekend", StartsAt: time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)}
+	db := &dbMock{
+		CampaignsResult: []Campaign{march, weekend},
+		UsageResult:     CampaignUsage{Spent: map[string]int64{"march": 400}},
+	}
+	service := NewReceiptService(db)
+
+	campaigns, err := service.Campaigns()
+	assert.NoError(t, err)
+	assert.Equal(t, []CampaignSummary{{Campaign: weekend}, {Campaign: march, Spent: 400}}, campaigns)
+
+	campaign, err := service.Campaign("march")
+	assert.NoError(t, err)
+	assert.Equal(t, CampaignSummary{Campaign: march, Spent: 400}, campaign)
+	_, err = service.Campaign("missing")
+	assert.ErrorIs(t, err, ErrCampaignNotFound)
+}
+
+func TestApplyCampaigns(t *testing.T) {
+	purchaseDate := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	purchaseTime := time.Date(0, 1, 1, 13, 1, 0, 0, time.UTC)
+	receipt := Receipt{
+		UserID:       "user",
+		Retailer:     "Target Store",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items:        []Item{{ShortDescription: "Gatorade Lemon-Lime"}, {ShortDescription: "Doritos"}},
+	}
+	points := Points{Points: 28, Breakdown: []PointsDetail{{Rule: "retailer_name", Points: 28}}}
+	weekend := Campaign{
+		ID:         "target-weekend",
+		Name:       "Target weekend",
+		Retailer:   "target",
+		StartsAt:   time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		EndsAt:     time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+		Multiplier: 2,
+	}
+	gatorade := Campaign{
+		ID:       "gatorade",
+		Name:     "Gatorade March",
+		Item:     "GATORADE",
+		StartsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Bonus:    200,
+	}
+	detail := func(c Campaign, points int64, reason string) PointsDetail {
+		return PointsDetail{Rule: "campaign", Campaign: c.ID, Points: points, Reason: reason}
+	}
+
 	tests := map[string]struct {
-		db     DB
-		result Points
-		err    error
+		campaigns []Campaign
+		usage     CampaignUsage
+		receipt   Receipt
+		points    int64
+		details   []PointsDetail
 	}{
-		"Successfully retrieves Points": {
-			db: &dbMock{
-				GetPointsResult: Points{ID: id},
-				GetError:        nil,
+		"Multiplier and bonus": {
+			campaigns: []Campaign{weekend, gatorade},
+			receipt:   receipt,
+			points:    28 + 28 + 200,
+			details: []PointsDetail{
+				detail(weekend, 28, "Target weekend campaign: 2x 28 points"),
+				detail(gatorade, 200, "Gatorade March campaign: 200 bonus points"),
 			},
-			result: Points{ID: id},
-			err:    nil,
 		},
-		"Receipt not found": {
-			db: &dbMock{
-				GetPointsResult: Points{},
-				GetError:        ErrReceiptNotFound,
-			},
-			result: Points{},
-			err:    ErrReceiptNotFound,
+		"Other retailer": {
+			campaigns: []Campaign{weekend},
+			receipt:   Receipt{Retailer: "Walgreens", PurchaseDate: purchaseDate, PurchaseTime: purchaseTime},
+			points:    28,
+		},
+		"No matching item": {
+			campaigns: []Campaign{gatorade},
+			receipt:   Receipt{Retailer: "Target", PurchaseDate: purchaseDate, Items: []Item{{ShortDescription: "Doritos"}}},
+			points:    28,
+		},
+		"Before the window": {
+			campaigns: []Campaign{weekend},
+			receipt:   Receipt{Retailer: "Target", PurchaseDate: purchaseDate.AddDate(0, 0, -1), PurchaseTime: purchaseTime},
+			points:    28,
+		},
+		"End of the window": {
+			campaigns: []Campaign{weekend},
+			receipt:   Receipt{Retailer: "Target", PurchaseDate: purchaseDate.AddDate(0, 0, 2)},
+			points:    28,
+		},
+		"User cap": {
+			campaigns: []Campaign{{ID: "gatorade", Name: "Gatorade March", Item: "gatorade", StartsAt: gatorade.StartsAt, EndsAt: gatorade.EndsAt, Bonus: 200, UserCap: 300}},
+			usage:     CampaignUsage{User: map[string]int64{"gatorade": 200}},
+			receipt:   receipt,
+			points:    28 + 100,
+			details:   []PointsDetail{detail(gatorade, 100, "Gatorade March campaign: 200 bonus points, capped at 300 points per user")},
+		},
+		"Budget spent": {
+			campaigns: []Campaign{{ID: "gatorade", Name: "Gatorade March", Item: "gatorade", StartsAt: gatorade.StartsAt, EndsAt: gatorade.EndsAt, Bonus: 200, Budget: 1000}},
+			usage:     CampaignUsage{Spent: map[string]int64{"gatorade": 1000}},
+			receipt:   receipt,
+			points:    28,
 		},
 	}
 
 	for testName, test := range tests {
 		t.Run(testName, func(t *testing.T) {
-			service := NewReceiptService(test.db)
-			response, err := service.GetPoints(id)
+			applied := applyCampaigns(test.campaigns, test.usage, test.receipt, points)
 
-			assert.Equal(t, test.result, response)
-			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.points, applied.Points)
+			assert.Equal(t, append(points.Breakdown[:1:1], test.details...), applied.Breakdown)
+			assert.Len(t, points.Breakdown, 1)
 		})
 	}
 }
 
-func TestReceiptServiceCreate(t *testing.T) {
-	id := uuid.NewString()
-	purchaseDate, _ := time.Parse("2006-01-02", "2024-09-14")
-	purchaseTime, _ := time.Parse("15:04", "14:00")
+func TestReceiptServiceCreateCampaign(t *testing.T) {
+	input := Receipt{
+		UserID:       "user-1",
+		Retailer:     "Target",
+		PurchaseDate: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		Items:        []Item{{ShortDescription: "Gatorade", Price: 500}},
+		Total:        500,
+	}
+	db := &dbMock{CampaignsResult: []Campaign{{
+		ID:       "gatorade",
+		Name:     "Gatorade March",
+		Item:     "gatorade",
+		StartsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Bonus:    200,
+	}}}
+	_, err := NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold), WithTierSet(TierSet{})).Create(input)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", db.UsageUser)
+	assert.Equal(t, map[string]int64{"gatorade": 200}, CampaignPoints(db.CreatePoints))
+	assert.Equal(t, toPoints(DefaultRuleSet(), input).Points+200, db.CreatePoints.Points)
+}
 
-	validItems := []Item{
-		{
-			ShortDescription: "chicken",
-			Price:            500,
+func TestReceiptServiceCreateRuleVersion(t *testing.T) {
+	input := Receipt{Retailer: "retailer", Items: []Item{{ShortDescription: "chicken", Price: 500}}, Total: 500}
+	db := &dbMock{}
+	_, err := NewReceiptService(db, WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", db.CreatePoints.RuleVersion)
+
+	rules := RuleSet{Rules: []Rule{{Name: "retailer_name", Type: RuleAlphanumericCount, Points: 2}}}
+	db = &dbMock{}
+	_, err = NewReceiptService(db, WithRuleSet(rules), WithRiskChecks(DefaultRiskThreshold)).Create(input)
+	assert.NoError(t, err)
+	assert.Equal(t, rules.versioned().Version, db.CreatePoints.RuleVersion)
+	assert.Equal(t, int64(16), db.CreatePoints.Points)
+}
+
+func TestReceiptServiceSaveRuleSet(t *testing.T) {
+	rules := []Rule{{Name: "retailer_name", Type: RuleAlphanumericCount, Points: 2}}
+	tests := map[string]struct {
+		rules RuleSet
+		saved RuleSet
+		err   error
+	}{
+		"Valid": {
+			rules: RuleSet{Version: " v2 ", Rules: rules},
+			saved: RuleSet{Version: "v2", Rules: rules},
+		},
+		"Missing version": {
+			rules: RuleSet{Rules: rules},
+			err:   ErrRuleSetInvalid,
+		},
+		"Invalid rules": {
+			rules: RuleSet{Version: "v2", Rules: []Rule{{Name: "bad", Type: "unknown"}}},
+			err:   ErrRuleSetInvalid,
 		},
 	}
 
-	validReceipt := Receipt{
-		ID:           "",
-		Retailer:     "retailer",
-		PurchaseDate: purchaseDate,
-		PurchaseTime: purchaseTime,
-		Items:        validItems,
-		Total:        500,
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			db := &dbMock{}
+			service := NewReceiptService(db)
+			saved, err := service.SaveRuleSet(test.rules)
+
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.saved, saved)
+			assert.Equal(t, test.saved, db.SavedRuleSet)
+		})
 	}
+}
 
-	createdReceipt := Receipt{
-		ID:           id,
-		Retailer:     "retailer",
-		PurchaseDate: purchaseDate,
-		PurchaseTime: purchaseTime,
-		Items:        validItems,
-		Total:        500,
+func TestReceiptServicePreviewRecompute(t *testing.T) {
+	v2 := RuleSet{Version: "v2", Rules: []Rule{{Name: "retailer_name", Type: RuleAlphanumericCount, Points: 2}}}
+	stored := func(id string, status ReceiptStatus, rules RuleSet, tier string) StoredReceipt {
+		r := Receipt{ID: id, Retailer: "Target", Status: status, Items: []Item{{ShortDescription: "Gatorade", Price: 500}}, Total: 500}
+		points := toPoints(rules, r)
+		points.ID = id
+		points.RuleVersion = rules.Version
+		return StoredReceipt{Receipt: r, Points: DefaultTierSet().named(tier).apply(points)}
+	}
+	approved := stored("approved", StatusApproved, DefaultRuleSet(), "")
+	approved.Points.Points += 200
+	approved.Points.Breakdown = append(approved.Points.Breakdown, PointsDetail{Rule: campaignRule, Campaign: "gatorade", Points: 200})
+	pending := stored("pending", StatusPending, DefaultRuleSet(), "silver")
+	duplicate := stored("duplicate", StatusApproved, DefaultRuleSet(), "")
+	duplicate.Receipt.DuplicateOf = "approved"
+	current := stored("current", StatusApproved, v2, "")
+	rejected := stored("rejected", StatusRejected, DefaultRuleSet(), "")
+
+	db := &dbMock{
+		RuleSetsResult: []RuleSet{DefaultRuleSet(), v2},
+		ListResult:     ReceiptPage{Receipts: []StoredReceipt{approved, pending, duplicate, current, rejected}},
+	}
+	service := NewReceiptService(db, WithTierSet(DefaultTierSet()))
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	rc, err := service.PreviewRecompute(RecomputeRequest{Version: "v2", PurchasedFrom: &from})
+	assert.NoError(t, err)
+	assert.Equal(t, from, db.ListQuery.PurchasedFrom)
+	assert.Equal(t, RecomputationPreview, rc.Status)
+	assert.Equal(t, 3, rc.Receipts)
+	assert.Equal(t, 2, rc.Changed)
+	assert.Equal(t, rc, db.SavedRecomputation)
+	if assert.Len(t, rc.Diffs, 2) {
+		assert.Equal(t, "approved", rc.Diffs[0].ReceiptID)
+		assert.Equal(t, int64(12+200), rc.Diffs[0].After.Points)
+		assert.Equal(t, "v2", rc.Diffs[0].After.RuleVersion)
+		assert.Equal(t, map[string]int64{"gatorade": 200}, CampaignPoints(rc.Diffs[0].After))
+		assert.Equal(t, int64(12+200)-approved.Points.Points, rc.Diffs[0].Delta)
+
+		assert.Equal(t, "pending", rc.Diffs[1].ReceiptID)
+		assert.Equal(t, "silver", rc.Diffs[1].After.Tier)
+		assert.Equal(t, int64(12+DefaultTierSet().named("silver").apply(Points{Points: 12}).TierBonus), rc.Diffs[1].After.Points)
+		assert.Equal(t, int64(0), rc.Diffs[1].Delta)
 	}
+	assert.Equal(t, rc.Diffs[0].Delta, rc.Delta)
+
+	rc, err = service.PreviewRecompute(RecomputeRequest{Version: "v2", FromVersion: "v2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rc.Receipts)
+	assert.Empty(t, rc.Diffs)
+
+	to := from.AddDate(0, -1, 0)
+	_, err = service.PreviewRecompute(RecomputeRequest{Version: "v2", PurchasedFrom: &from, PurchasedTo: &to})
+	assert.ErrorIs(t, err, ErrRecomputationInvalid)
+	_, err = service.PreviewRecompute(RecomputeRequest{Version: "v3"})
+	assert.ErrorIs(t, err, ErrRuleSetNotFound)
+}
+
+func TestReceiptServiceEnqueue(t *testing.T) {
+	input := Receipt{UserID: "user-1", Retailer: "Target", Total: 500}
+	db := &dbMock{}
+	job, err := NewReceiptService(db, WithJobQueueSize(10)).Enqueue(input)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, job.ID)
+	assert.Equal(t, JobQueued, job.Status)
+	queued := input
+	queued.JobID = job.ID
+	assert.Equal(t, queued, job.Receipt)
+	assert.Equal(t, job, db.EnqueuedJob)
+	assert.Equal(t, 10, db.QueueCapacity)
+
+	db = &dbMock{CreateError: ErrJobQueueFull}
+	_, err = NewReceiptService(db).Enqueue(input)
+	assert.ErrorIs(t, err, ErrJobQueueFull)
+	assert.Equal(t, DefaultJobQueueSize, db.QueueCapacity)
+}
+
+func TestReceiptServicePruneJobs(t *testing.T) {
+	db := &dbMock{}
+	pruned, err := NewReceiptService(db, WithJobRetention(time.Hour)).PruneJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), db.PrunedBefore, time.Minute)
+}
 
+func TestReceiptServiceFinishJob(t *testing.T) {
+	running := Job{ID: "job", Status: JobRunning, Attempts: 1}
+	duplicate := apperrors.NewAppError(apperrors.ReceiptDuplicate, "The receipt was already processed")
 	tests := map[string]struct {
-		db     DB
-		result Receipt
-		input  Receipt
-		err    error
+		job       Job
+		receiptID string
+		problem   *apperrors.AppError
+		status    JobStatus
+		unsaved   bool
+		err       error
 	}{
-		"Success": {
-			db: &dbMock{
-				CreateResult: createdReceipt,
-				CreateError:  nil,
-			},
-			input:  validReceipt,
-			result: createdReceipt,
-			err:    nil,
+		"Succeeded": {
+			job:       running,
+			receiptID: "receipt",
+			status:    JobSucceeded,
+		},
+		"Finished when the receipt was stored": {
+			job:       Job{ID: "job", Status: JobSucceeded, ReceiptID: "receipt", FinishedAt: &time.Time{}},
+			receiptID: "receipt",
+			status:    JobSucceeded,
+			unsaved:   true,
+		},
+		"Failed": {
+			job:     running,
+			problem: duplicate,
+			status:  JobFailed,
+		},
+		"Not running": {
+			job: Job{ID: "job", Status: JobSucceeded},
+			err: ErrJobStatusConflict,
 		},
 	}
 
 	for testName, test := range tests {
 		t.Run(testName, func(t *testing.T) {
-			service := NewReceiptService(test.db)
-			response, err := service.Create(test.input)
+			db := &dbMock{JobResult: test.job}
+			job, err := NewReceiptService(db).FinishJob("job", test.receiptID, test.problem)
 
-			assert.Equal(t, test.result, response)
-			assert.Equal(t, test.err, err)
+			assert.ErrorIs(t, err, test.err)
+			if test.err != nil || test.unsaved {
+				assert.Equal(t, Job{}, db.SavedJob)
+			} else {
+				assert.Equal(t, job, db.SavedJob)
+			}
+			if test.err != nil {
+				return
+			}
+			assert.Equal(t, test.status, job.Status)
+			assert.Equal(t, test.receiptID, job.ReceiptID)
+			assert.Equal(t, test.problem, job.Error)
+			assert.NotNil(t, job.FinishedAt)
 		})
 	}
 }
 
+func TestReceiptServiceRewards(t *testing.T) {
+	db := &dbMock{RewardsResult: []Reward{
+		{ID: "hat", Cost: 20},
+		{ID: "mug", Cost: 8},
+		{ID: "cap", Cost: 20},
+	}}
+	rewards, err := NewReceiptService(db).Rewards()
+	assert.NoError(t, err)
+	assert.Equal(t, []Reward{{ID: "mug", Cost: 8}, {ID: "cap", Cost: 20}, {ID: "hat", Cost: 20}}, rewards)
+
+	first := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	db = &dbMock{RedemptionsResult: []Redemption{{ID: "1", CreatedAt: first}, {ID: "2", CreatedAt: first.Add(time.Hour)}}}
+	redemptions, err := NewReceiptService(db).Redemptions("user")
+	assert.NoError(t, err)
+	assert.Equal(t, []Redemption{{ID: "2", CreatedAt: first.Add(time.Hour)}, {ID: "1", CreatedAt: first}}, redemptions)
+}
+
+func TestLedgerPoints(t *testing.T) {
+	at := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	purchaseDate := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	receipt := Receipt{ID: "receipt", UserID: "user", PurchaseDate: purchaseDate}
+
+	entry, ok := LedgerPoints(receipt, 0, 28, at)
+	assert.True(t, ok)
+	assert.Equal(t, LedgerEntry{UserID: "user", ReceiptID: "receipt", Type: LedgerEarn, Points: 28, At: at}, entry)
+
+	entry, ok = LedgerPoints(receipt, 28, 0, at)
+	assert.True(t, ok)
+	assert.Equal(t, LedgerEntry{UserID: "user", ReceiptID: "receipt", Type: LedgerReversal, Points: -28, At: at}, entry)
+
+	_, ok = LedgerPoints(receipt, 0, 0, at)
+	assert.False(t, ok)
+
+	receipt.Expiry = &ExpiryPolicy{Version: "v2", Months: 12, Basis: ExpiryFromPurchase}
+	entry, ok = LedgerPoints(receipt, 0, 28, at)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), *entry.ExpiresAt)
+	assert.Equal(t, "v2", entry.Policy)
+
+	entry, ok = LedgerPoints(receipt, 28, 0, at)
+	assert.True(t, ok)
+	assert.Nil(t, entry.ExpiresAt)
+	assert.Empty(t, entry.Policy)
+}
+
+func TestToFingerprint(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2024-09-14")
+	purchaseTime, _ := time.Parse("15:04", "14:00")
+	receipt := Receipt{
+		Retailer:     "Target",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items: []Item{
+			{ShortDescription: "Pepsi", Price: 125},
+			{ShortDescription: "Dasani", Price: 140},
+		},
+		Total: 265,
+	}
+	reordered := receipt
+	reordered.Retailer = " target "
+	reordered.Items = []Item{
+		{ShortDescription: "DASANI ", Price: 140},
+		{ShortDescription: "Pepsi", Price: 125},
+	}
+	otherTotal := receipt
+	otherTotal.Total = 266
+	otherItem := receipt
+	otherItem.Items = []Item{{ShortDescription: "Pepsi", Price: 265}}
+
+	assert.Equal(t, toFingerprint(receipt), toFingerprint(reordered))
+	assert.NotEqual(t, toFingerprint(receipt), toFingerprint(otherTotal))
+	assert.NotEqual(t, toFingerprint(receipt), toFingerprint(otherItem))
+}
+
 func TestReceiptToPoints(t *testing.T) {
 	targetPurchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
 	targetPurchaseTime, _ := time.Parse("15:04", "13:01")
@@ -177,11 +2042,32 @@ func TestReceiptToPoints(t *testing.T) {
 	targetPoints := Points{
 		ID:     "",
 		Points: 28,
+		Breakdown: []PointsDetail{
+			{Rule: "retailer_name", Points: 6, Reason: `6 alphanumeric characters in retailer name "Target"`},
+			{Rule: "item_pairs", Points: 10, Reason: "5 items on the receipt, 5 points for every 2 items"},
+			{
+				Rule:   "item_description_length",
+				Points: 6,
+				Reason: "trimmed item description length is a multiple of 3, price multiplied by 0.2 and rounded up",
+				Items: []ItemPoints{
+					{Index: 1, ShortDescription: "Emils Cheese Pizza", Points: 3},
+					{Index: 4, ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Points: 3},
+				},
+			},
+			{Rule: "odd_purchase_day", Points: 6, Reason: "purchase day 1 is odd"},
+		},
 	}
 
 	cornerMarketPoints := Points{
 		ID:     "",
 		Points: 109,
+		Breakdown: []PointsDetail{
+			{Rule: "retailer_name", Points: 14, Reason: `14 alphanumeric characters in retailer name "M&M Corner Market"`},
+			{Rule: "round_dollar_total", Points: 50, Reason: "total 9.00 is a multiple of 1.00"},
+			{Rule: "quarter_multiple_total", Points: 25, Reason: "total 9.00 is a multiple of 0.25"},
+			{Rule: "item_pairs", Points: 10, Reason: "4 items on the receipt, 5 points for every 2 items"},
+			{Rule: "afternoon_purchase", Points: 10, Reason: "purchase time 14:33 is after 14:00 and before 16:00"},
+		},
 	}
 
 	tests := map[string]struct {
@@ -200,9 +2086,283 @@ func TestReceiptToPoints(t *testing.T) {
 
 	for testName, test := range tests {
 		t.Run(testName, func(t *testing.T) {
-			points := toPoints(test.input)
+			points := toPoints(DefaultRuleSet(), test.input)
 
 			assert.Equal(t, test.result, points)
 		})
 	}
 }
+
+func TestLoadRuleSet(t *testing.T) {
+	rules, err := LoadRuleSet("../../rules.json")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRuleSet(), rules)
+
+	path := filepath.Join(t.TempDir(), "unversioned.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "retailer_name", "type": "alphanumeric_count", "points": 2}]}`), 0o644))
+	rules, err = LoadRuleSet(path)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^sha256-[0-9a-f]{12}$`, rules.Version)
+	again, err := LoadRuleSet(path)
+	assert.NoError(t, err)
+	assert.Equal(t, rules.Version, again.Version)
+
+	path = filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "bad", "type": "unknown"}]}`), 0o644))
+	_, err = LoadRuleSet(path)
+	assert.Error(t, err)
+}
+
+func TestRuleSetPoints(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2022-01-02")
+	purchaseTime, _ := time.Parse("15:04", "09:30")
+	receipt := Receipt{
+		Retailer:     "Corner Store",
+		PurchaseDate: purchaseDate,
+		PurchaseTime: purchaseTime,
+		Items: []Item{
+			{ShortDescription: "milk", Price: 350},
+			{ShortDescription: "eggs", Price: 400},
+			{ShortDescription: "bread", Price: 250},
+		},
+		Total: 1000,
+	}
+
+	tests := map[string]struct {
+		rules  RuleSet
+		result int64
+	}{
+		"Ten dollar total bonus": {
+			rules:  RuleSet{Rules: []Rule{{Name: "ten_dollars", Type: RuleTotalMultiple, Points: 100, Multiple: 1000}}},
+			result: 100,
+		},
+		"Points per item": {
+			rules:  RuleSet{Rules: []Rule{{Name: "every_item", Type: RuleItemCount, Points: 3, Multiple: 1}}},
+			result: 9,
+		},
+		"Even day": {
+			rules:  RuleSet{Rules: []Rule{{Name: "even_day", Type: RulePurchaseDay, Points: 4, Parity: "even"}}},
+			result: 4,
+		},
+		"Morning window": {
+			rules:  RuleSet{Rules: []Rule{{Name: "morning", Type: RulePurchaseTime, Points: 8, Start: "09:00", End: "11:00"}}},
+			result: 8,
+		},
+		"Four letter descriptions": {
+			rules: RuleSet{Rules: []Rule{
+				{Name: "four_letters", Type: RuleDescriptionLength, Multiple: 4, Multiplier: 1},
+			}},
+			result: 8,
+		},
+		"Rules are summed": {
+			rules: RuleSet{Rules: []Rule{
+				{Name: "retailer", Type: RuleAlphanumericCount, Points: 2},
+				{Name: "every_item", Type: RuleItemCount, Points: 3, Multiple: 1},
+			}},
+			result: 31,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.NoError(t, test.rules.Validate())
+			assert.Equal(t, test.result, toPoints(test.rules, receipt).Points)
+		})
+	}
+}
+
+func TestRuleSetValidate(t *testing.T) {
+	tests := map[string]RuleSet{
+		"Missing name":       {Rules: []Rule{{Type: RuleAlphanumericCount}}},
+		"Duplicate name":     {Rules: []Rule{{Name: "a", Type: RuleAlphanumericCount}, {Name: "a", Type: RuleAlphanumericCount}}},
+		"Unknown type":       {Rules: []Rule{{Name: "a", Type: "unknown"}}},
+		"Missing multiple":   {Rules: []Rule{{Name: "a", Type: RuleTotalMultiple, Points: 1}}},
+		"Missing multiplier": {Rules: []Rule{{Name: "a", Type: RuleDescriptionLength, Multiple: 3}}},
+		"Invalid parity":     {Rules: []Rule{{Name: "a", Type: RulePurchaseDay, Parity: "weekday"}}},
+		"Invalid window":     {Rules: []Rule{{Name: "a", Type: RulePurchaseTime, Start: "16:00", End: "14:00"}}},
+	}
+
+	for testName, rules := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Error(t, rules.Validate())
+		})
+	}
+}
+
+func TestReceiptQueryPaginate(t *testing.T) {
+	newStored := func(id string, retailer string, date string, total Money, points int64) StoredReceipt {
+		purchaseDate, _ := time.Parse("2006-01-02", date)
+		purchaseTime, _ := time.Parse("15:04", "12:00")
+		return StoredReceipt{
+			Receipt: Receipt{ID: id, Retailer: retailer, PurchaseDate: purchaseDate, PurchaseTime: purchaseTime, Total: total, Status: StatusApproved},
+			Points:  Points{ID: id, Points: points},
+		}
+	}
+	// e was rejected, so its points are not awarded and neither filter nor sort on them.
+	rejected := newStored("e", "Walgreens", "2022-01-05", 50, 100)
+	rejected.Receipt.Status = StatusRejected
+	all := []StoredReceipt{
+		newStored("a", "Target", "2022-01-03", 1000, 30),
+		newStored("b", "Walgreens", "2022-01-01", 500, 10),
+		newStored("c", "Target", "2022-01-02", 2500, 50),
+		newStored("d", "Super Target", "2022-01-04", 100, 20),
+		rejected,
+	}
+	ids := func(page ReceiptPage) []string {
+		var result []string
+		for _, stored := range page.Receipts {
+			result = append(result, stored.Receipt.ID)
+		}
+		return result
+	}
+	from, _ := time.Parse("2006-01-02", "2022-01-02")
+	to, _ := time.Parse("2006-01-02", "2022-01-03")
+	minTotal, maxTotal, minPoints := Money(500), Money(1000), int64(25)
+
+	tests := map[string]struct {
+		query  ReceiptQuery
+		result []string
+	}{
+		"Sorted by purchase date":  {query: ReceiptQuery{}, result: []string{"b", "c", "a", "d", "e"}},
+		"Sorted by points desc":    {query: ReceiptQuery{SortBy: SortByPoints, Descending: true}, result: []string{"c", "a", "d", "b", "e"}},
+		"Exact retailer":           {query: ReceiptQuery{Retailer: "Target"}, result: []string{"c", "a"}},
+		"Retailer substring":       {query: ReceiptQuery{RetailerContains: "target"}, result: []string{"c", "a", "d"}},
+		"Purchase date range":      {query: ReceiptQuery{PurchasedFrom: from, PurchasedTo: to}, result: []string{"c", "a"}},
+		"Total range":              {query: ReceiptQuery{MinTotal: &minTotal, MaxTotal: &maxTotal}, result: []string{"b", "a"}},
+		"Minimum points":           {query: ReceiptQuery{MinPoints: &minPoints}, result: []string{"c", "a"}},
+		"Combined filters, no hit": {query: ReceiptQuery{Retailer: "Walgreens", MinPoints: &minPoints}, result: nil},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.NoError(t, test.query.Validate())
+			page := test.query.Paginate(all)
+
+			assert.Equal(t, test.result, ids(page))
+			assert.Equal(t, "", page.NextCursor)
+		})
+	}
+
+	t.Run("Cursor pagination", func(t *testing.T) {
+		for _, descending := range []bool{false, true} {
+			var seen []string
+			query := ReceiptQuery{SortBy: SortByPoints, Descending: descending, Limit: 3}
+			for {
+				assert.NoError(t, query.Validate())
+				page := query.Paginate(all)
+				seen = append(seen, ids(page)...)
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if descending {
+				assert.Equal(t, []string{"c", "a", "d", "b", "e"}, seen)
+			} else {
+				assert.Equal(t, []string{"e", "b", "d", "a", "c"}, seen)
+			}
+		}
+	})
+
+	t.Run("Cursor from a different sort is rejected", func(t *testing.T) {
+		query := ReceiptQuery{Limit: 1}
+		assert.NoError(t, query.Validate())
+		page := query.Paginate(all)
+
+		query = ReceiptQuery{SortBy: SortByPoints, Cursor: page.NextCursor}
+		assert.Equal(t, ErrQueryInvalid, query.Validate())
+	})
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		result Money
+		err    error
+	}{
+		"Dollars and cents":   {input: "35.35", result: 3535, err: nil},
+		"Zero":                {input: "0.00", result: 0, err: nil},
+		"Leading zeros":       {input: "007.10", result: 710, err: nil},
+		"Largest amount":      {input: "92233720368547758.07", result: math.MaxInt64, err: nil},
+		"Overflow":            {input: "92233720368547758.08", result: 0, err: ErrMoneyOverflow},
+		"Too many digits":     {input: "999999999999999999999.00", result: 0, err: ErrMoneyOverflow},
+		"Exponent":            {input: "1e2", result: 0, err: ErrMoneyInvalid},
+		"NaN":                 {input: "NaN", result: 0, err: ErrMoneyInvalid},
+		"Negative":            {input: "-3.50", result: 0, err: ErrMoneyInvalid},
+		"Too many decimals":   {input: "12.345", result: 0, err: ErrMoneyInvalid},
+		"Missing cents":       {input: "12", result: 0, err: ErrMoneyInvalid},
+		"Missing dollars":     {input: ".50", result: 0, err: ErrMoneyInvalid},
+		"Surrounding spaces":  {input: " 1.00", result: 0, err: ErrMoneyInvalid},
+		"Empty":               {input: "", result: 0, err: ErrMoneyInvalid},
+		"Thousands separator": {input: "1,000.00", result: 0, err: ErrMoneyInvalid},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			result, err := ParseMoney(test.input)
+
+			assert.Equal(t, test.result, result)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestMoney(t *testing.T) {
+	assert.Equal(t, "35.35", Money(3535).String())
+	assert.Equal(t, "0.05", Money(5).String())
+	assert.Equal(t, "-1.05", Money(-105).String())
+	assert.Equal(t, "-92233720368547758.08", Money(math.MinInt64).String())
+
+	sum, err := Money(100).Add(-250)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(-150), sum)
+
+	_, err = Money(math.MaxInt64).Add(1)
+	assert.Equal(t, ErrMoneyOverflow, err)
+	_, err = Money(math.MinInt64).Add(-1)
+	assert.Equal(t, ErrMoneyOverflow, err)
+}
+
+func TestLegs(t *testing.T) {
+	tests := map[string]struct {
+		entry LedgerEntry
+		legs  []LedgerLeg
+	}{
+		"Earn": {
+			entry: LedgerEntry{UserID: "user", Type: LedgerEarn, Points: 10},
+			legs:  []LedgerLeg{{Account: "user:user", Points: 10}, {Account: AccountIssuance, Points: -10}},
+		},
+		"Anonymous reversal": {
+			entry: LedgerEntry{Type: LedgerReversal, Points: -10},
+			legs:  []LedgerLeg{{Account: AccountAnonymous, Points: -10}, {Account: AccountIssuance, Points: 10}},
+		},
+		"Adjustment": {
+			entry: LedgerEntry{UserID: "user", Type: LedgerAdjustment, Points: -2},
+			legs:  []LedgerLeg{{Account: "user:user", Points: -2}, {Account: AccountIssuance, Points: 2}},
+		},
+		"Redemption": {
+			entry: LedgerEntry{UserID: "user", Type: LedgerRedemption, Points: -500},
+			legs:  []LedgerLeg{{Account: "user:user", Points: -500}, {Account: AccountRedemptions, Points: 500}},
+		},
+		"Refund": {
+			entry: LedgerEntry{UserID: "user", Type: LedgerRefund, Points: 500},
+			legs:  []LedgerLeg{{Account: "user:user", Points: 500}, {Account: AccountRedemptions, Points: -500}},
+		},
+		"Expiry": {
+			entry: LedgerEntry{UserID: "user", Type: LedgerExpiry, Points: -7},
+			legs:  []LedgerLeg{{Account: "user:user", Points: -7}, {Account: AccountExpiry, Points: 7}},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, test.legs, Legs(test.entry))
+		})
+	}
+
+	userID, ok := AccountUser(UserAccount("user"))
+	assert.True(t, ok)
+	assert.Equal(t, "user", userID)
+	_, ok = AccountUser(UserAccount(""))
+	assert.False(t, ok)
+}
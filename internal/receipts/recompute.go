@@ -0,0 +1,249 @@
+package receipts
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecomputationStatus is the state of a recomputation.
+type RecomputationStatus string
+
+const (
+	// RecomputationPreview is a recomputation whose points were not changed yet.
+	RecomputationPreview RecomputationStatus = "preview"
+	// RecomputationCommitted is a recomputation whose points replaced those of its receipts.
+	RecomputationCommitted RecomputationStatus = "committed"
+)
+
+// RecomputeRequest selects the receipts to recompute and the rules to recompute them under.
+// Version: The version of the rule set to recompute under.
+// PurchasedFrom, PurchasedTo: Inclusive range of purchase dates, nil for no bound.
+// FromVersion: Only recompute receipts scored under this version, empty for any.
+type RecomputeRequest struct {
+	Version       string     `json:"version"`
+	PurchasedFrom *time.Time `json:"purchasedFrom,omitempty"`
+	PurchasedTo   *time.Time `json:"purchasedTo,omitempty"`
+	FromVersion   string     `json:"fromVersion,omitempty"`
+}
+
+// RecomputeDiff
+// ReceiptID: The receipt whose points change.
+// UserID: The user the receipt belongs to, empty for anonymous receipts.
+// Status: The status of the receipt, its points are only awarded while approved.
+// Before: The stored points.
+// After: The points under the new rule set.
+// Delta: The change in the points awarded, 0 for receipts that are not approved.
+type RecomputeDiff struct {
+	ReceiptID string        `json:"receiptId"`
+	UserID    string        `json:"userId,omitempty"`
+	Status    ReceiptStatus `json:"status"`
+	Before    Points        `json:"before"`
+	After     Points        `json:"after"`
+	Delta     int64         `json:"delta"`
+}
+
+// Recomputation is the report of recomputing the points of a range of
+// receipts, previewed before it is committed.
+// ID: The ID of the recomputation.
+// Request: The receipts and rule set it was previewed for.
+// Status: preview, or committed once the points were replaced.
+// Receipts: The number of receipts selected.
+// Changed: The number of receipts whose points change.
+// Delta: The change in the points awarded across all receipts.
+// Diffs: Every receipt scored under another version or whose points change.
+// CreatedAt: When it was previewed.
+// CommittedAt: When it was committed, if it was.
+// Actor: Who committed it.
+type Recomputation struct {
+	ID          string              `json:"id"`
+	Request     RecomputeRequest    `json:"request"`
+	Status      RecomputationStatus `json:"status"`
+	Receipts    int                 `json:"receipts"`
+	Changed     int                 `json:"changed"`
+	Delta       int64               `json:"delta"`
+	Diffs       []RecomputeDiff     `json:"diffs"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	CommittedAt *time.Time          `json:"committedAt,omitempty"`
+	Actor       string              `json:"actor,omitempty"`
+}
+
+// rescore returns the points of a stored receipt under rules. The campaign
+// points it was awarded are kept as they were, and the bonus of the tier it
+// was scored in is worked out again.
+func (r *receipt) rescore(rules RuleSet, stored StoredReceipt) Points {
+	points := toPoints(rules, stored.Receipt)
+	points.ID = stored.Points.ID
+	points.RuleVersion = rules.Version
+	for _, detail := range stored.Points.Breakdown {
+		if detail.Rule == campaignRule {
+			points.Points += detail.Points
+			points.Breakdown = append(points.Breakdown, detail)
+		}
+	}
+	return r.tiers.named(stored.Points.Tier).apply(points)
+}
+
+// RuleSets returns every registered rule set, ordered by version.
+func (r *receipt) RuleSets() ([]RuleSet, error) {
+	ruleSets, err := r.db.RuleSets()
+	if err != nil {
+		log.WithError(err).Error("Failed to list rule sets")
+		return nil, err
+	}
+	sort.Slice(ruleSets, func(i, j int) bool {
+		return ruleSets[i].Version < ruleSets[j].Version
+	})
+	return ruleSets, nil
+}
+
+// SaveRuleSet registers a rule set under its version. Versions cannot be
+// reused for other rules, saving the same rules again is a no-op.
+func (r *receipt) SaveRuleSet(rules RuleSet) (RuleSet, error) {
+	rules.Version = strings.TrimSpace(rules.Version)
+	if rules.Version == "" {
+		return RuleSet{}, fmt.Errorf("%w: a version is required", ErrRuleSetInvalid)
+	}
+	if err := rules.Validate(); err != nil {
+		return RuleSet{}, fmt.Errorf("%w: %s", ErrRuleSetInvalid, err)
+	}
+
+	if err := r.db.SaveRuleSet(rules); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"version": rules.Version,
+		}).Warn("Failed to save rule set")
+		return RuleSet{}, err
+	}
+	return rules, nil
+}
+
+// ruleSet returns the registered rule set with version.
+func (r *receipt) ruleSet(version string) (RuleSet, error) {
+	ruleSets, err := r.RuleSets()
+	if err != nil {
+		return RuleSet{}, err
+	}
+	for _, rules := range ruleSets {
+		if rules.Version == version {
+			return rules, nil
+		}
+	}
+	return RuleSet{}, ErrRuleSetNotFound
+}
+
+// PreviewRecompute works out the points of the approved and pending receipts
+// purchased in the requested range under another rule set, and stores the
+// report to be committed later. No points change until it is committed.
+// Duplicates keep their zero points.
+func (r *receipt) PreviewRecompute(req RecomputeRequest) (Recomputation, error) {
+	rules, err := r.ruleSet(req.Version)
+	if err != nil {
+		return Recomputation{}, err
+	}
+	q := ReceiptQuery{Limit: MaxPageSize}
+	if req.PurchasedFrom != nil {
+		q.PurchasedFrom = *req.PurchasedFrom
+	}
+	if req.PurchasedTo != nil {
+		q.PurchasedTo = *req.PurchasedTo
+	}
+	if err := q.Validate(); err != nil {
+		return Recomputation{}, fmt.Errorf("%w: purchasedFrom must not be after purchasedTo", ErrRecomputationInvalid)
+	}
+
+	rc := Recomputation{
+		ID:        uuid.NewString(),
+		Request:   req,
+		Status:    RecomputationPreview,
+		Diffs:     []RecomputeDiff{},
+		CreatedAt: time.Now().UTC(),
+	}
+	for {
+		page, err := r.db.List(q)
+		if err != nil {
+			log.WithError(err).Error("Failed to list receipts to recompute")
+			return Recomputation{}, err
+		}
+		for _, stored := range page.Receipts {
+			status := stored.Receipt.Status
+			if (status != StatusApproved && status != StatusPending) || stored.Receipt.DuplicateOf != "" ||
+				(req.FromVersion != "" && stored.Points.RuleVersion != req.FromVersion) {
+				continue
+			}
+			rc.Receipts++
+
+			after := r.rescore(rules, stored)
+			if after.Points == stored.Points.Points && after.RuleVersion == stored.Points.RuleVersion {
+				continue
+			}
+			diff := RecomputeDiff{
+				ReceiptID: stored.Receipt.ID,
+				UserID:    stored.Receipt.UserID,
+				Status:    status,
+				Before:    stored.Points,
+				After:     after,
+				Delta:     StoredReceipt{Receipt: stored.Receipt, Points: after}.Awarded() - stored.Awarded(),
+			}
+			if after.Points != stored.Points.Points {
+				rc.Changed++
+			}
+			rc.Delta += diff.Delta
+			rc.Diffs = append(rc.Diffs, diff)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	if err := r.db.SaveRecomputation(rc); err != nil {
+		log.WithError(err).Error("Failed to save recomputation")
+		return Recomputation{}, err
+	}
+	log.WithFields(log.Fields{
+		"ID":       rc.ID,
+		"version":  rules.Version,
+		"receipts": rc.Receipts,
+		"changed":  rc.Changed,
+		"delta":    rc.Delta,
+	}).Info("Recomputation previewed")
+	return rc, nil
+}
+
+// Recomputation returns a recomputation report.
+func (r *receipt) Recomputation(id string) (Recomputation, error) {
+	rc, err := r.db.Recomputation(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ID": id,
+		}).Warn("Failed to retrieve recomputation")
+		return Recomputation{}, err
+	}
+	return rc, nil
+}
+
+// CommitRecompute replaces the points of the receipts of a previewed
+// recomputation and posts the change in the points of approved receipts to
+// the ledger. It returns ErrRecomputationStale without changing anything if
+// any of the receipts changed since the preview.
+func (r *receipt) CommitRecompute(id string, actor string) (Recomputation, error) {
+	rc, err := r.db.CommitRecomputation(id, actor, time.Now().UTC())
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID": id,
+		}).Warn("Failed to commit recomputation")
+		return Recomputation{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":      id,
+		"version": rc.Request.Version,
+		"changed": rc.Changed,
+		"delta":   rc.Delta,
+		"actor":   actor,
+	}).Info("Recomputation committed")
+	return rc, nil
+}
@@ -0,0 +1,93 @@
+package receipts
+
+import (
+	apperrors "fetch_take_home/errors"
+	"fmt"
+)
+
+// ReconcilePolicy decides what happens to a receipt whose total does not add up.
+type ReconcilePolicy string
+
+const (
+	// ReconcileReject fails the submission with ErrReceiptTotalMismatch.
+	ReconcileReject ReconcilePolicy = "reject"
+	// ReconcileFlag stores the receipt and records the mismatch on it.
+	ReconcileFlag ReconcilePolicy = "flag"
+)
+
+// ParseReconcilePolicy returns the ReconcilePolicy named s.
+func ParseReconcilePolicy(s string) (ReconcilePolicy, error) {
+	switch policy := ReconcilePolicy(s); policy {
+	case ReconcileReject, ReconcileFlag:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown reconcile policy %q", s)
+	}
+}
+
+// ReconciliationStatus is the outcome of comparing a receipt total to its lines.
+type ReconciliationStatus string
+
+const (
+	ReconciliationMatched  ReconciliationStatus = "matched"
+	ReconciliationMismatch ReconciliationStatus = "mismatch"
+)
+
+// Reconciliation
+// ItemsTotal: The sum of the item prices.
+// Expected: ItemsTotal plus tax and tip, less the discount.
+// Difference: Total less Expected, positive when the total claims more than the lines.
+// Status: Whether Difference is within the tolerance.
+type Reconciliation struct {
+	ItemsTotal Money                `json:"itemsTotal"`
+	Expected   Money                `json:"expected"`
+	Difference Money                `json:"difference"`
+	Status     ReconciliationStatus `json:"status"`
+}
+
+// reconcile compares the total of receipt with its items, tax, tip and
+// discount. A difference of at most tolerance in either direction matches.
+func reconcile(receipt Receipt, tolerance Money) (Reconciliation, error) {
+	var itemsTotal Money
+	for _, item := range receipt.Items {
+		sum, err := itemsTotal.Add(item.Price)
+		if err != nil {
+			return Reconciliation{}, &ValidationError{Fields: []apperrors.FieldError{{
+				Path:    "/items",
+				Code:    apperrors.FieldTooLarge,
+				Message: "item prices add up to more than can be represented",
+			}}}
+		}
+		itemsTotal = sum
+	}
+
+	expected, err := itemsTotal.Add(receipt.Tax)
+	if err == nil {
+		expected, err = expected.Add(receipt.Tip)
+	}
+	if err == nil {
+		expected, err = expected.Add(-receipt.Discount)
+	}
+	var difference Money
+	if err == nil {
+		difference, err = receipt.Total.Add(-expected)
+	}
+	if err != nil {
+		return Reconciliation{}, &ValidationError{Fields: []apperrors.FieldError{{
+			Path:    "/total",
+			Code:    apperrors.FieldTooLarge,
+			Message: "total, tax, tip and discount add up to more than can be represented",
+		}}}
+	}
+
+	status := ReconciliationMatched
+	if difference > tolerance || difference < -tolerance {
+		status = ReconciliationMismatch
+	}
+	return Reconciliation{
+		ItemsTotal: itemsTotal,
+		Expected:   expected,
+		Difference: difference,
+		Status:     status,
+	}, nil
+}
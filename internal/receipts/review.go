@@ -0,0 +1,167 @@
+package receipts
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// AuditEntry
+// ReceiptID: The receipt whose status changed.
+// From: The status before the change, empty when the receipt was submitted.
+// To: The status after the change.
+// Reason: Why the status changed.
+// Actor: Who changed the status.
+// Reversed: The points taken back by the change, when an approved receipt is
+// voided, neither expired points nor more than the balance of its user.
+// At: When the status changed.
+type AuditEntry struct {
+	ReceiptID string        `json:"receiptId"`
+	From      ReceiptStatus `json:"from,omitempty"`
+	To        ReceiptStatus `json:"to"`
+	Reason    string        `json:"reason,omitempty"`
+	Actor     string        `json:"actor,omitempty"`
+	Reversed  int64         `json:"reversed,omitempty"`
+	At        time.Time     `json:"at"`
+}
+
+// Tombstone
+// ID: The ID of the deleted receipt.
+// Reason: Why the receipt was deleted.
+// Actor: Who deleted the receipt.
+// DeletedAt: When the receipt was deleted.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// Review approves or rejects a receipt that is held for review.
+func (r *receipt) Review(id string, decision ReceiptStatus, reason string, actor string) (StoredReceipt, error) {
+	if decision != StatusApproved && decision != StatusRejected {
+		return StoredReceipt{}, fmt.Errorf("%w: decision must be %s or %s", ErrReviewInvalid, StatusApproved, StatusRejected)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return StoredReceipt{}, fmt.Errorf("%w: a reason is required", ErrReviewInvalid)
+	}
+
+	stored, err := r.db.Transition(AuditEntry{
+		ReceiptID: id,
+		From:      StatusPending,
+		To:        decision,
+		Reason:    reason,
+		Actor:     actor,
+		At:        time.Now().UTC(),
+	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID":       id,
+			"decision": decision,
+		}).Error("Failed to review receipt")
+		return StoredReceipt{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":       id,
+		"decision": decision,
+		"actor":    actor,
+	}).Info("Receipt reviewed")
+	return stored, nil
+}
+
+func (r *receipt) Audit(id string) ([]AuditEntry, error) {
+	entries, err := r.db.Audit(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ID": id,
+		}).Error("Failed to retrieve audit trail")
+		return nil, err
+	}
+	return entries, nil
+}
+
+// voidEntry returns the audit entry that voids stored and reverses the
+// points it was awarded, if any.
+func voidEntry(stored StoredReceipt, reason string, actor string) AuditEntry {
+	entry := AuditEntry{
+		ReceiptID: stored.Receipt.ID,
+		From:      stored.Receipt.Status,
+		To:        StatusVoided,
+		Reason:    reason,
+		Actor:     actor,
+		At:        time.Now().UTC(),
+	}
+	if stored.Receipt.Status == StatusApproved {
+		entry.Reversed = stored.Points.Points
+	}
+	return entry
+}
+
+// Void withdraws an approved or pending receipt and reverses its points. The
+// receipt is kept with the voided status, so its id answers with ErrReceiptGone.
+func (r *receipt) Void(id string, reason string, actor string) error {
+	stored, err := r.GetReceipt(id)
+	if err != nil {
+		return err
+	}
+	if stored.Receipt.Status != StatusApproved && stored.Receipt.Status != StatusPending {
+		return fmt.Errorf("%w: receipt is %s", ErrReceiptStatusConflict, stored.Receipt.Status)
+	}
+
+	entry := voidEntry(stored, reason, actor)
+	if _, err := r.db.Transition(entry); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID": id,
+		}).Error("Failed to void receipt")
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":       id,
+		"actor":    actor,
+		"reversed": r.reversed(entry),
+	}).Info("Receipt voided")
+	return nil
+}
+
+// Delete removes a receipt in any status and its points, leaving a tombstone
+// behind. The audit trail is kept and records the receipt as voided.
+func (r *receipt) Delete(id string, reason string, actor string) error {
+	stored, err := r.db.GetReceipt(id)
+	if err != nil {
+		return err
+	}
+
+	entry := voidEntry(stored, reason, actor)
+	if err := r.db.Delete(entry); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID": id,
+		}).Error("Failed to delete receipt")
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":       id,
+		"actor":    actor,
+		"reversed": r.reversed(entry),
+	}).Info("Receipt deleted")
+	return nil
+}
+
+// reversed returns the points the change in entry actually took back, as
+// recorded in the audit trail, which leaves out expired points and what the
+// balance of the user did not cover.
+func (r *receipt) reversed(entry AuditEntry) int64 {
+	trail, err := r.db.Audit(entry.ReceiptID)
+	if err != nil {
+		return 0
+	}
+	for i := len(trail) - 1; i >= 0; i-- {
+		if trail[i].To == entry.To && trail[i].At.Equal(entry.At) {
+			return trail[i].Reversed
+		}
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package receipts
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCancellationWindow is how long after redeeming a reward the redemption can be cancelled.
+const DefaultCancellationWindow = 30 * time.Minute
+
+// Reward
+// ID: The ID of the reward, chosen by the admin who adds it to the catalog.
+// Name: What the user gets.
+// Cost: The points a redemption debits.
+// Stock: How many more times the reward can be redeemed.
+type Reward struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Cost  int64  `json:"cost"`
+	Stock int    `json:"stock"`
+}
+
+// RedemptionStatus is the state of a redemption.
+type RedemptionStatus string
+
+const (
+	// RedemptionCompleted is a redemption whose points were debited.
+	RedemptionCompleted RedemptionStatus = "completed"
+	// RedemptionCancelled is a redemption whose points were refunded.
+	RedemptionCancelled RedemptionStatus = "cancelled"
+)
+
+// Redemption
+// ID: The ID of the redemption.
+// UserID: The user who redeemed the reward.
+// RewardID: The reward that was redeemed.
+// Cost: The points debited, the cost of the reward at the time.
+// Status: completed, or cancelled once the points were refunded.
+// CreatedAt: When the reward was redeemed.
+// CancelledAt: When the redemption was cancelled, if it was.
+type Redemption struct {
+	ID          string           `json:"id"`
+	UserID      string           `json:"userId"`
+	RewardID    string           `json:"rewardId"`
+	Cost        int64            `json:"cost"`
+	Status      RedemptionStatus `json:"status"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	CancelledAt *time.Time       `json:"cancelledAt,omitempty"`
+}
+
+// WithCancellationWindow lets redemptions be cancelled for window after they
+// were made, instead of DefaultCancellationWindow. Once it passed the reward
+// may have been handed out, so the points are no longer refunded.
+func WithCancellationWindow(window time.Duration) Option {
+	return func(r *receipt) {
+		r.cancellationWindow = window
+	}
+}
+
+// Rewards returns the catalog, ordered by cost.
+func (r *receipt) Rewards() ([]Reward, error) {
+	rewards, err := r.db.Rewards()
+	if err != nil {
+		log.WithError(err).Error("Failed to list rewards")
+		return nil, err
+	}
+	sort.SliceStable(rewards, func(i, j int) bool {
+		if rewards[i].Cost != rewards[j].Cost {
+			return rewards[i].Cost < rewards[j].Cost
+		}
+		return rewards[i].ID < rewards[j].ID
+	})
+	return rewards, nil
+}
+
+// SaveReward adds a reward to the catalog or replaces the one with its ID.
+func (r *receipt) SaveReward(reward Reward) (Reward, error) {
+	reward.Name = strings.TrimSpace(reward.Name)
+	switch {
+	case reward.Name == "":
+		return Reward{}, fmt.Errorf("%w: a name is required", ErrRewardInvalid)
+	case reward.Cost <= 0:
+		return Reward{}, fmt.Errorf("%w: cost must be more than 0", ErrRewardInvalid)
+	case reward.Stock < 0:
+		return Reward{}, fmt.Errorf("%w: stock must not be negative", ErrRewardInvalid)
+	}
+
+	if err := r.db.SaveReward(reward); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"rewardID": reward.ID,
+		}).Error("Failed to save reward")
+		return Reward{}, err
+	}
+	return reward, nil
+}
+
+// Redeem spends the points of a user on a reward. It returns
+// ErrInsufficientPoints if the balance of the user is below the cost and
+// ErrRewardOutOfStock if there is none left.
+func (r *receipt) Redeem(userID string, rewardID string) (Redemption, error) {
+	redemption, err := r.db.Redeem(userID, rewardID, time.Now().UTC())
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"userID":   userID,
+			"rewardID": rewardID,
+		}).Warn("Failed to redeem reward")
+		return Redemption{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":       redemption.ID,
+		"userID":   userID,
+		"rewardID": rewardID,
+		"cost":     redemption.Cost,
+	}).Info("Reward redeemed")
+	return redemption, nil
+}
+
+// Redemptions returns the redemptions of a user, newest first.
+func (r *receipt) Redemptions(userID string) ([]Redemption, error) {
+	redemptions, err := r.db.Redemptions(userID)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"userID": userID,
+		}).Error("Failed to list redemptions")
+		return nil, err
+	}
+	sort.SliceStable(redemptions, func(i, j int) bool {
+		return redemptions[i].CreatedAt.After(redemptions[j].CreatedAt)
+	})
+	return redemptions, nil
+}
+
+// CancelRedemption refunds a redemption of the user and returns the reward to
+// stock, if it was made within the cancellation window.
+func (r *receipt) CancelRedemption(userID string, id string) (Redemption, error) {
+	now := time.Now().UTC()
+	redemption, err := r.db.CancelRedemption(userID, id, now, now.Add(-r.cancellationWindow))
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID":     id,
+			"userID": userID,
+		}).Warn("Failed to cancel redemption")
+		return Redemption{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":       id,
+		"userID":   userID,
+		"refunded": redemption.Cost,
+	}).Info("Redemption cancelled")
+	return redemption, nil
+}
@@ -0,0 +1,210 @@
+package receipts
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRiskThreshold is the score at which a receipt is held for review.
+const DefaultRiskThreshold = 50
+
+// RiskSignal
+// Check: Name of the check that raised the signal.
+// Score: How much the signal adds to the risk score.
+// Reason: Explanation of what was found.
+type RiskSignal struct {
+	Check  string `json:"check"`
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+// Risk
+// Score: The sum of the signal scores.
+// Signals: What the risk checks found, empty for an unremarkable receipt.
+type Risk struct {
+	Score   int          `json:"score"`
+	Signals []RiskSignal `json:"signals,omitempty"`
+}
+
+// RiskCheck inspects a submitted receipt before points are awarded.
+// Checks are called concurrently and must be safe for concurrent use.
+type RiskCheck interface {
+	Check(receipt Receipt) []RiskSignal
+}
+
+// RiskCheckFunc adapts a function to a RiskCheck.
+type RiskCheckFunc func(receipt Receipt) []RiskSignal
+
+func (f RiskCheckFunc) Check(receipt Receipt) []RiskSignal {
+	return f(receipt)
+}
+
+// DefaultRiskChecks returns the checks used when none are configured. The
+// description check looks for padding to the multiples used by rules.
+func DefaultRiskChecks(rules RuleSet) []RiskCheck {
+	var multiples []int64
+	for _, rule := range rules.Rules {
+		if rule.Type == RuleDescriptionLength {
+			multiples = append(multiples, rule.Multiple)
+		}
+	}
+
+	return []RiskCheck{
+		PurchaseDateCheck(10, time.Now),
+		LargeTotalCheck(100000),
+		SubmissionRateCheck(10, time.Minute, time.Now),
+		DescriptionPaddingCheck(multiples...),
+	}
+}
+
+// scoreRisk runs every check on receipt and sums their signals.
+func scoreRisk(checks []RiskCheck, receipt Receipt) Risk {
+	var risk Risk
+	for _, check := range checks {
+		for _, signal := range check.Check(receipt) {
+			risk.Score += signal.Score
+			risk.Signals = append(risk.Signals, signal)
+		}
+	}
+	return risk
+}
+
+// PurchaseDateCheck flags purchase dates after today or more than maxYears ago.
+// A day of slack allows for clients in time zones ahead of UTC.
+func PurchaseDateCheck(maxYears int, now func() time.Time) RiskCheck {
+	return RiskCheckFunc(func(receipt Receipt) []RiskSignal {
+		today := now().UTC().Truncate(24 * time.Hour)
+		switch {
+		case receipt.PurchaseDate.After(today.AddDate(0, 0, 1)):
+			return []RiskSignal{{
+				Check:  "purchase_date",
+				Score:  50,
+				Reason: fmt.Sprintf("purchase date %s is in the future", receipt.PurchaseDate.Format("2006-01-02")),
+			}}
+		case receipt.PurchaseDate.Before(today.AddDate(-maxYears, 0, 0)):
+			return []RiskSignal{{
+				Check:  "purchase_date",
+				Score:  50,
+				Reason: fmt.Sprintf("purchase date %s is more than %d years ago", receipt.PurchaseDate.Format("2006-01-02"), maxYears),
+			}}
+		default:
+			return nil
+		}
+	})
+}
+
+// LargeTotalCheck flags totals above limit.
+func LargeTotalCheck(limit Money) RiskCheck {
+	return RiskCheckFunc(func(receipt Receipt) []RiskSignal {
+		if receipt.Total <= limit {
+			return nil
+		}
+		return []RiskSignal{{
+			Check:  "large_total",
+			Score:  30,
+			Reason: fmt.Sprintf("total %s is more than %s", receipt.Total, limit),
+		}}
+	})
+}
+
+// submission is when a client submitted a receipt, or the first receipt of a batch.
+type submission struct {
+	at      time.Time
+	batchID string
+}
+
+// submissionRateCheck remembers when each client submitted receipts.
+type submissionRateCheck struct {
+	mu          sync.Mutex
+	limit       int
+	window      time.Duration
+	now         func() time.Time
+	submissions map[string][]submission
+}
+
+// SubmissionRateCheck flags a client submitting more than limit receipts
+// within window. The receipts of a batch count as one submission. Receipts
+// without a ClientID are not checked.
+func SubmissionRateCheck(limit int, window time.Duration, now func() time.Time) RiskCheck {
+	return &submissionRateCheck{
+		limit:       limit,
+		window:      window,
+		now:         now,
+		submissions: make(map[string][]submission),
+	}
+}
+
+func (s *submissionRateCheck) Check(receipt Receipt) []RiskSignal {
+	if receipt.ClientID == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for client, submissions := range s.submissions {
+		recent := submissions[:0]
+		for _, sub := range submissions {
+			if now.Sub(sub.at) < s.window {
+				recent = append(recent, sub)
+			}
+		}
+		if len(recent) == 0 {
+			delete(s.submissions, client)
+		} else {
+			s.submissions[client] = recent
+		}
+	}
+
+	submissions := s.submissions[receipt.ClientID]
+	if receipt.BatchID == "" || !slices.ContainsFunc(submissions, func(sub submission) bool {
+		return sub.batchID == receipt.BatchID
+	}) {
+		submissions = append(submissions, submission{at: now, batchID: receipt.BatchID})
+		s.submissions[receipt.ClientID] = submissions
+	}
+	count := len(submissions)
+	if count <= s.limit {
+		return nil
+	}
+	return []RiskSignal{{
+		Check:  "submission_rate",
+		Score:  30,
+		Reason: fmt.Sprintf("%d receipts submitted by the client in the last %s", count, s.window),
+	}}
+}
+
+var fillerSuffix = regexp.MustCompile(`[^\p{L}\p{N}\s]{2,}$`)
+
+// DescriptionPaddingCheck flags item descriptions that only have a trimmed
+// length divisible by one of multiples because of repeated whitespace or
+// trailing filler characters, e.g. "Diet   Pepsi" or "Chips!!!!".
+func DescriptionPaddingCheck(multiples ...int64) RiskCheck {
+	return RiskCheckFunc(func(receipt Receipt) []RiskSignal {
+		var signals []RiskSignal
+		for i, item := range receipt.Items {
+			trimmed := strings.TrimSpace(item.ShortDescription)
+			unpadded := fillerSuffix.ReplaceAllString(strings.Join(strings.Fields(trimmed), " "), "")
+			if unpadded == trimmed {
+				continue
+			}
+			for _, multiple := range multiples {
+				length := int64(len(trimmed))
+				if multiple > 0 && length%multiple == 0 && int64(len(unpadded))%multiple != 0 {
+					signals = append(signals, RiskSignal{
+						Check:  "description_padding",
+						Score:  20,
+						Reason: fmt.Sprintf("item %d description %q is padded to a length of %d", i, item.ShortDescription, length),
+					})
+					break
+				}
+			}
+		}
+		return signals
+	})
+}
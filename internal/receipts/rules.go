@@ -0,0 +1,205 @@
+package receipts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// RuleType is the primitive a Rule is built from.
+type RuleType string
+
+const (
+	// RuleAlphanumericCount awards Points for every alphanumeric character in the retailer name.
+	RuleAlphanumericCount RuleType = "alphanumeric_count"
+	// RuleTotalMultiple awards Points if the total in cents is a multiple of Multiple.
+	RuleTotalMultiple RuleType = "total_multiple"
+	// RuleItemCount awards Points for every Multiple items on the receipt.
+	RuleItemCount RuleType = "item_count"
+	// RuleDescriptionLength awards the item price in dollars times Multiplier, rounded up,
+	// for every item whose trimmed description length is a multiple of Multiple.
+	RuleDescriptionLength RuleType = "description_length"
+	// RulePurchaseDay awards Points if the day of the purchase date has the given Parity.
+	RulePurchaseDay RuleType = "purchase_day"
+	// RulePurchaseTime awards Points if the purchase time is strictly between Start and End.
+	RulePurchaseTime RuleType = "purchase_time"
+)
+
+const timeLayout = "15:04"
+
+// Rule
+// Name: Unique name of the rule.
+// Type: The primitive the rule evaluates.
+// Points: Points awarded each time the rule matches.
+// Multiple: Divisor used by total_multiple, item_count and description_length.
+// Multiplier: Factor applied to the item price by description_length.
+// Parity: "odd" or "even", used by purchase_day.
+// Start, End: Exclusive time window (24-hour format), used by purchase_time.
+type Rule struct {
+	Name       string   `json:"name"`
+	Type       RuleType `json:"type"`
+	Points     int64    `json:"points,omitempty"`
+	Multiple   int64    `json:"multiple,omitempty"`
+	Multiplier float64  `json:"multiplier,omitempty"`
+	Parity     string   `json:"parity,omitempty"`
+	Start      string   `json:"start,omitempty"`
+	End        string   `json:"end,omitempty"`
+}
+
+// RuleSet
+// Version: Names the rule set in the points it scores, derived from the rules if empty.
+// Rules: The rules evaluated against every receipt, their points are summed.
+type RuleSet struct {
+	Version string `json:"version"`
+	Rules   []Rule `json:"rules"`
+}
+
+// DefaultRuleSet returns the rules described in the README.
+func DefaultRuleSet() RuleSet {
+	return RuleSet{Version: "v1", Rules: []Rule{
+		{Name: "retailer_name", Type: RuleAlphanumericCount, Points: 1},
+		{Name: "round_dollar_total", Type: RuleTotalMultiple, Points: 50, Multiple: 100},
+		{Name: "quarter_multiple_total", Type: RuleTotalMultiple, Points: 25, Multiple: 25},
+		{Name: "item_pairs", Type: RuleItemCount, Points: 5, Multiple: 2},
+		{Name: "item_description_length", Type: RuleDescriptionLength, Multiple: 3, Multiplier: 0.2},
+		{Name: "odd_purchase_day", Type: RulePurchaseDay, Points: 6, Parity: "odd"},
+		{Name: "afternoon_purchase", Type: RulePurchaseTime, Points: 10, Start: "14:00", End: "16:00"},
+	}}
+}
+
+// LoadRuleSet reads a JSON rule set from path and validates it.
+func LoadRuleSet(path string) (RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("read rules file: %w", err)
+	}
+
+	var rs RuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return RuleSet{}, fmt.Errorf("decode rules file: %w", err)
+	}
+	if err := rs.Validate(); err != nil {
+		return RuleSet{}, err
+	}
+	return rs.versioned(), nil
+}
+
+// versioned returns the rule set with a version derived from its rules if it
+// has none, so the points it scores always say which rules they came from.
+func (rs RuleSet) versioned() RuleSet {
+	if rs.Version != "" {
+		return rs
+	}
+	data, _ := json.Marshal(rs.Rules)
+	digest := sha256.Sum256(data)
+	rs.Version = "sha256-" + hex.EncodeToString(digest[:6])
+	return rs
+}
+
+// Validate checks every rule has a unique name and the parameters its type requires.
+func (rs RuleSet) Validate() error {
+	names := make(map[string]bool)
+	for i, rule := range rs.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	switch r.Type {
+	case RuleAlphanumericCount:
+	case RuleTotalMultiple, RuleItemCount:
+		if r.Multiple <= 0 {
+			return fmt.Errorf("multiple must be positive")
+		}
+	case RuleDescriptionLength:
+		if r.Multiple <= 0 {
+			return fmt.Errorf("multiple must be positive")
+		}
+		if r.Multiplier <= 0 {
+			return fmt.Errorf("multiplier must be positive")
+		}
+	case RulePurchaseDay:
+		if r.Parity != "odd" && r.Parity != "even" {
+			return fmt.Errorf("parity must be \"odd\" or \"even\"")
+		}
+	case RulePurchaseTime:
+		start, err := time.Parse(timeLayout, r.Start)
+		if err != nil {
+			return fmt.Errorf("invalid start %q", r.Start)
+		}
+		end, err := time.Parse(timeLayout, r.End)
+		if err != nil {
+			return fmt.Errorf("invalid end %q", r.End)
+		}
+		if !start.Before(end) {
+			return fmt.Errorf("start must be before end")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+	return nil
+}
+
+// apply returns the points the rule awards to receipt and why. The rule must be valid.
+func (r Rule) apply(receipt Receipt) PointsDetail {
+	detail := PointsDetail{Rule: r.Name}
+
+	switch r.Type {
+	case RuleAlphanumericCount:
+		count := int64(len(alphanumeric.ReplaceAllString(receipt.Retailer, "")))
+		detail.Points = r.Points * count
+		detail.Reason = fmt.Sprintf("%d alphanumeric characters in retailer name %q", count, receipt.Retailer)
+	case RuleTotalMultiple:
+		if receipt.Total%Money(r.Multiple) == 0 {
+			detail.Points = r.Points
+			detail.Reason = fmt.Sprintf("total %s is a multiple of %s", receipt.Total, Money(r.Multiple))
+		}
+	case RuleItemCount:
+		groups := int64(len(receipt.Items)) / r.Multiple
+		detail.Points = r.Points * groups
+		detail.Reason = fmt.Sprintf("%d items on the receipt, %d points for every %d items", len(receipt.Items), r.Points, r.Multiple)
+	case RuleDescriptionLength:
+		for i, item := range receipt.Items {
+			if int64(len(strings.TrimSpace(item.ShortDescription)))%r.Multiple == 0 {
+				points := int64(math.Ceil(float64(item.Price) * r.Multiplier / 100))
+				detail.Points += points
+				detail.Items = append(detail.Items, ItemPoints{
+					Index:            i,
+					ShortDescription: item.ShortDescription,
+					Points:           points,
+				})
+			}
+		}
+		detail.Reason = fmt.Sprintf("trimmed item description length is a multiple of %d, price multiplied by %g and rounded up", r.Multiple, r.Multiplier)
+	case RulePurchaseDay:
+		day := receipt.PurchaseDate.Day()
+		if (day%2 == 1) == (r.Parity == "odd") {
+			detail.Points = r.Points
+			detail.Reason = fmt.Sprintf("purchase day %d is %s", day, r.Parity)
+		}
+	case RulePurchaseTime:
+		start, _ := time.Parse(timeLayout, r.Start)
+		end, _ := time.Parse(timeLayout, r.End)
+		if receipt.PurchaseTime.After(start) && receipt.PurchaseTime.Before(end) {
+			detail.Points = r.Points
+			detail.Reason = fmt.Sprintf("purchase time %s is after %s and before %s", receipt.PurchaseTime.Format(timeLayout), r.Start, r.End)
+		}
+	}
+	return detail
+}
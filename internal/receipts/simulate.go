@@ -0,0 +1,110 @@
+package receipts
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// SimulationRequest
+// Version: The version of a registered rule set to score under, empty for the rules in force.
+// CampaignID: The only campaign to apply, empty for every campaign.
+type SimulationRequest struct {
+	Version    string
+	CampaignID string
+}
+
+// Simulation is what a receipt would be awarded if it were submitted.
+// Receipt: The receipt as it would be stored, with its reconciliation, risk, status and duplicate.
+// Points: The points it would be awarded.
+type Simulation struct {
+	Receipt Receipt
+	Points  Points
+}
+
+// Simulate runs a receipt through the checks and scoring of Create without
+// storing it, changing the tier of its user or counting it towards the
+// submission rate of its client. Duplicates of a stored receipt are scored 0
+// points unless the duplicate policy rejects them.
+func (r *receipt) Simulate(receipt Receipt, req SimulationRequest) (Simulation, error) {
+	rules := r.rules
+	if req.Version != "" {
+		var err error
+		if rules, err = r.ruleSet(req.Version); err != nil {
+			return Simulation{}, err
+		}
+	}
+
+	receipt.ClientID = ""
+	receipt, err := r.assess(receipt)
+	if err != nil {
+		return Simulation{}, err
+	}
+
+	var tier Tier
+	if receipt.UserID != "" && len(r.tiers.Tiers) > 0 {
+		tier, _, err = r.qualifyingTier(receipt.UserID, time.Now().UTC())
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"userID": receipt.UserID,
+			}).Error("Failed to work out the tier of the user")
+			return Simulation{}, err
+		}
+	}
+
+	points := toPoints(rules, receipt)
+	points.RuleVersion = rules.Version
+
+	receipt.Fingerprint = toFingerprint(receipt)
+	original, err := r.db.FindByFingerprint(receipt.Fingerprint)
+	switch {
+	case errors.Is(err, ErrReceiptNotFound):
+	case err != nil:
+		log.WithError(err).Error("Failed to look up duplicate receipts")
+		return Simulation{}, err
+	case r.duplicates == DuplicateReject,
+		r.duplicates == DuplicateReturnOriginal && original.Receipt.UserID != receipt.UserID:
+		return Simulation{}, ErrReceiptDuplicate
+	default:
+		receipt.DuplicateOf = original.Receipt.ID
+		return Simulation{Receipt: receipt, Points: Points{
+			RuleVersion: rules.Version,
+			Breakdown: []PointsDetail{{
+				Rule:   "duplicate",
+				Reason: fmt.Sprintf("duplicate of receipt %s, no points awarded", original.Receipt.ID),
+			}},
+		}}, nil
+	}
+
+	if req.CampaignID == "" {
+		points, err = r.addCampaigns(receipt, points)
+	} else {
+		points, err = r.addCampaign(req.CampaignID, receipt, points)
+	}
+	if err != nil {
+		return Simulation{}, err
+	}
+	return Simulation{Receipt: receipt, Points: tier.apply(points)}, nil
+}
+
+// addCampaign adds the points of one campaign to a receipt, if it matches.
+func (r *receipt) addCampaign(id string, receipt Receipt, points Points) (Points, error) {
+	campaigns, err := r.db.Campaigns()
+	if err != nil {
+		log.WithError(err).Error("Failed to list campaigns")
+		return Points{}, err
+	}
+	for _, c := range campaigns {
+		if c.ID != id {
+			continue
+		}
+		usage, err := r.db.CampaignUsage(receipt.UserID)
+		if err != nil {
+			log.WithError(err).Error("Failed to retrieve campaign usage")
+			return Points{}, err
+		}
+		return applyCampaigns([]Campaign{c}, usage, receipt, points), nil
+	}
+	return Points{}, ErrCampaignNotFound
+}
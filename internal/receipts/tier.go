@@ -0,0 +1,225 @@
+package receipts
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultTierWindow is the number of days of activity tiers are based on.
+const DefaultTierWindow = 90
+
+// Tier
+// Name: The name of the tier, e.g. gold.
+// MinPoints: Base points earned within the window that qualify for the tier, 0 to not qualify on points.
+// MinReceipts: Approved receipts within the window that qualify for the tier, 0 to not qualify on receipts.
+// Multiplier: Factor applied to the base points of a receipt, 0 or 1 for none.
+// Bonus: Flat points added to every receipt that earns base points.
+type Tier struct {
+	Name        string  `json:"name"`
+	MinPoints   int64   `json:"minPoints,omitempty"`
+	MinReceipts int     `json:"minReceipts,omitempty"`
+	Multiplier  float64 `json:"multiplier,omitempty"`
+	Bonus       int64   `json:"bonus,omitempty"`
+}
+
+// TierSet
+// WindowDays: The number of days of activity tiers are based on.
+// Tiers: The tiers, lowest first. Users are placed in the highest tier they
+// qualify for, and in the first tier if they qualify for none.
+type TierSet struct {
+	WindowDays int    `json:"windowDays"`
+	Tiers      []Tier `json:"tiers"`
+}
+
+// Activity
+// Points: The base points of the approved receipts of a user within the window.
+// Receipts: The number of approved receipts of a user within the window.
+type Activity struct {
+	Points   int64
+	Receipts int
+}
+
+// DefaultTierSet returns the tiers described in the README.
+func DefaultTierSet() TierSet {
+	return TierSet{WindowDays: DefaultTierWindow, Tiers: []Tier{
+		{Name: "bronze"},
+		{Name: "silver", MinPoints: 500, MinReceipts: 5, Multiplier: 1.1},
+		{Name: "gold", MinPoints: 2000, MinReceipts: 20, Multiplier: 1.25, Bonus: 25},
+	}}
+}
+
+// LoadTierSet reads and validates a tier set from a JSON file.
+func LoadTierSet(path string) (TierSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TierSet{}, fmt.Errorf("read tiers file: %w", err)
+	}
+
+	var ts TierSet
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return TierSet{}, fmt.Errorf("decode tiers file: %w", err)
+	}
+	if err := ts.Validate(); err != nil {
+		return TierSet{}, err
+	}
+	return ts, nil
+}
+
+// Validate checks the window is positive and every tier has a unique name
+// and no negative parameters.
+func (ts TierSet) Validate() error {
+	if ts.WindowDays <= 0 {
+		return fmt.Errorf("tiers: windowDays must be more than 0")
+	}
+	names := make(map[string]bool)
+	for i, tier := range ts.Tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("tier %d: name is required", i)
+		}
+		if names[tier.Name] {
+			return fmt.Errorf("tier %q: duplicate name", tier.Name)
+		}
+		names[tier.Name] = true
+		if tier.MinPoints < 0 || tier.MinReceipts < 0 || tier.Multiplier < 0 || tier.Bonus < 0 {
+			return fmt.Errorf("tier %q: minPoints, minReceipts, multiplier and bonus must not be negative", tier.Name)
+		}
+	}
+	return nil
+}
+
+// qualifies reports whether activity meets either threshold of the tier.
+// A tier without thresholds is open to everyone.
+func (t Tier) qualifies(activity Activity) bool {
+	if t.MinPoints == 0 && t.MinReceipts == 0 {
+		return true
+	}
+	return (t.MinPoints > 0 && activity.Points >= t.MinPoints) ||
+		(t.MinReceipts > 0 && activity.Receipts >= t.MinReceipts)
+}
+
+// Qualify returns the highest tier activity qualifies for, the first tier if
+// it qualifies for none and the zero Tier if there are no tiers.
+func (ts TierSet) Qualify(activity Activity) Tier {
+	if len(ts.Tiers) == 0 {
+		return Tier{}
+	}
+	for i := len(ts.Tiers) - 1; i > 0; i-- {
+		if ts.Tiers[i].qualifies(activity) {
+			return ts.Tiers[i]
+		}
+	}
+	return ts.Tiers[0]
+}
+
+// named returns the tier called name, or the zero Tier if there is none.
+func (ts TierSet) named(name string) Tier {
+	for _, tier := range ts.Tiers {
+		if tier.Name == name {
+			return tier
+		}
+	}
+	return Tier{}
+}
+
+// apply adds the bonus of the tier to points, keeping the base points of the
+// rules in the breakdown. The multiplied points are rounded to the nearest
+// point. Points without base points get no bonus.
+func (t Tier) apply(points Points) Points {
+	if t.Name == "" || points.Points <= 0 {
+		return points
+	}
+
+	base := points.Points
+	var bonus int64
+	reason := fmt.Sprintf("%s tier", t.Name)
+	if t.Multiplier > 0 && t.Multiplier != 1 {
+		bonus += int64(math.Round(float64(base) * (t.Multiplier - 1)))
+		reason += fmt.Sprintf(": %sx %d base points", strconv.FormatFloat(t.Multiplier, 'f', -1, 64), base)
+	}
+	if t.Bonus > 0 {
+		bonus += t.Bonus
+		reason += fmt.Sprintf(", %d bonus points", t.Bonus)
+	}
+
+	points.Tier = t.Name
+	points.TierBonus = bonus
+	if bonus == 0 {
+		return points
+	}
+	points.Points += bonus
+	points.Breakdown = append(points.Breakdown[:len(points.Breakdown):len(points.Breakdown)], PointsDetail{
+		Rule:   "tier",
+		Points: bonus,
+		Reason: reason,
+	})
+	return points
+}
+
+// qualifyingTier returns the tier the activity of a user within the window
+// before now qualifies for, and the user.
+func (r *receipt) qualifyingTier(userID string, now time.Time) (Tier, User, error) {
+	user, err := r.db.GetUser(userID, now)
+	if err != nil {
+		return Tier{}, User{}, err
+	}
+	activity, err := r.db.Activity(userID, now.AddDate(0, 0, -r.tiers.WindowDays))
+	if err != nil {
+		return Tier{}, User{}, err
+	}
+	return r.tiers.Qualify(activity), user, nil
+}
+
+// refreshTier places a user in the tier their activity within the window
+// before now qualifies for and returns it. Callers must hold r.createMu, so
+// the tier is not changed by two receipts at once.
+func (r *receipt) refreshTier(userID string, now time.Time) (Tier, error) {
+	tier, user, err := r.qualifyingTier(userID, now)
+	if err != nil {
+		return Tier{}, err
+	}
+	if tier.Name != user.Tier {
+		if err := r.db.SetTier(userID, tier.Name); err != nil {
+			return Tier{}, err
+		}
+		log.WithFields(log.Fields{
+			"userID": userID,
+			"from":   user.Tier,
+			"to":     tier.Name,
+		}).Info("User tier changed")
+	}
+	return tier, nil
+}
+
+// RecalculateTiers places every user in the tier their recent activity
+// qualifies for, so users drop a tier once old receipts leave the window.
+// It returns the number of users whose tier changed.
+func (r *receipt) RecalculateTiers() (int, error) {
+	now := time.Now().UTC()
+	users, err := r.db.Users(now)
+	if err != nil {
+		log.WithError(err).Error("Failed to list users")
+		return 0, err
+	}
+
+	changed := 0
+	for _, user := range users {
+		r.createMu.Lock()
+		tier, err := r.refreshTier(user.ID, now)
+		r.createMu.Unlock()
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"userID": user.ID,
+			}).Error("Failed to recalculate tier")
+			return changed, err
+		}
+		if tier.Name != user.Tier {
+			changed++
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,59 @@
+package receipts
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// RecentReceipts is the number of receipts returned with the points of a user.
+const RecentReceipts = 10
+
+// User
+// ID: The ID of the user, taken from the authenticated caller.
+// Balance: The sum of the ledger entries of the user.
+// Tier: The loyalty tier of the user, empty until their first receipt.
+type User struct {
+	ID      string `json:"id"`
+	Balance int64  `json:"balance"`
+	Tier    string `json:"tier,omitempty"`
+}
+
+// UserPoints
+// User: The user and their balance.
+// Recent: The latest receipts of the user by purchase date, at most RecentReceipts.
+type UserPoints struct {
+	User   User
+	Recent []StoredReceipt
+}
+
+// Awarded returns the points the receipt adds to the balance of its user,
+// which are only awarded while it is approved.
+func (s StoredReceipt) Awarded() int64 {
+	if s.Receipt.Status != StatusApproved {
+		return 0
+	}
+	return s.Points.Points
+}
+
+// GetUserPoints returns the balance and recent receipts of a user. Users
+// without receipts have a zero balance.
+func (r *receipt) GetUserPoints(id string) (UserPoints, error) {
+	user, err := r.db.GetUser(id, time.Now().UTC())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"userID": id,
+		}).Error("Failed to retrieve user")
+		return UserPoints{}, err
+	}
+
+	page, err := r.List(ReceiptQuery{
+		UserID:     id,
+		SortBy:     SortByPurchaseDate,
+		Descending: true,
+		Limit:      RecentReceipts,
+	})
+	if err != nil {
+		return UserPoints{}, err
+	}
+	return UserPoints{User: user, Recent: page.Receipts}, nil
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"crypto/subtle"
+	stderrors "errors"
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	adminTokenHeader = "X-Admin-Token"
+	// adminActor is recorded in the audit trail for changes made through the admin API.
+	adminActor = "admin"
+)
+
+var errUnauthorized = stderrors.New("A valid X-Admin-Token header is required")
+
+// requireAdmin rejects requests without the configured admin token. When no
+// token is configured the admin API is disabled and every request is rejected.
+func (h *Handler) requireAdmin(c *gin.Context) {
+	if !h.isAdmin(c) {
+		abortWithError(c, errUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+// isAdmin reports whether the request carries the configured admin token.
+func (h *Handler) isAdmin(c *gin.Context) bool {
+	token := c.GetHeader(adminTokenHeader)
+	return h.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+// ListPending returns the receipts held for review.
+func (h *Handler) ListPending(c *gin.Context) {
+	var queryDTO receipts.ReceiptQueryDTO
+	if err := c.ShouldBindQuery(&queryDTO); err != nil {
+		abortWithError(c, receipts.ErrQueryInvalid)
+		return
+	}
+
+	query, err := toReceiptQuery(queryDTO)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	query.Status = receipts.StatusPending
+
+	page, err := h.ReceiptService.List(query)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toListResponse(page, toReviewResponse))
+}
+
+func (h *Handler) Approve(c *gin.Context) {
+	h.review(c, receipts.StatusApproved)
+}
+
+func (h *Handler) Reject(c *gin.Context) {
+	h.review(c, receipts.StatusRejected)
+}
+
+func (h *Handler) review(c *gin.Context, decision receipts.ReceiptStatus) {
+	var reviewDTO receipts.ReviewDTO
+	if err := c.ShouldBindJSON(&reviewDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.ReviewInvalid, err))
+		return
+	}
+
+	stored, err := h.ReceiptService.Review(c.Param("id"), decision, reviewDTO.Reason, adminActor)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toReviewResponse(stored))
+}
+
+func (h *Handler) GetAudit(c *gin.Context) {
+	entries, err := h.ReceiptService.Audit(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, receipts.AuditResponse{Entries: entries})
+}
+
+// GetLedger returns the balance and points transactions of a user.
+func (h *Handler) GetLedger(c *gin.Context) {
+	userLedger, err := h.ReceiptService.Ledger(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toLedgerResponse(userLedger))
+}
+
+// Adjust posts a manual correction to the points of a user.
+func (h *Handler) Adjust(c *gin.Context) {
+	id := c.Param("id")
+	if !idPattern.MatchString(id) {
+		abortWithError(c, errors.NewAppError(errors.UserInvalid, "user ids are 1 to 64 letters, digits, '-' or '_'"))
+		return
+	}
+
+	var adjustmentDTO receipts.AdjustmentDTO
+	if err := c.ShouldBindJSON(&adjustmentDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.AdjustmentInvalid, err))
+		return
+	}
+
+	entry, err := h.ReceiptService.Adjust(id, adjustmentDTO.Points, adjustmentDTO.Reason, adminActor)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, entry)
+}
+
+// CheckLedger reports where the ledger does not match the stored points.
+func (h *Handler) CheckLedger(c *gin.Context) {
+	report, err := h.ReceiptService.CheckLedger()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
+
+// ExpirePoints expires points straight away instead of waiting for the background job.
+func (h *Handler) ExpirePoints(c *gin.Context) {
+	entries, err := h.ReceiptService.ExpirePoints()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if entries == nil {
+		entries = []receipts.LedgerEntry{}
+	}
+	c.IndentedJSON(http.StatusOK, receipts.ExpiryRunResponse{Entries: entries})
+}
+
+// RecalculateTiers places every user in their tier straight away instead of waiting for the background job.
+func (h *Handler) RecalculateTiers(c *gin.Context) {
+	changed, err := h.ReceiptService.RecalculateTiers()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, receipts.TierRunResponse{Changed: changed})
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	stderrors "errors"
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const userIDKey = "userID"
+
+var (
+	errTokenRequired = stderrors.New("A bearer token is required")
+	errInvalidToken  = stderrors.New("The bearer token is invalid")
+	errForbidden     = stderrors.New("The caller may only access their own account")
+	errNotOwner      = stderrors.New("The receipt belongs to another user")
+)
+
+// idPattern matches the ids of users and rewards.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// signToken returns the bearer token for userID, the user id and its
+// HMAC-SHA256 under secret, separated by a dot.
+func signToken(secret string, userID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userID))
+	return userID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken returns the user id of token if it was signed with secret.
+func verifyToken(secret string, token string) (string, bool) {
+	userID, _, ok := strings.Cut(token, ".")
+	if !ok || secret == "" || !idPattern.MatchString(userID) {
+		return "", false
+	}
+	return userID, hmac.Equal([]byte(token), []byte(signToken(secret, userID)))
+}
+
+// authenticate identifies the caller from an "Authorization: Bearer" header.
+// Requests without the header are anonymous, requests with an invalid token
+// are rejected.
+func (h *Handler) authenticate(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		c.Next()
+		return
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		abortWithError(c, errInvalidToken)
+		return
+	}
+	userID, ok := verifyToken(h.authSecret, token)
+	if !ok {
+		abortWithError(c, errInvalidToken)
+		return
+	}
+	c.Set(userIDKey, userID)
+	c.Next()
+}
+
+// requireUser returns the user id in the path if the caller authenticated as
+// that user, otherwise it writes the error response and returns false.
+func requireUser(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	caller := c.GetString(userIDKey)
+	if caller == "" {
+		abortWithError(c, errTokenRequired)
+		return "", false
+	}
+	if caller != id {
+		abortWithError(c, errForbidden)
+		return "", false
+	}
+	return id, true
+}
+
+// readableReceipt returns the receipt in the path if the caller may read it:
+// admins read every receipt, users their own, and anyone may read an anonymous
+// receipt by its id. Otherwise it writes the error response and returns false.
+func (h *Handler) readableReceipt(c *gin.Context) (receipts.StoredReceipt, bool) {
+	stored, err := h.ReceiptService.GetReceipt(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return receipts.StoredReceipt{}, false
+	}
+	owner := stored.Receipt.UserID
+	if owner == "" || h.isAdmin(c) {
+		return stored, true
+	}
+	switch c.GetString(userIDKey) {
+	case owner:
+		return stored, true
+	case "":
+		abortWithError(c, errTokenRequired)
+	default:
+		abortWithError(c, errNotOwner)
+	}
+	return receipts.StoredReceipt{}, false
+}
+
+// GetUserPoints returns the balance and recent receipts of the authenticated user.
+func (h *Handler) GetUserPoints(c *gin.Context) {
+	id, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	userPoints, err := h.ReceiptService.GetUserPoints(id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toUserPointsResponse(userPoints))
+}
+
+// IssueToken returns a bearer token for the user, for an admin to hand out.
+func (h *Handler) IssueToken(c *gin.Context) {
+	id := c.Param("id")
+	if !idPattern.MatchString(id) {
+		abortWithError(c, errors.NewAppError(errors.UserInvalid, "user ids are 1 to 64 letters, digits, '-' or '_'"))
+		return
+	}
+	if h.authSecret == "" {
+		abortWithError(c, errors.NewAppError(errors.Internal, "AUTH_SECRET is not configured"))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, receipts.TokenResponse{Token: signToken(h.authSecret, id)})
+}
+
+// ListExpirations returns the points of the authenticated user that are still to expire.
+func (h *Handler) ListExpirations(c *gin.Context) {
+	id, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	expirations, err := h.ReceiptService.Expirations(id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if expirations == nil {
+		expirations = []receipts.Expiration{}
+	}
+	c.IndentedJSON(http.StatusOK, receipts.ExpirationsResponse{UserID: id, Expirations: expirations})
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxBatchSize is the most receipts a batch may have.
+const DefaultMaxBatchSize = 100
+
+// batchWorkers is how many receipts of a batch are processed at once.
+const batchWorkers = 8
+
+// ndjsonContentType is the media type of batches sent as one receipt per line.
+const ndjsonContentType = "application/x-ndjson"
+
+// maxNDJSONLine is the longest line of an NDJSON batch, one receipt.
+const maxNDJSONLine = 1 << 20
+
+// errBatchTooLarge is returned while reading a batch with more than the maximum number of receipts.
+var errBatchTooLarge = stderrors.New("batch too large")
+
+// CreateBatch processes a JSON array or NDJSON stream of receipts
+// concurrently. Every receipt succeeds or fails on its own, the response
+// has the id or error of each in the order they were sent.
+func (h *Handler) CreateBatch(c *gin.Context) {
+	var entries []json.RawMessage
+	var err error
+	if c.ContentType() == ndjsonContentType {
+		entries, err = readNDJSONBatch(c.Request.Body, h.maxBatchSize)
+	} else {
+		entries, err = readJSONBatch(c.Request.Body, h.maxBatchSize)
+	}
+	switch {
+	case stderrors.Is(err, errBatchTooLarge):
+		abortWithError(c, errors.NewAppError(errors.BatchTooLarge, fmt.Sprintf("a batch may have at most %d receipts", h.maxBatchSize)))
+		return
+	case err != nil:
+		abortWithError(c, errors.NewAppError(errors.BatchInvalid, err.Error()))
+		return
+	case len(entries) == 0:
+		abortWithError(c, errors.NewAppError(errors.BatchInvalid, "a batch needs at least 1 receipt"))
+		return
+	}
+
+	// The receipts share a batch id so the batch counts as one submission of the client.
+	userID, clientID, batchID := c.GetString(userIDKey), c.ClientIP(), uuid.NewString()
+	response := receipts.BatchResponse{Results: make([]receipts.BatchResultResponse, len(entries))}
+	workers := make(chan struct{}, batchWorkers)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			result := receipts.BatchResultResponse{Index: i}
+			created, err := h.createEntry(entry, userID, clientID, batchID)
+			if err != nil {
+				problem := *handleError(err)
+				result.Error = &problem
+			} else {
+				result.ID = created.ID
+			}
+			response.Results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if result.Error != nil {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+// createEntry validates and processes one receipt of a batch like Create.
+func (h *Handler) createEntry(entry json.RawMessage, userID string, clientID string, batchID string) (receipts.Receipt, error) {
+	var receiptDTO receipts.ReceiptDTO
+	if err := json.Unmarshal(entry, &receiptDTO); err != nil {
+		return receipts.Receipt{}, toValidationError(receiptDTO, err)
+	}
+	if err := binding.Validator.ValidateStruct(&receiptDTO); err != nil {
+		return receipts.Receipt{}, toValidationError(receiptDTO, err)
+	}
+
+	receipt, err := toReceipt(receiptDTO)
+	if err != nil {
+		return receipts.Receipt{}, err
+	}
+	receipt.ClientID = clientID
+	receipt.BatchID = batchID
+	receipt.UserID = userID
+	return h.ReceiptService.Create(receipt)
+}
+
+// readJSONBatch reads the receipts of a JSON array, without decoding them,
+// and stops at the first receipt over max.
+func readJSONBatch(body io.Reader, max int) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(body)
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		return nil, stderrors.New("request body must be a JSON array of receipts or NDJSON")
+	}
+	var entries []json.RawMessage
+	for dec.More() {
+		if len(entries) == max {
+			return nil, errBatchTooLarge
+		}
+		var entry json.RawMessage
+		if err := dec.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("receipt %d is not valid JSON", len(entries))
+		}
+		entries = append(entries, entry)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, stderrors.New("request body must be a JSON array of receipts or NDJSON")
+	}
+	return entries, nil
+}
+
+// readNDJSONBatch reads one receipt per line, skipping blank lines, and
+// stops at the first receipt over max. Lines are decoded when processed.
+func readNDJSONBatch(body io.Reader, max int) ([]json.RawMessage, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+	var entries []json.RawMessage
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(entries) == max {
+			return nil, errBatchTooLarge
+		}
+		entries = append(entries, json.RawMessage(bytes.Clone(line)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("receipt %d could not be read: %w", len(entries), err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ListCampaigns returns every campaign with the points it awarded.
+func (h *Handler) ListCampaigns(c *gin.Context) {
+	campaigns, err := h.ReceiptService.Campaigns()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if campaigns == nil {
+		campaigns = []receipts.CampaignSummary{}
+	}
+	c.IndentedJSON(http.StatusOK, receipts.CampaignsResponse{Campaigns: campaigns})
+}
+
+// GetCampaign returns a campaign with the points it awarded.
+func (h *Handler) GetCampaign(c *gin.Context) {
+	campaign, err := h.ReceiptService.Campaign(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, campaign)
+}
+
+// SaveCampaign creates a campaign or replaces it.
+func (h *Handler) SaveCampaign(c *gin.Context) {
+	id := c.Param("id")
+	if !idPattern.MatchString(id) {
+		abortWithError(c, errors.NewAppError(errors.CampaignInvalid, "campaign ids are 1 to 64 letters, digits, '-' or '_'"))
+		return
+	}
+
+	var campaignDTO receipts.CampaignDTO
+	if err := c.ShouldBindJSON(&campaignDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.CampaignInvalid, err))
+		return
+	}
+
+	campaign, err := h.ReceiptService.SaveCampaign(receipts.Campaign{
+		ID:         id,
+		Name:       campaignDTO.Name,
+		Retailer:   campaignDTO.Retailer,
+		Item:       campaignDTO.Item,
+		StartsAt:   campaignDTO.StartsAt,
+		EndsAt:     campaignDTO.EndsAt,
+		Multiplier: campaignDTO.Multiplier,
+		Bonus:      campaignDTO.Bonus,
+		UserCap:    campaignDTO.UserCap,
+		Budget:     campaignDTO.Budget,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, campaign)
+}
+
+// DeleteCampaign ends a campaign, receipts keep the points it awarded.
+func (h *Handler) DeleteCampaign(c *gin.Context) {
+	if err := h.ReceiptService.DeleteCampaign(c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
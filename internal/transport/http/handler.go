@@ -1,41 +1,222 @@
 package http
 
 import (
+	"encoding/json"
+	stderrors "errors"
 	"fetch_take_home/errors"
 	"fetch_take_home/internal/receipts"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"time"
 )
 
 type Handler struct {
 	ReceiptService receipts.Service
+
+	idempotencyWindow time.Duration
+	maxBatchSize      int
+	jobs              *jobPool
+	adminToken        string
+	authSecret        string
 }
 
-func Activate(router *gin.Engine, receiptService receipts.Service) {
+// Option configures optional behaviour of the handler.
+type Option func(*Handler)
+
+// WithIdempotencyWindow sets how long responses are replayed for a repeated Idempotency-Key.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(h *Handler) {
+		h.idempotencyWindow = window
+	}
+}
+
+// WithMaxBatchSize sets the most receipts a batch may have.
+func WithMaxBatchSize(size int) Option {
+	return func(h *Handler) {
+		h.maxBatchSize = size
+	}
+}
+
+// WithJobWorkers starts workers that process receipts submitted with the
+// Prefer: respond-async header in the background. Without workers the
+// header is ignored and receipts are processed in the request.
+func WithJobWorkers(workers int) Option {
+	return func(h *Handler) {
+		if workers > 0 {
+			h.jobs = &jobPool{workers: workers, wake: make(chan struct{}, workers)}
+		}
+	}
+}
+
+// WithAdminToken enables the admin API for requests with token in the X-Admin-Token header.
+func WithAdminToken(token string) Option {
+	return func(h *Handler) {
+		h.adminToken = token
+	}
+}
+
+// WithAuthSecret verifies the bearer tokens of users with secret.
+func WithAuthSecret(secret string) Option {
+	return func(h *Handler) {
+		h.authSecret = secret
+	}
+}
+
+func Activate(router *gin.Engine, receiptService receipts.Service, opts ...Option) {
 	handler := Handler{
-		ReceiptService: receiptService,
+		ReceiptService:    receiptService,
+		idempotencyWindow: DefaultIdempotencyWindow,
+		maxBatchSize:      DefaultMaxBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&handler)
+	}
+	registerTagName()
+	if handler.jobs != nil {
+		handler.jobs.start(receiptService)
 	}
+	idempotency := newIdempotencyStore(handler.idempotencyWindow)
 
+	router.Use(requestID, handler.authenticate)
+
+	router.GET("/receipts", handler.List)
+	router.GET("/receipts/:id", handler.GetReceipt)
 	router.GET("/receipts/:id/points", handler.GetPoints)
-	router.POST("/receipts/process", handler.Create)
+	router.GET("/receipts/:id/points/breakdown", handler.GetBreakdown)
+	router.POST("/receipts/process", idempotency.idempotent, handler.Create)
+	router.POST("/receipts/process/batch", idempotency.idempotent, handler.CreateBatch)
+	router.POST("/receipts/simulate", handler.Simulate)
+	router.DELETE("/receipts/:id", handler.Void)
+	router.GET("/users/:id/points", handler.GetUserPoints)
+	router.GET("/users/:id/redemptions", handler.ListRedemptions)
+	router.POST("/users/:id/redemptions", idempotency.idempotent, handler.Redeem)
+	router.POST("/users/:id/redemptions/:redemptionId/cancel", handler.CancelRedemption)
+	router.GET("/users/:id/expirations", handler.ListExpirations)
+	router.GET("/rewards", handler.ListRewards)
+	router.GET("/jobs/:id", handler.GetJob)
 	router.GET("/health", handler.HealthCheck)
+
+	admin := router.Group("/admin", handler.requireAdmin)
+	admin.GET("/reviews", handler.ListPending)
+	admin.POST("/reviews/:id/approve", handler.Approve)
+	admin.POST("/reviews/:id/reject", handler.Reject)
+	admin.GET("/receipts/:id/audit", handler.GetAudit)
+	admin.POST("/users/:id/token", handler.IssueToken)
+	admin.GET("/users/:id/ledger", handler.GetLedger)
+	admin.POST("/users/:id/adjustments", handler.Adjust)
+	admin.GET("/ledger/check", handler.CheckLedger)
+	admin.PUT("/rewards/:id", handler.SaveReward)
+	admin.GET("/campaigns", handler.ListCampaigns)
+	admin.GET("/campaigns/:id", handler.GetCampaign)
+	admin.PUT("/campaigns/:id", handler.SaveCampaign)
+	admin.DELETE("/campaigns/:id", handler.DeleteCampaign)
+	admin.GET("/rulesets", handler.ListRuleSets)
+	admin.PUT("/rulesets/:version", handler.SaveRuleSet)
+	admin.POST("/recomputations", handler.PreviewRecompute)
+	admin.GET("/recomputations/:id", handler.GetRecomputation)
+	admin.POST("/recomputations/:id/commit", handler.CommitRecompute)
+	admin.POST("/expirations/run", handler.ExpirePoints)
+	admin.POST("/tiers/recalculate", handler.RecalculateTiers)
 }
 
 func getPointsResponse(p receipts.Points) receipts.PointsResponse {
-	return receipts.PointsResponse{Points: p.Points}
+	return receipts.PointsResponse{Points: p.Points, Tier: toTierPointsResponse(p)}
+}
+
+// withheldPoints writes the response for a receipt whose points are withheld
+// because of its status and reports whether err was such an error: 202
+// Accepted while the receipt is pending review, 200 with no points once it
+// was rejected.
+func withheldPoints(c *gin.Context, err error) bool {
+	switch {
+	case stderrors.Is(err, receipts.ErrReceiptPending):
+		c.IndentedJSON(http.StatusAccepted, receipts.PointsResponse{Points: 0, Status: receipts.StatusPending})
+	case stderrors.Is(err, receipts.ErrReceiptRejected):
+		c.IndentedJSON(http.StatusOK, receipts.PointsResponse{Points: 0, Status: receipts.StatusRejected})
+	default:
+		return false
+	}
+	return true
 }
 
 func (h *Handler) GetPoints(c *gin.Context) {
+	if _, ok := h.readableReceipt(c); !ok {
+		return
+	}
 	points, err := h.ReceiptService.GetPoints(c.Param("id"))
+	if withheldPoints(c, err) {
+		return
+	}
 	if err != nil {
-		status, e := handleError(err)
-		c.IndentedJSON(status, e)
+		abortWithError(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, getPointsResponse(points))
 }
 
+func (h *Handler) GetReceipt(c *gin.Context) {
+	stored, ok := h.readableReceipt(c)
+	if !ok {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toReceiptResponse(stored))
+}
+
+func (h *Handler) List(c *gin.Context) {
+	var queryDTO receipts.ReceiptQueryDTO
+	if err := c.ShouldBindQuery(&queryDTO); err != nil {
+		abortWithError(c, receipts.ErrQueryInvalid)
+		return
+	}
+
+	query, err := toReceiptQuery(queryDTO)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	// Admins list the receipts of every user, users only their own.
+	if !h.isAdmin(c) {
+		caller := c.GetString(userIDKey)
+		if caller == "" {
+			abortWithError(c, errTokenRequired)
+			return
+		}
+		if query.UserID != "" && query.UserID != caller {
+			abortWithError(c, errForbidden)
+			return
+		}
+		query.UserID = caller
+	}
+
+	page, err := h.ReceiptService.List(query)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toListResponse(page, toReceiptResponse))
+}
+
+func getBreakdownResponse(p receipts.Points) receipts.BreakdownResponse {
+	return receipts.BreakdownResponse{Points: p.Points, Breakdown: p.Breakdown, Tier: toTierPointsResponse(p)}
+}
+
+func (h *Handler) GetBreakdown(c *gin.Context) {
+	if _, ok := h.readableReceipt(c); !ok {
+		return
+	}
+	points, err := h.ReceiptService.GetPoints(c.Param("id"))
+	if withheldPoints(c, err) {
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, getBreakdownResponse(points))
+}
+
 func createResponse(r receipts.Receipt) receipts.CreateResponse {
 	return receipts.CreateResponse{ID: r.ID}
 }
@@ -44,46 +225,211 @@ func (h *Handler) Create(c *gin.Context) {
 	var receiptDTO receipts.ReceiptDTO
 
 	if err := c.ShouldBindJSON(&receiptDTO); err != nil {
-		status, e := handleError(receipts.ErrReceiptInvalid)
-		c.IndentedJSON(status, e)
+		err = toValidationError(receiptDTO, err)
+		log.WithError(err).Error("Failed to bind receipt")
+		abortWithError(c, err)
 		return
 	}
 
 	receipt, err := toReceipt(receiptDTO)
 	if err != nil {
-		log.WithFields(log.Fields{
+		log.WithError(err).WithFields(log.Fields{
 			"retailer":     receiptDTO.Retailer,
 			"purchaseDate": receiptDTO.PurchaseDate,
 			"purchaseTime": receiptDTO.PurchaseTime,
 			"items":        receiptDTO.Items,
 			"total":        receiptDTO.Total,
 		}).Error("Failed to create receipt")
-		status, e := handleError(err)
-		c.IndentedJSON(status, e)
+		abortWithError(c, err)
+		return
+	}
+	receipt.ClientID = c.ClientIP()
+	receipt.UserID = c.GetString(userIDKey)
+
+	if h.jobs != nil && prefersAsync(c) {
+		h.enqueue(c, receipt)
 		return
 	}
 
 	createdReceipt, err := h.ReceiptService.Create(receipt)
 	if err != nil {
-		status, e := handleError(err)
-		c.IndentedJSON(status, e)
+		abortWithError(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, createResponse(createdReceipt))
 }
 
+// Simulate scores a receipt like Create without storing it. The version
+// query parameter scores it under a registered rule set, campaign applies
+// only that campaign.
+func (h *Handler) Simulate(c *gin.Context) {
+	var receiptDTO receipts.ReceiptDTO
+
+	if err := c.ShouldBindJSON(&receiptDTO); err != nil {
+		abortWithError(c, toValidationError(receiptDTO, err))
+		return
+	}
+
+	receipt, err := toReceipt(receiptDTO)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	receipt.UserID = c.GetString(userIDKey)
+
+	simulation, err := h.ReceiptService.Simulate(receipt, receipts.SimulationRequest{
+		Version:    c.Query("version"),
+		CampaignID: c.Query("campaign"),
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toSimulationResponse(simulation))
+}
+
+// Void withdraws a receipt, the optional reason query parameter is kept in
+// its audit trail. Users void their own receipts and admins any receipt,
+// including anonymous ones, which have no owner who could void them. Admins
+// remove a receipt for good with the hard=true query parameter.
+func (h *Handler) Void(c *gin.Context) {
+	hard := c.Query("hard") == "true"
+	if h.isAdmin(c) {
+		remove := h.ReceiptService.Void
+		if hard {
+			remove = h.ReceiptService.Delete
+		}
+		if err := remove(c.Param("id"), c.Query("reason"), adminActor); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if hard {
+		abortWithError(c, errUnauthorized)
+		return
+	}
+
+	caller := c.GetString(userIDKey)
+	if caller == "" {
+		abortWithError(c, errTokenRequired)
+		return
+	}
+	stored, err := h.ReceiptService.GetReceipt(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if stored.Receipt.UserID != caller {
+		abortWithError(c, errNotOwner)
+		return
+	}
+
+	if err := h.ReceiptService.Void(stored.Receipt.ID, c.Query("reason"), caller); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
 func (h *Handler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "200", "healthy": "OK"})
 }
 
-func handleError(e error) (int, error) {
-	switch e {
-	case receipts.ErrReceiptNotFound:
-		return http.StatusNotFound, errors.NewAppError(errors.NotFound, "No receipt found for that id")
-	case receipts.ErrReceiptInvalid:
-		return http.StatusBadRequest, errors.NewAppError(errors.BadRequest, "The receipt is invalid")
+// handleError maps an error returned by the service to its problem details.
+func handleError(e error) *errors.AppError {
+	var appErr *errors.AppError
+	var validationErr *receipts.ValidationError
+	switch {
+	case stderrors.As(e, &appErr):
+		return appErr
+	case stderrors.As(e, &validationErr):
+		return errors.NewValidationError(fmt.Sprintf("%d invalid fields", len(validationErr.Fields)), validationErr.Fields)
+	case stderrors.Is(e, receipts.ErrReceiptNotFound):
+		return errors.NewAppError(errors.ReceiptNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrReceiptInvalid):
+		return errors.NewAppError(errors.ReceiptInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrQueryInvalid):
+		return errors.NewAppError(errors.QueryInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrReceiptDuplicate):
+		return errors.NewAppError(errors.ReceiptDuplicate, e.Error())
+	case stderrors.Is(e, receipts.ErrReceiptTotalMismatch):
+		return errors.NewAppError(errors.ReceiptTotalMismatch, e.Error())
+	case stderrors.Is(e, receipts.ErrReceiptStatusConflict):
+		return errors.NewAppError(errors.ReceiptStatusConflict, e.Error())
+	case stderrors.Is(e, receipts.ErrReceiptGone):
+		return errors.NewAppError(errors.ReceiptGone, e.Error())
+	case stderrors.Is(e, receipts.ErrReviewInvalid):
+		return errors.NewAppError(errors.ReviewInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrAdjustmentInvalid):
+		return errors.NewAppError(errors.AdjustmentInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrRewardNotFound):
+		return errors.NewAppError(errors.RewardNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrRewardInvalid):
+		return errors.NewAppError(errors.RewardInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrRewardOutOfStock):
+		return errors.NewAppError(errors.RewardOutOfStock, e.Error())
+	case stderrors.Is(e, receipts.ErrInsufficientPoints):
+		return errors.NewAppError(errors.PointsInsufficient, e.Error())
+	case stderrors.Is(e, receipts.ErrRedemptionNotFound):
+		return errors.NewAppError(errors.RedemptionNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrRedemptionCancelled):
+		return errors.NewAppError(errors.RedemptionCancelled, e.Error())
+	case stderrors.Is(e, receipts.ErrRedemptionFinal):
+		return errors.NewAppError(errors.RedemptionFinal, e.Error())
+	case stderrors.Is(e, receipts.ErrCampaignNotFound):
+		return errors.NewAppError(errors.CampaignNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrCampaignInvalid):
+		return errors.NewAppError(errors.CampaignInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrRuleSetNotFound):
+		return errors.NewAppError(errors.RuleSetNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrRuleSetInvalid):
+		return errors.NewAppError(errors.RuleSetInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrRuleSetConflict):
+		return errors.NewAppError(errors.RuleSetConflict, e.Error())
+	case stderrors.Is(e, receipts.ErrRecomputationNotFound):
+		return errors.NewAppError(errors.RecomputationNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrRecomputationInvalid):
+		return errors.NewAppError(errors.RecomputationInvalid, e.Error())
+	case stderrors.Is(e, receipts.ErrRecomputationCommitted):
+		return errors.NewAppError(errors.RecomputationCommitted, e.Error())
+	case stderrors.Is(e, receipts.ErrRecomputationStale):
+		return errors.NewAppError(errors.RecomputationStale, e.Error())
+	case stderrors.Is(e, receipts.ErrJobNotFound):
+		return errors.NewAppError(errors.JobNotFound, e.Error())
+	case stderrors.Is(e, receipts.ErrJobQueueFull):
+		return errors.NewAppError(errors.JobQueueFull, e.Error())
+	case stderrors.Is(e, receipts.ErrJobStatusConflict):
+		return errors.NewAppError(errors.JobStatusConflict, e.Error())
+	case stderrors.Is(e, errUnauthorized), stderrors.Is(e, errTokenRequired), stderrors.Is(e, errInvalidToken):
+		return errors.NewAppError(errors.Unauthorized, e.Error())
+	case stderrors.Is(e, errForbidden), stderrors.Is(e, errNotOwner):
+		return errors.NewAppError(errors.Forbidden, e.Error())
+	case stderrors.Is(e, errIdempotencyKeyInFlight):
+		return errors.NewAppError(errors.IdempotencyKeyInFlight, e.Error())
+	case stderrors.Is(e, errIdempotencyKeyReused):
+		return errors.NewAppError(errors.IdempotencyKeyReused, e.Error())
+	case stderrors.Is(e, errRequestTooLarge):
+		return errors.NewAppError(errors.RequestTooLarge, e.Error())
 	default:
-		return http.StatusInternalServerError, errors.NewAppError(errors.InternalServerError, "Internal server error")
+		return errors.NewAppError(errors.Internal, "")
+	}
+}
+
+// abortWithError writes e to the response as application/problem+json and stops the handler chain.
+func abortWithError(c *gin.Context, e error) {
+	problem := *handleError(e)
+	problem.Instance = c.Request.URL.Path
+	problem.RequestID = c.GetString(requestIDKey)
+
+	body, err := json.MarshalIndent(problem, "", "    ")
+	if err != nil {
+		log.WithError(err).Error("Failed to encode error response")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
+	c.Data(problem.Status, errors.ProblemContentType, body)
+	c.Abort()
 }
@@ -1,8 +1,10 @@
 package http
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fetch_take_home/errors"
+	"fetch_take_home/internal/db"
 	"fetch_take_home/internal/receipts"
 	"fmt"
 	"github.com/gin-gonic/gin"
@@ -10,7 +12,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -19,23 +23,285 @@ type mockReceiptService struct {
 	GetPointsResult receipts.Points
 	GetPointsError  error
 
+	GetReceiptResult receipts.StoredReceipt
+	GetReceiptError  error
+
+	ListQuery  receipts.ReceiptQuery
+	ListResult receipts.ReceiptPage
+	ListError  error
+
 	CreateResult receipts.Receipt
 	CreateError  error
+
+	ReviewDecision receipts.ReceiptStatus
+	ReviewReason   string
+	ReviewResult   receipts.StoredReceipt
+	ReviewError    error
+
+	AuditResult []receipts.AuditEntry
+	AuditError  error
+
+	VoidError   error
+	DeleteError error
+	DeletedID   string
+	VoidActor   string
+
+	CreateReceipt receipts.Receipt
+
+	UserPointsResult receipts.UserPoints
+	UserPointsError  error
+	LedgerResult     receipts.UserLedger
+	AdjustResult     receipts.LedgerEntry
+	AdjustError      error
+	AdjustPoints     int64
+	CheckResult      receipts.LedgerReport
+
+	RewardsResult     []receipts.Reward
+	SavedReward       receipts.Reward
+	SaveRewardError   error
+	RedeemRewardID    string
+	RedeemResult      receipts.Redemption
+	RedeemError       error
+	RedemptionsResult []receipts.Redemption
+	CancelResult      receipts.Redemption
+	CancelError       error
+
+	ExpirationsResult []receipts.Expiration
+	ExpireResult      []receipts.LedgerEntry
+	TierChanges       int
+
+	CampaignsResult   []receipts.CampaignSummary
+	CampaignError     error
+	SavedCampaign     receipts.Campaign
+	SaveCampaignError error
+	DeletedCampaign   string
+
+	RuleSetsResult      []receipts.RuleSet
+	SavedRuleSet        receipts.RuleSet
+	RuleSetError        error
+	RecomputeRequest    receipts.RecomputeRequest
+	RecomputationResult receipts.Recomputation
+	RecomputationError  error
+	CommittedActor      string
+
+	SimulateReceipt  receipts.Receipt
+	SimulateRequest  receipts.SimulationRequest
+	SimulationResult receipts.Simulation
+	SimulateError    error
+
+	EnqueueReceipt receipts.Receipt
+	EnqueueResult  receipts.Job
+	EnqueueError   error
+	JobResult      receipts.Job
+	JobError       error
 }
 
 func (s *mockReceiptService) GetPoints(id string) (receipts.Points, error) {
 	return s.GetPointsResult, s.GetPointsError
 }
 
+func (s *mockReceiptService) GetReceipt(id string) (receipts.StoredReceipt, error) {
+	return s.GetReceiptResult, s.GetReceiptError
+}
+
+func (s *mockReceiptService) List(q receipts.ReceiptQuery) (receipts.ReceiptPage, error) {
+	s.ListQuery = q
+	return s.ListResult, s.ListError
+}
+
 func (s *mockReceiptService) Create(receipt receipts.Receipt) (receipts.Receipt, error) {
+	s.CreateReceipt = receipt
 	return s.CreateResult, s.CreateError
 }
 
+func (s *mockReceiptService) Simulate(receipt receipts.Receipt, req receipts.SimulationRequest) (receipts.Simulation, error) {
+	s.SimulateReceipt = receipt
+	s.SimulateRequest = req
+	return s.SimulationResult, s.SimulateError
+}
+
+func (s *mockReceiptService) Enqueue(receipt receipts.Receipt) (receipts.Job, error) {
+	s.EnqueueReceipt = receipt
+	return s.EnqueueResult, s.EnqueueError
+}
+
+func (s *mockReceiptService) Job(id string) (receipts.Job, error) {
+	return s.JobResult, s.JobError
+}
+
+func (s *mockReceiptService) ClaimJob() (receipts.Job, error) {
+	return receipts.Job{}, receipts.ErrJobNotFound
+}
+
+func (s *mockReceiptService) FinishJob(id string, receiptID string, problem *errors.AppError) (receipts.Job, error) {
+	return s.JobResult, s.JobError
+}
+
+func (s *mockReceiptService) PruneJobs() (int, error) {
+	return 0, nil
+}
+
+func (s *mockReceiptService) Review(id string, decision receipts.ReceiptStatus, reason string, actor string) (receipts.StoredReceipt, error) {
+	s.ReviewDecision = decision
+	s.ReviewReason = reason
+	return s.ReviewResult, s.ReviewError
+}
+
+func (s *mockReceiptService) Audit(id string) ([]receipts.AuditEntry, error) {
+	return s.AuditResult, s.AuditError
+}
+
+func (s *mockReceiptService) Void(id string, reason string, actor string) error {
+	s.DeletedID = id
+	s.VoidActor = actor
+	return s.VoidError
+}
+
+func (s *mockReceiptService) Delete(id string, reason string, actor string) error {
+	s.DeletedID = id
+	return s.DeleteError
+}
+
+func (s *mockReceiptService) GetUserPoints(id string) (receipts.UserPoints, error) {
+	return s.UserPointsResult, s.UserPointsError
+}
+
+func (s *mockReceiptService) Ledger(userID string) (receipts.UserLedger, error) {
+	return s.LedgerResult, nil
+}
+
+func (s *mockReceiptService) Adjust(userID string, points int64, reason string, actor string) (receipts.LedgerEntry, error) {
+	s.AdjustPoints = points
+	return s.AdjustResult, s.AdjustError
+}
+
+func (s *mockReceiptService) CheckLedger() (receipts.LedgerReport, error) {
+	return s.CheckResult, nil
+}
+
+func (s *mockReceiptService) Rewards() ([]receipts.Reward, error) {
+	return s.RewardsResult, nil
+}
+
+func (s *mockReceiptService) SaveReward(reward receipts.Reward) (receipts.Reward, error) {
+	s.SavedReward = reward
+	if s.SaveRewardError != nil {
+		return receipts.Reward{}, s.SaveRewardError
+	}
+	return reward, nil
+}
+
+func (s *mockReceiptService) Redeem(userID string, rewardID string) (receipts.Redemption, error) {
+	s.RedeemRewardID = rewardID
+	return s.RedeemResult, s.RedeemError
+}
+
+func (s *mockReceiptService) Redemptions(userID string) ([]receipts.Redemption, error) {
+	return s.RedemptionsResult, nil
+}
+
+func (s *mockReceiptService) Expirations(userID string) ([]receipts.Expiration, error) {
+	return s.ExpirationsResult, nil
+}
+
+func (s *mockReceiptService) ExpirePoints() ([]receipts.LedgerEntry, error) {
+	return s.ExpireResult, nil
+}
+
+func (s *mockReceiptService) RecalculateTiers() (int, error) {
+	return s.TierChanges, nil
+}
+
+func (s *mockReceiptService) Campaigns() ([]receipts.CampaignSummary, error) {
+	return s.CampaignsResult, nil
+}
+
+func (s *mockReceiptService) Campaign(id string) (receipts.CampaignSummary, error) {
+	if s.CampaignError != nil {
+		return receipts.CampaignSummary{}, s.CampaignError
+	}
+	return s.CampaignsResult[0], nil
+}
+
+func (s *mockReceiptService) SaveCampaign(c receipts.Campaign) (receipts.Campaign, error) {
+	s.SavedCampaign = c
+	if s.SaveCampaignError != nil {
+		return receipts.Campaign{}, s.SaveCampaignError
+	}
+	return c, nil
+}
+
+func (s *mockReceiptService) RuleSets() ([]receipts.RuleSet, error) {
+	return s.RuleSetsResult, nil
+}
+
+func (s *mockReceiptService) SaveRuleSet(rules receipts.RuleSet) (receipts.RuleSet, error) {
+	s.SavedRuleSet = rules
+	if s.RuleSetError != nil {
+		return receipts.RuleSet{}, s.RuleSetError
+	}
+	return rules, nil
+}
+
+func (s *mockReceiptService) PreviewRecompute(req receipts.RecomputeRequest) (receipts.Recomputation, error) {
+	s.RecomputeRequest = req
+	return s.RecomputationResult, s.RecomputationError
+}
+
+func (s *mockReceiptService) Recomputation(id string) (receipts.Recomputation, error) {
+	return s.RecomputationResult, s.RecomputationError
+}
+
+func (s *mockReceiptService) CommitRecompute(id string, actor string) (receipts.Recomputation, error) {
+	s.CommittedActor = actor
+	return s.RecomputationResult, s.RecomputationError
+}
+
+func (s *mockReceiptService) DeleteCampaign(id string) error {
+	s.DeletedCampaign = id
+	return s.CampaignError
+}
+
+func (s *mockReceiptService) CancelRedemption(userID string, id string) (receipts.Redemption, error) {
+	return s.CancelResult, s.CancelError
+}
+
+// problem returns the problem details expected in an error response, without the per-request fields.
+func problem(code errors.Code, detail string) errors.AppError {
+	return *errors.NewAppError(code, detail)
+}
+
+// validationProblem returns the problem details expected for an invalid receipt.
+func validationProblem(detail string, fieldErrors []errors.FieldError) errors.AppError {
+	return *errors.NewValidationError(detail, fieldErrors)
+}
+
+// readProblem decodes the problem details of response, checks the per-request
+// fields match req and clears them so the rest can be compared with problem.
+func readProblem(t *testing.T, response *httptest.ResponseRecorder, req *http.Request) errors.AppError {
+	assert.Equal(t, errors.ProblemContentType, response.Header().Get("Content-Type"))
+
+	var p errors.AppError
+	if err := json.Unmarshal(response.Body.Bytes(), &p); err != nil {
+		assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+	}
+	assert.Equal(t, response.Code, p.Status)
+	assert.Equal(t, req.URL.Path, p.Instance)
+	assert.NotEmpty(t, p.RequestID)
+	assert.Equal(t, response.Header().Get("X-Request-ID"), p.RequestID)
+
+	p.Instance = ""
+	p.RequestID = ""
+	return p
+}
+
 func TestHandlerGetPoints(t *testing.T) {
 	id := uuid.NewString()
 	tests := map[string]struct {
 		mockService receipts.Service
 		uri         string
+		token       string
+		header      string
 		response    interface{}
 		statusCode  int
 	}{
@@ -48,16 +314,50 @@ func TestHandlerGetPoints(t *testing.T) {
 			response:   receipts.PointsResponse{Points: 0},
 			statusCode: http.StatusOK,
 		},
+		"Tier bonus": {
+			mockService: &mockReceiptService{
+				GetPointsResult: receipts.Points{ID: id, Points: 125, Tier: "gold", TierBonus: 45},
+			},
+			uri: fmt.Sprintf("/receipts/%s/points", id),
+			response: receipts.PointsResponse{
+				Points: 125,
+				Tier:   &receipts.TierPointsResponse{Name: "gold", BasePoints: 80, Bonus: 45},
+			},
+			statusCode: http.StatusOK,
+		},
+		"Pending review": {
+			mockService: &mockReceiptService{
+				GetPointsError: receipts.ErrReceiptPending,
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points", id),
+			response:   receipts.PointsResponse{Points: 0, Status: receipts.StatusPending},
+			statusCode: http.StatusAccepted,
+		},
+		"Rejected in review": {
+			mockService: &mockReceiptService{
+				GetPointsError: receipts.ErrReceiptRejected,
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points", id),
+			response:   receipts.PointsResponse{Points: 0, Status: receipts.StatusRejected},
+			statusCode: http.StatusOK,
+		},
+		"Receipt of another user": {
+			mockService: &mockReceiptService{
+				GetReceiptResult: receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id, UserID: "user-1"}},
+				GetPointsResult:  receipts.Points{ID: id, Points: 28},
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points", id),
+			token:      signToken("secret", "user-2"),
+			response:   problem(errors.Forbidden, "The receipt belongs to another user"),
+			statusCode: http.StatusForbidden,
+		},
 		"ID not found": {
 			mockService: &mockReceiptService{
 				GetPointsResult: receipts.Points{},
 				GetPointsError:  receipts.ErrReceiptNotFound,
 			},
-			uri: fmt.Sprintf("/receipts/%s/points", "invalid_id"),
-			response: errors.AppError{
-				Code:        "404",
-				Description: "No receipt found for that id",
-			},
+			uri:        fmt.Sprintf("/receipts/%s/points", "invalid_id"),
+			response:   problem(errors.ReceiptNotFound, "No receipt found for that id"),
 			statusCode: http.StatusNotFound,
 		},
 	}
@@ -66,76 +366,209 @@ func TestHandlerGetPoints(t *testing.T) {
 		t.Run(testName, func(t *testing.T) {
 			response := httptest.NewRecorder()
 			router := gin.New()
-			Activate(router, test.mockService)
+			Activate(router, test.mockService, WithAdminToken("secret"), WithAuthSecret("secret"))
 
 			req, err := http.NewRequest(http.MethodGet, test.uri, nil)
 			assert.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Admin-Token", test.header)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
 
 			router.ServeHTTP(response, req)
 
 			assert.Equal(t, test.statusCode, response.Code)
 
-			if test.statusCode == http.StatusOK {
+			if test.statusCode < http.StatusBadRequest {
 				var p receipts.PointsResponse
 				if err := json.Unmarshal(response.Body.Bytes(), &p); err != nil {
 					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
 				}
 				assert.Equal(t, test.response, p)
 			} else {
-				var err errors.AppError
-				if err := json.Unmarshal(response.Body.Bytes(), &err); err != nil {
-					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
-				}
-				assert.Equal(t, test.response, err)
+				assert.Equal(t, test.response, readProblem(t, response, req))
 			}
 		})
 	}
 }
 
-func TestHandlerCreate(t *testing.T) {
+func TestHandlerGetReceipt(t *testing.T) {
 	id := uuid.NewString()
-	retailer := "Walgreens"
-	purchaseDate := "2022-01-02"
-	purchaseTime := "08:13"
-	total := "2.65"
-	items := `[{"shortDescription": "Pepsi - 12-oz", "price": "1.25"},{"shortDescription": "Dasani", "price": "1.40"}]`
-	receiptDTO := fmt.Sprintf(`{"retailer": "%s","purchaseDate": "%s","purchaseTime": "%s","total": "%s","items": %s}`,
-		retailer,
-		purchaseDate,
-		purchaseTime,
-		total,
-		items,
-	)
-
+	purchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
+	purchaseTime, _ := time.Parse("15:04", "13:01")
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	stored := receipts.StoredReceipt{
+		Receipt: receipts.Receipt{
+			ID:           id,
+			Retailer:     "Target",
+			PurchaseDate: purchaseDate,
+			PurchaseTime: purchaseTime,
+			Items: []receipts.Item{
+				{ShortDescription: "Mountain Dew 12PK", Price: 649},
+				{ShortDescription: "Emils Cheese Pizza", Price: 1200},
+			},
+			Total:     1849,
+			Status:    receipts.StatusApproved,
+			CreatedAt: createdAt,
+		},
+		Points: receipts.Points{ID: id, Points: 21},
+	}
+	owned := stored
+	owned.Receipt.UserID = "user-1"
+	ownedResponse := receipts.ReceiptResponse{
+		ID:           id,
+		UserID:       "user-1",
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []receipts.ItemDTO{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.00"},
+		},
+		Total:     "18.49",
+		Status:    receipts.StatusApproved,
+		Points:    21,
+		CreatedAt: createdAt,
+	}
+	rejected := stored
+	rejected.Receipt.Status = receipts.StatusRejected
+	flagged := stored
+	flagged.Receipt.Tax = 150
+	flagged.Receipt.Total = 50000
+	flagged.Receipt.Reconciliation = receipts.Reconciliation{
+		ItemsTotal: 1849,
+		Expected:   1999,
+		Difference: 48001,
+		Status:     receipts.ReconciliationMismatch,
+	}
+	flagged.Receipt.Status = receipts.StatusPending
+	flagged.Receipt.Risk = receipts.Risk{Score: 30, Signals: []receipts.RiskSignal{
+		{Check: "large_total", Score: 30, Reason: "total 500.00 is more than 100.00"},
+	}}
 	tests := map[string]struct {
 		mockService receipts.Service
 		uri         string
-		body        string
+		token       string
+		header      string
 		response    interface{}
 		statusCode  int
 	}{
-		"Successful Create": {
+		"Flagged receipt": {
 			mockService: &mockReceiptService{
-				CreateResult: receipts.Receipt{ID: id},
-				CreateError:  nil,
+				GetReceiptResult: flagged,
+			},
+			uri: fmt.Sprintf("/receipts/%s", id),
+			response: receipts.ReceiptResponse{
+				ID:           id,
+				Retailer:     "Target",
+				PurchaseDate: "2022-01-01",
+				PurchaseTime: "13:01",
+				Items: []receipts.ItemDTO{
+					{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+					{ShortDescription: "Emils Cheese Pizza", Price: "12.00"},
+				},
+				Total: "500.00",
+				Tax:   "1.50",
+				Reconciliation: &receipts.ReconciliationResponse{
+					ItemsTotal: "18.49",
+					Expected:   "19.99",
+					Difference: "480.01",
+					Status:     receipts.ReconciliationMismatch,
+				},
+				Status:    receipts.StatusPending,
+				Points:    0,
+				CreatedAt: createdAt,
 			},
-			uri:        "/receipts/process",
-			body:       receiptDTO,
-			response:   receipts.CreateResponse{ID: id},
 			statusCode: http.StatusOK,
 		},
-		"Invalid Receipt": {
+		"Rejected receipt": {
 			mockService: &mockReceiptService{
-				CreateResult: receipts.Receipt{},
-				CreateError:  receipts.ErrReceiptInvalid,
+				GetReceiptResult: rejected,
 			},
-			uri:  "/receipts/process",
-			body: "{}",
-			response: errors.AppError{
-				Code:        "400",
-				Description: "The receipt is invalid",
+			uri: fmt.Sprintf("/receipts/%s", id),
+			response: receipts.ReceiptResponse{
+				ID:           id,
+				Retailer:     "Target",
+				PurchaseDate: "2022-01-01",
+				PurchaseTime: "13:01",
+				Items: []receipts.ItemDTO{
+					{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+					{ShortDescription: "Emils Cheese Pizza", Price: "12.00"},
+				},
+				Total:     "18.49",
+				Status:    receipts.StatusRejected,
+				Points:    0,
+				CreatedAt: createdAt,
 			},
-			statusCode: http.StatusBadRequest,
+			statusCode: http.StatusOK,
+		},
+		"Successful Get": {
+			mockService: &mockReceiptService{
+				GetReceiptResult: stored,
+				GetReceiptError:  nil,
+			},
+			uri: fmt.Sprintf("/receipts/%s", id),
+			response: receipts.ReceiptResponse{
+				ID:           id,
+				Retailer:     "Target",
+				PurchaseDate: "2022-01-01",
+				PurchaseTime: "13:01",
+				Items: []receipts.ItemDTO{
+					{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+					{ShortDescription: "Emils Cheese Pizza", Price: "12.00"},
+				},
+				Total:     "18.49",
+				Status:    receipts.StatusApproved,
+				Points:    21,
+				CreatedAt: createdAt,
+			},
+			statusCode: http.StatusOK,
+		},
+		"Own receipt": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-1"),
+			response:    ownedResponse,
+			statusCode:  http.StatusOK,
+		},
+		"Receipt of another user": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-2"),
+			response:    problem(errors.Forbidden, "The receipt belongs to another user"),
+			statusCode:  http.StatusForbidden,
+		},
+		"Receipt of a user without token": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			response:    problem(errors.Unauthorized, "A bearer token is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"Admin reads receipt of a user": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			header:      "secret",
+			response:    ownedResponse,
+			statusCode:  http.StatusOK,
+		},
+		"Receipt voided": {
+			mockService: &mockReceiptService{
+				GetReceiptError: receipts.ErrReceiptGone,
+			},
+			uri:        fmt.Sprintf("/receipts/%s", id),
+			response:   problem(errors.ReceiptGone, "The receipt was voided or deleted"),
+			statusCode: http.StatusGone,
+		},
+		"ID not found": {
+			mockService: &mockReceiptService{
+				GetReceiptResult: receipts.StoredReceipt{},
+				GetReceiptError:  receipts.ErrReceiptNotFound,
+			},
+			uri:        fmt.Sprintf("/receipts/%s", "invalid_id"),
+			response:   problem(errors.ReceiptNotFound, "No receipt found for that id"),
+			statusCode: http.StatusNotFound,
 		},
 	}
 
@@ -143,60 +576,588 @@ func TestHandlerCreate(t *testing.T) {
 		t.Run(testName, func(t *testing.T) {
 			response := httptest.NewRecorder()
 			router := gin.New()
-			Activate(router, test.mockService)
+			Activate(router, test.mockService, WithAdminToken("secret"), WithAuthSecret("secret"))
 
-			req, err := http.NewRequest(http.MethodPost, test.uri, strings.NewReader(test.body))
+			req, err := http.NewRequest(http.MethodGet, test.uri, nil)
 			assert.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Admin-Token", test.header)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
 
 			router.ServeHTTP(response, req)
 
 			assert.Equal(t, test.statusCode, response.Code)
+
 			if test.statusCode == http.StatusOK {
-				var c receipts.CreateResponse
-				if err := json.Unmarshal(response.Body.Bytes(), &c); err != nil {
+				var r receipts.ReceiptResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &r); err != nil {
 					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
 				}
-				assert.Equal(t, test.response, c)
+				assert.Equal(t, test.response, r)
 			} else {
-				var err errors.AppError
-				if err := json.Unmarshal(response.Body.Bytes(), &err); err != nil {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerList(t *testing.T) {
+	id := uuid.NewString()
+	purchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
+	purchaseTime, _ := time.Parse("15:04", "13:01")
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	page := receipts.ReceiptPage{
+		Receipts: []receipts.StoredReceipt{{
+			Receipt: receipts.Receipt{
+				ID:           id,
+				Retailer:     "Target",
+				PurchaseDate: purchaseDate,
+				PurchaseTime: purchaseTime,
+				Items:        []receipts.Item{{ShortDescription: "Pepsi", Price: 125}},
+				Total:        125,
+				Status:       receipts.StatusApproved,
+				CreatedAt:    createdAt,
+			},
+			Points: receipts.Points{ID: id, Points: 6},
+		}},
+		NextCursor: "next",
+	}
+	minTotal, maxTotal, minPoints := receipts.Money(1000), receipts.Money(2550), int64(5)
+	from, _ := time.Parse("2006-01-02", "2022-01-01")
+	to, _ := time.Parse("2006-01-02", "2022-01-31")
+
+	listed := receipts.ListResponse{
+		Receipts: []receipts.ReceiptResponse{{
+			ID:           id,
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Items:        []receipts.ItemDTO{{ShortDescription: "Pepsi", Price: "1.25"}},
+			Total:        "1.25",
+			Status:       receipts.StatusApproved,
+			Points:       6,
+			CreatedAt:    createdAt,
+		}},
+		NextCursor: "next",
+	}
+
+	tests := map[string]struct {
+		uri        string
+		token      string
+		header     string
+		query      receipts.ReceiptQuery
+		response   interface{}
+		statusCode int
+	}{
+		"Successful List": {
+			uri: "/receipts?retailer=Target&retailerContains=tar&purchaseDateFrom=2022-01-01&purchaseDateTo=2022-01-31" +
+				"&minTotal=10.00&maxTotal=25.50&minPoints=5&sort=points&order=desc&limit=10&cursor=abc",
+			token: signToken("secret", "user-1"),
+			query: receipts.ReceiptQuery{
+				Retailer:         "Target",
+				RetailerContains: "tar",
+				PurchasedFrom:    from,
+				PurchasedTo:      to,
+				MinTotal:         &minTotal,
+				MaxTotal:         &maxTotal,
+				MinPoints:        &minPoints,
+				UserID:           "user-1",
+				SortBy:           receipts.SortByPoints,
+				Descending:       true,
+				Limit:            10,
+				Cursor:           "abc",
+			},
+			response:   listed,
+			statusCode: http.StatusOK,
+		},
+		"Own receipts": {
+			uri:        "/receipts?userId=user-1",
+			token:      signToken("secret", "user-1"),
+			query:      receipts.ReceiptQuery{UserID: "user-1"},
+			response:   listed,
+			statusCode: http.StatusOK,
+		},
+		"Receipts of another user": {
+			uri:        "/receipts?userId=user-2",
+			token:      signToken("secret", "user-1"),
+			response:   problem(errors.Forbidden, "The caller may only access their own account"),
+			statusCode: http.StatusForbidden,
+		},
+		"Without token": {
+			uri:        "/receipts",
+			response:   problem(errors.Unauthorized, "A bearer token is required"),
+			statusCode: http.StatusUnauthorized,
+		},
+		"Admin lists every user": {
+			uri:        "/receipts",
+			header:     "secret",
+			query:      receipts.ReceiptQuery{},
+			response:   listed,
+			statusCode: http.StatusOK,
+		},
+		"Admin filters by user": {
+			uri:        "/receipts?userId=user-2",
+			header:     "secret",
+			query:      receipts.ReceiptQuery{UserID: "user-2"},
+			response:   listed,
+			statusCode: http.StatusOK,
+		},
+		"Invalid date": {
+			uri:        "/receipts?purchaseDateFrom=yesterday",
+			token:      signToken("secret", "user-1"),
+			response:   problem(errors.QueryInvalid, "The query is invalid"),
+			statusCode: http.StatusBadRequest,
+		},
+		"Invalid order": {
+			uri:        "/receipts?order=sideways",
+			token:      signToken("secret", "user-1"),
+			response:   problem(errors.QueryInvalid, "The query is invalid"),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mockService := &mockReceiptService{ListResult: page}
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, mockService, WithAdminToken("secret"), WithAuthSecret("secret"))
+
+			req, err := http.NewRequest(http.MethodGet, test.uri, nil)
+			assert.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Admin-Token", test.header)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+
+			if test.statusCode == http.StatusOK {
+				assert.Equal(t, test.query, mockService.ListQuery)
+
+				var l receipts.ListResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &l); err != nil {
 					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
 				}
-				assert.Equal(t, test.response, err)
+				assert.Equal(t, test.response, l)
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
 			}
 		})
 	}
 }
 
-func TestToReceipt(t *testing.T) {
-	targetPurchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
-	targetPurchaseTime, _ := time.Parse("15:04", "13:01")
-	cornerMarketPurchaseDate, _ := time.Parse("2006-01-02", "2022-03-20")
-	cornerMarketPurchaseTime, _ := time.Parse("15:04", "14:33")
-	targetItemsDTO := []receipts.ItemDTO{
+func TestHandlerGetBreakdown(t *testing.T) {
+	id := uuid.NewString()
+	breakdown := []receipts.PointsDetail{
+		{Rule: "retailer_name", Points: 6, Reason: `6 alphanumeric characters in retailer name "Target"`},
 		{
-			ShortDescription: "Mountain Dew 12PK",
-			Price:            "6.49",
-		}, {
-			ShortDescription: "Emils Cheese Pizza",
-			Price:            "12.25",
-		}, {
-			ShortDescription: "Knorr Creamy Chicken",
-			Price:            "1.26",
-		}, {
-			ShortDescription: "Doritos Nacho Cheese",
-			Price:            "3.35",
-		}, {
-			ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ",
-			Price:            "12.00",
+			Rule:   "item_description_length",
+			Points: 3,
+			Reason: "trimmed item description length is a multiple of 3, price multiplied by 0.2 and rounded up",
+			Items:  []receipts.ItemPoints{{Index: 1, ShortDescription: "Emils Cheese Pizza", Points: 3}},
 		},
 	}
-	cornerMarketItemsDTO := []receipts.ItemDTO{
-		{
-			ShortDescription: "Gatorade",
-			Price:            "2.25",
-		}, {
-			ShortDescription: "Gatorade",
+	tierBreakdown := append(breakdown[:len(breakdown):len(breakdown)],
+		receipts.PointsDetail{Rule: "tier", Points: 1, Reason: "silver tier: 1.1x 9 base points"})
+	tests := map[string]struct {
+		mockService receipts.Service
+		uri         string
+		token       string
+		header      string
+		response    interface{}
+		statusCode  int
+	}{
+		"Successful Get": {
+			mockService: &mockReceiptService{
+				GetPointsResult: receipts.Points{ID: id, Points: 9, Breakdown: breakdown},
+				GetPointsError:  nil,
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points/breakdown", id),
+			response:   receipts.BreakdownResponse{Points: 9, Breakdown: breakdown},
+			statusCode: http.StatusOK,
+		},
+		"Tier bonus": {
+			mockService: &mockReceiptService{
+				GetPointsResult: receipts.Points{ID: id, Points: 10, Breakdown: tierBreakdown, Tier: "silver", TierBonus: 1},
+			},
+			uri: fmt.Sprintf("/receipts/%s/points/breakdown", id),
+			response: receipts.BreakdownResponse{
+				Points:    10,
+				Breakdown: tierBreakdown,
+				Tier:      &receipts.TierPointsResponse{Name: "silver", BasePoints: 9, Bonus: 1},
+			},
+			statusCode: http.StatusOK,
+		},
+		"Pending review": {
+			mockService: &mockReceiptService{
+				GetPointsError: receipts.ErrReceiptPending,
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points/breakdown", id),
+			response:   receipts.PointsResponse{Points: 0, Status: receipts.StatusPending},
+			statusCode: http.StatusAccepted,
+		},
+		"Receipt of another user": {
+			mockService: &mockReceiptService{
+				GetReceiptResult: receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id, UserID: "user-1"}},
+				GetPointsResult:  receipts.Points{ID: id, Points: 9, Breakdown: breakdown},
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points/breakdown", id),
+			token:      signToken("secret", "user-2"),
+			response:   problem(errors.Forbidden, "The receipt belongs to another user"),
+			statusCode: http.StatusForbidden,
+		},
+		"Own receipt": {
+			mockService: &mockReceiptService{
+				GetReceiptResult: receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id, UserID: "user-1"}},
+				GetPointsResult:  receipts.Points{ID: id, Points: 9, Breakdown: breakdown},
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points/breakdown", id),
+			token:      signToken("secret", "user-1"),
+			response:   receipts.BreakdownResponse{Points: 9, Breakdown: breakdown},
+			statusCode: http.StatusOK,
+		},
+		"ID not found": {
+			mockService: &mockReceiptService{
+				GetPointsResult: receipts.Points{},
+				GetPointsError:  receipts.ErrReceiptNotFound,
+			},
+			uri:        fmt.Sprintf("/receipts/%s/points/breakdown", "invalid_id"),
+			response:   problem(errors.ReceiptNotFound, "No receipt found for that id"),
+			statusCode: http.StatusNotFound,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAdminToken("secret"), WithAuthSecret("secret"))
+
+			req, err := http.NewRequest(http.MethodGet, test.uri, nil)
+			assert.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Admin-Token", test.header)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+
+			if test.statusCode == http.StatusOK {
+				var b receipts.BreakdownResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &b); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, b)
+			} else if test.statusCode == http.StatusAccepted {
+				var p receipts.PointsResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &p); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, p)
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerCreate(t *testing.T) {
+	id := uuid.NewString()
+	retailer := "Walgreens"
+	purchaseDate := "2022-01-02"
+	purchaseTime := "08:13"
+	total := "2.65"
+	items := `[{"shortDescription": "Pepsi - 12-oz", "price": "1.25"},{"shortDescription": "Dasani", "price": "1.40"}]`
+	receiptDTO := fmt.Sprintf(`{"retailer": "%s","purchaseDate": "%s","purchaseTime": "%s","total": "%s","items": %s}`,
+		retailer,
+		purchaseDate,
+		purchaseTime,
+		total,
+		items,
+	)
+
+	tests := map[string]struct {
+		mockService receipts.Service
+		uri         string
+		body        string
+		response    interface{}
+		statusCode  int
+	}{
+		"Successful Create": {
+			mockService: &mockReceiptService{
+				CreateResult: receipts.Receipt{ID: id},
+				CreateError:  nil,
+			},
+			uri:        "/receipts/process",
+			body:       receiptDTO,
+			response:   receipts.CreateResponse{ID: id},
+			statusCode: http.StatusOK,
+		},
+		"Duplicate Receipt": {
+			mockService: &mockReceiptService{
+				CreateResult: receipts.Receipt{},
+				CreateError:  receipts.ErrReceiptDuplicate,
+			},
+			uri:        "/receipts/process",
+			body:       receiptDTO,
+			response:   problem(errors.ReceiptDuplicate, "The receipt was already processed"),
+			statusCode: http.StatusConflict,
+		},
+		"Total Mismatch": {
+			mockService: &mockReceiptService{
+				CreateResult: receipts.Receipt{},
+				CreateError:  fmt.Errorf("%w: items, tax and tip less discount come to 2.65 but the total is 500.00", receipts.ErrReceiptTotalMismatch),
+			},
+			uri:  "/receipts/process",
+			body: receiptDTO,
+			response: problem(errors.ReceiptTotalMismatch,
+				"The receipt total does not match its items: items, tax and tip less discount come to 2.65 but the total is 500.00"),
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		"Invalid Receipt": {
+			mockService: &mockReceiptService{
+				CreateResult: receipts.Receipt{},
+				CreateError:  receipts.ErrReceiptInvalid,
+			},
+			uri:  "/receipts/process",
+			body: "{}",
+			response: validationProblem("5 invalid fields", []errors.FieldError{
+				{Path: "/retailer", Code: errors.FieldRequired, Message: "retailer is required"},
+				{Path: "/purchaseDate", Code: errors.FieldRequired, Message: "purchaseDate is required"},
+				{Path: "/purchaseTime", Code: errors.FieldRequired, Message: "purchaseTime is required"},
+				{Path: "/items", Code: errors.FieldRequired, Message: "items is required"},
+				{Path: "/total", Code: errors.FieldRequired, Message: "total is required"},
+			}),
+			statusCode: http.StatusBadRequest,
+		},
+		"Every invalid field is reported": {
+			mockService: &mockReceiptService{},
+			uri:         "/receipts/process",
+			body: `{"retailer": "Target","purchaseDate": "2022-13-01","purchaseTime": "13:01","total": "12",` +
+				`"items": [{"shortDescription": "Pepsi", "price": "1.25"},{"shortDescription": "", "price": "1e2"},` +
+				`{"shortDescription": "Dasani", "price": "1.400"}]}`,
+			response: validationProblem("5 invalid fields", []errors.FieldError{
+				{Path: "/items/1/shortDescription", Code: errors.FieldRequired, Message: "shortDescription is required"},
+				{Path: "/purchaseDate", Code: errors.FieldInvalidFormat, Message: "purchase date must be a date in YYYY-MM-DD format"},
+				{Path: "/items/1/price", Code: errors.FieldInvalidFormat, Message: "amount must be dollars and cents, e.g. 6.49"},
+				{Path: "/items/2/price", Code: errors.FieldInvalidFormat, Message: "amount must be dollars and cents, e.g. 6.49"},
+				{Path: "/total", Code: errors.FieldInvalidFormat, Message: "amount must be dollars and cents, e.g. 6.49"},
+			}),
+			statusCode: http.StatusBadRequest,
+		},
+		"Empty items": {
+			mockService: &mockReceiptService{},
+			uri:         "/receipts/process",
+			body:        `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": "1.00","items": []}`,
+			response: validationProblem("1 invalid fields", []errors.FieldError{
+				{Path: "/items", Code: errors.FieldTooShort, Message: "items must have at least 1 entries"},
+			}),
+			statusCode: http.StatusBadRequest,
+		},
+		"Wrong JSON type": {
+			mockService: &mockReceiptService{},
+			uri:         "/receipts/process",
+			body:        `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": 1.00,"items": []}`,
+			response: validationProblem("1 invalid fields", []errors.FieldError{
+				{Path: "/total", Code: errors.FieldInvalidType, Message: "total must be a string"},
+			}),
+			statusCode: http.StatusBadRequest,
+		},
+		"Malformed JSON": {
+			mockService: &mockReceiptService{},
+			uri:         "/receipts/process",
+			body:        `{"retailer": `,
+			response: validationProblem("1 invalid fields", []errors.FieldError{
+				{Path: "", Code: errors.FieldInvalidFormat, Message: "request body must be a JSON object"},
+			}),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService)
+
+			req, err := http.NewRequest(http.MethodPost, test.uri, strings.NewReader(test.body))
+			assert.NoError(t, err)
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			if test.statusCode == http.StatusOK {
+				var c receipts.CreateResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &c); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, c)
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerSimulate(t *testing.T) {
+	body := `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": "1.25",` +
+		`"items": [{"shortDescription": "Pepsi", "price": "1.25"}]}`
+	simulation := receipts.Simulation{
+		Receipt: receipts.Receipt{
+			Status:         receipts.StatusApproved,
+			Reconciliation: receipts.Reconciliation{ItemsTotal: 125, Expected: 125, Status: receipts.ReconciliationMatched},
+		},
+		Points: receipts.Points{
+			Points:      6,
+			RuleVersion: "v2",
+			Breakdown:   []receipts.PointsDetail{{Rule: "retailer_name", Points: 6, Reason: "6 alphanumeric characters in the retailer name"}},
+		},
+	}
+
+	held := simulation
+	held.Receipt.Status = receipts.StatusPending
+	held.Receipt.Risk = receipts.Risk{Score: 60, Signals: []receipts.RiskSignal{{Check: "rate", Score: 60, Reason: "12 receipts in the last hour"}}}
+
+	tests := map[string]struct {
+		mockService *mockReceiptService
+		uri         string
+		body        string
+		response    interface{}
+		statusCode  int
+		request     receipts.SimulationRequest
+	}{
+		"Simulate": {
+			mockService: &mockReceiptService{SimulationResult: simulation},
+			uri:         "/receipts/simulate?version=v2&campaign=gatorade",
+			body:        body,
+			response: receipts.SimulationResponse{
+				Points:      6,
+				Breakdown:   simulation.Points.Breakdown,
+				RuleVersion: "v2",
+				Status:      receipts.StatusApproved,
+				Reconciliation: &receipts.ReconciliationResponse{
+					ItemsTotal: "1.25",
+					Expected:   "1.25",
+					Difference: "0.00",
+					Status:     receipts.ReconciliationMatched,
+				},
+			},
+			statusCode: http.StatusOK,
+			request:    receipts.SimulationRequest{Version: "v2", CampaignID: "gatorade"},
+		},
+		"Held for review without risk": {
+			mockService: &mockReceiptService{SimulationResult: held},
+			uri:         "/receipts/simulate",
+			body:        body,
+			response: receipts.SimulationResponse{
+				Points:      6,
+				Breakdown:   simulation.Points.Breakdown,
+				RuleVersion: "v2",
+				Status:      receipts.StatusPending,
+				Reconciliation: &receipts.ReconciliationResponse{
+					ItemsTotal: "1.25",
+					Expected:   "1.25",
+					Difference: "0.00",
+					Status:     receipts.ReconciliationMatched,
+				},
+			},
+			statusCode: http.StatusOK,
+		},
+		"Unknown rule set": {
+			mockService: &mockReceiptService{SimulateError: receipts.ErrRuleSetNotFound},
+			uri:         "/receipts/simulate?version=v9",
+			body:        body,
+			response:    problem(errors.RuleSetNotFound, "No rule set found for that version"),
+			statusCode:  http.StatusNotFound,
+			request:     receipts.SimulationRequest{Version: "v9"},
+		},
+		"Duplicate": {
+			mockService: &mockReceiptService{SimulateError: receipts.ErrReceiptDuplicate},
+			uri:         "/receipts/simulate",
+			body:        body,
+			response:    problem(errors.ReceiptDuplicate, "The receipt was already processed"),
+			statusCode:  http.StatusConflict,
+		},
+		"Invalid Receipt": {
+			mockService: &mockReceiptService{},
+			uri:         "/receipts/simulate",
+			body:        `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": "1.00","items": []}`,
+			response: validationProblem("1 invalid fields", []errors.FieldError{
+				{Path: "/items", Code: errors.FieldTooShort, Message: "items must have at least 1 entries"},
+			}),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService)
+
+			req, err := http.NewRequest(http.MethodPost, test.uri, strings.NewReader(test.body))
+			assert.NoError(t, err)
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			assert.Equal(t, test.request, test.mockService.SimulateRequest)
+			assert.Empty(t, test.mockService.CreateReceipt.Retailer)
+			if test.statusCode == http.StatusOK {
+				var s receipts.SimulationResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &s); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, s)
+				assert.Equal(t, "Target", test.mockService.SimulateReceipt.Retailer)
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestToReceipt(t *testing.T) {
+	targetPurchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
+	targetPurchaseTime, _ := time.Parse("15:04", "13:01")
+	cornerMarketPurchaseDate, _ := time.Parse("2006-01-02", "2022-03-20")
+	cornerMarketPurchaseTime, _ := time.Parse("15:04", "14:33")
+	targetItemsDTO := []receipts.ItemDTO{
+		{
+			ShortDescription: "Mountain Dew 12PK",
+			Price:            "6.49",
+		}, {
+			ShortDescription: "Emils Cheese Pizza",
+			Price:            "12.25",
+		}, {
+			ShortDescription: "Knorr Creamy Chicken",
+			Price:            "1.26",
+		}, {
+			ShortDescription: "Doritos Nacho Cheese",
+			Price:            "3.35",
+		}, {
+			ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ",
+			Price:            "12.00",
+		},
+	}
+	cornerMarketItemsDTO := []receipts.ItemDTO{
+		{
+			ShortDescription: "Gatorade",
+			Price:            "2.25",
+		}, {
+			ShortDescription: "Gatorade",
 			Price:            "2.25",
 		}, {
 			ShortDescription: "Gatorade",
@@ -285,6 +1246,11 @@ func TestToReceipt(t *testing.T) {
 			input:  cornerMarketDTO,
 			result: cornerMarketReceipt,
 		},
+
+		"Tax, discount and tip mapped successfully": {
+			input:  withLines(cornerMarketDTO, "0.72", "1.00", "2.00"),
+			result: withAmounts(cornerMarketReceipt, 72, 100, 200),
+		},
 	}
 
 	for testName, test := range tests {
@@ -295,3 +1261,1537 @@ func TestToReceipt(t *testing.T) {
 		})
 	}
 }
+
+func withLines(dto receipts.ReceiptDTO, tax, discount, tip string) receipts.ReceiptDTO {
+	dto.Tax, dto.Discount, dto.Tip = tax, discount, tip
+	return dto
+}
+
+func withAmounts(r receipts.Receipt, tax, discount, tip receipts.Money) receipts.Receipt {
+	r.Tax, r.Discount, r.Tip = tax, discount, tip
+	return r
+}
+
+func TestToReceiptInvalidMoney(t *testing.T) {
+	tests := map[string]struct {
+		price string
+		total string
+	}{
+		"Exponent price":   {price: "1e2", total: "100.00"},
+		"NaN price":        {price: "NaN", total: "1.00"},
+		"Negative price":   {price: "-3.50", total: "1.00"},
+		"Rounded price":    {price: "12.345", total: "12.35"},
+		"Exponent total":   {price: "1.00", total: "1e0"},
+		"Negative total":   {price: "1.00", total: "-1.00"},
+		"Overflow total":   {price: "1.00", total: "99999999999999999999.00"},
+		"Whole dollars":    {price: "1", total: "1.00"},
+		"Rounded total":    {price: "1.00", total: "1.001"},
+		"Infinite total":   {price: "1.00", total: "Inf"},
+		"Hex total":        {price: "1.00", total: "0x1p-2"},
+		"Underscore total": {price: "1.00", total: "1_0.00"},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := toReceipt(receipts.ReceiptDTO{
+				Retailer:     "Target",
+				PurchaseDate: "2022-01-01",
+				PurchaseTime: "13:01",
+				Items:        []receipts.ItemDTO{{ShortDescription: "Pepsi", Price: test.price}},
+				Total:        test.total,
+			})
+
+			assert.ErrorIs(t, err, receipts.ErrReceiptInvalid)
+		})
+	}
+}
+
+func TestMoneyFieldError(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		code string
+	}{
+		"Overflow":         {err: receipts.ErrMoneyOverflow, code: errors.FieldTooLarge},
+		"Wrapped overflow": {err: fmt.Errorf("total: %w", receipts.ErrMoneyOverflow), code: errors.FieldTooLarge},
+		"Invalid format":   {err: fmt.Errorf("not a number"), code: errors.FieldInvalidFormat},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			assert.Equal(t, test.code, moneyFieldError("/total", test.err).Code)
+		})
+	}
+}
+
+func TestToReceiptFieldErrors(t *testing.T) {
+	_, err := toReceipt(receipts.ReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "01/01/2022",
+		PurchaseTime: "1:01 PM",
+		Items: []receipts.ItemDTO{
+			{ShortDescription: "Pepsi", Price: "1.25"},
+			{ShortDescription: "Dasani", Price: "99999999999999999999.00"},
+		},
+		Total: "1.5",
+		Tax:   "0.1",
+		Tip:   "-1.00",
+	})
+
+	var validationErr *receipts.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []errors.FieldError{
+		{Path: "/purchaseDate", Code: errors.FieldInvalidFormat, Message: "purchase date must be a date in YYYY-MM-DD format"},
+		{Path: "/purchaseTime", Code: errors.FieldInvalidFormat, Message: "purchase time must be a 24-hour time in HH:MM format"},
+		{Path: "/items/1/price", Code: errors.FieldTooLarge, Message: "amount is too large"},
+		{Path: "/total", Code: errors.FieldInvalidFormat, Message: "amount must be dollars and cents, e.g. 6.49"},
+		{Path: "/tax", Code: errors.FieldInvalidFormat, Message: "amount must be dollars and cents, e.g. 6.49"},
+		{Path: "/tip", Code: errors.FieldInvalidFormat, Message: "amount must be dollars and cents, e.g. 6.49"},
+	}, validationErr.Fields)
+}
+
+func TestHandlerConcurrentRequests(t *testing.T) {
+	router := gin.New()
+	Activate(router, receipts.NewReceiptService(db.NewDB()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"retailer": "Target %d","purchaseDate": "2022-01-01","purchaseTime": "13:01",`+
+				`"total": "1.25","items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`, i)
+			created := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+			router.ServeHTTP(created, req)
+			assert.Equal(t, http.StatusOK, created.Code)
+
+			var c receipts.CreateResponse
+			assert.NoError(t, json.Unmarshal(created.Body.Bytes(), &c))
+
+			for j := 0; j < 5; j++ {
+				response := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/receipts/%s/points", c.ID), nil)
+				router.ServeHTTP(response, req)
+				assert.Equal(t, http.StatusOK, response.Code)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHandlerCreateBatch(t *testing.T) {
+	receipt := func(retailer string) string {
+		return fmt.Sprintf(`{"retailer": "%s","purchaseDate": "2022-01-01","purchaseTime": "13:01",`+
+			`"total": "1.25","items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`, retailer)
+	}
+	existing := receipt("Walgreens")
+	invalid := `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": "1.00","items": []}`
+	tooShort := validationProblem("1 invalid fields", []errors.FieldError{
+		{Path: "/items", Code: errors.FieldTooShort, Message: "items must have at least 1 entries"},
+	})
+	duplicate := problem(errors.ReceiptDuplicate, "The receipt was already processed")
+
+	tests := map[string]struct {
+		contentType string
+		body        string
+		results     []receipts.BatchResultResponse
+		response    interface{}
+		statusCode  int
+	}{
+		"JSON array": {
+			contentType: "application/json",
+			body:        "[" + receipt("Target") + "," + invalid + "," + existing + "," + receipt("Costco") + "]",
+			results: []receipts.BatchResultResponse{
+				{Index: 0},
+				{Index: 1, Error: &tooShort},
+				{Index: 2, Error: &duplicate},
+				{Index: 3},
+			},
+			statusCode: http.StatusOK,
+		},
+		"NDJSON": {
+			contentType: "application/x-ndjson",
+			body:        receipt("Target") + "\n\n" + `{"retailer": ` + "\n" + receipt("Costco") + "\n",
+			results: []receipts.BatchResultResponse{
+				{Index: 0},
+				{Index: 1, Error: func() *errors.AppError {
+					p := validationProblem("1 invalid fields", []errors.FieldError{
+						{Path: "", Code: errors.FieldInvalidFormat, Message: "request body must be a JSON object"},
+					})
+					return &p
+				}()},
+				{Index: 2},
+			},
+			statusCode: http.StatusOK,
+		},
+		"Too many receipts": {
+			contentType: "application/json",
+			body:        "[" + receipt("Target") + "," + receipt("Costco") + "," + receipt("Kroger") + "]",
+			response:    problem(errors.BatchTooLarge, "a batch may have at most 2 receipts"),
+			statusCode:  http.StatusRequestEntityTooLarge,
+		},
+		"Not an array": {
+			contentType: "application/json",
+			body:        receipt("Target"),
+			response:    problem(errors.BatchInvalid, "request body must be a JSON array of receipts or NDJSON"),
+			statusCode:  http.StatusBadRequest,
+		},
+		"Malformed receipt": {
+			contentType: "application/json",
+			body:        "[" + receipt("Target") + ", {",
+			response:    problem(errors.BatchInvalid, "receipt 1 is not valid JSON"),
+			statusCode:  http.StatusBadRequest,
+		},
+		"Empty batch": {
+			contentType: "application/json",
+			body:        "[]",
+			response:    problem(errors.BatchInvalid, "a batch needs at least 1 receipt"),
+			statusCode:  http.StatusBadRequest,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			router := gin.New()
+			maxBatchSize := DefaultMaxBatchSize
+			if test.statusCode == http.StatusRequestEntityTooLarge {
+				maxBatchSize = 2
+			}
+			Activate(router, receipts.NewReceiptService(db.NewDB()), WithMaxBatchSize(maxBatchSize))
+			created := httptest.NewRecorder()
+			router.ServeHTTP(created, httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(existing)))
+			assert.Equal(t, http.StatusOK, created.Code)
+
+			response := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/receipts/process/batch", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			if test.statusCode != http.StatusOK {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+				return
+			}
+			var batch receipts.BatchResponse
+			if err := json.Unmarshal(response.Body.Bytes(), &batch); err != nil {
+				assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+			}
+			failed := 0
+			for i, result := range batch.Results {
+				if result.Error != nil {
+					failed++
+					continue
+				}
+				points := httptest.NewRecorder()
+				router.ServeHTTP(points, httptest.NewRequest(http.MethodGet, "/receipts/"+result.ID+"/points", nil))
+				assert.Equal(t, http.StatusOK, points.Code)
+				batch.Results[i].ID = ""
+			}
+			assert.Equal(t, test.results, batch.Results)
+			assert.Equal(t, len(test.results)-failed, batch.Succeeded)
+			assert.Equal(t, failed, batch.Failed)
+		})
+	}
+}
+
+func TestHandlerCreateBatchSubmissionRate(t *testing.T) {
+	service := receipts.NewReceiptService(db.NewDB(),
+		receipts.WithRiskChecks(30, receipts.SubmissionRateCheck(10, time.Minute, time.Now)))
+	router := gin.New()
+	Activate(router, service)
+
+	entries := make([]string, 12)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"retailer": "Store %d","purchaseDate": "2022-01-01","purchaseTime": "13:01",`+
+			`"total": "1.25","items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`, i)
+	}
+	response := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/receipts/process/batch", strings.NewReader("["+strings.Join(entries, ",")+"]"))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	var batch receipts.BatchResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &batch); err != nil {
+		assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+	}
+	assert.Equal(t, 12, batch.Succeeded)
+	for _, result := range batch.Results {
+		points := httptest.NewRecorder()
+		router.ServeHTTP(points, httptest.NewRequest(http.MethodGet, "/receipts/"+result.ID+"/points", nil))
+		assert.Equal(t, http.StatusOK, points.Code, "receipt %d was held for review", result.Index)
+	}
+}
+
+func TestHandlerJobs(t *testing.T) {
+	body := `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01",` +
+		`"total": "1.25","items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`
+	duplicate := problem(errors.ReceiptDuplicate, "The receipt was already processed")
+
+	router := gin.New()
+	Activate(router, receipts.NewReceiptService(db.NewDB()), WithJobWorkers(2))
+	submit := func(prefer string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+		req.Header.Set("Prefer", prefer)
+		router.ServeHTTP(response, req)
+		return response
+	}
+	// finish polls a job until the workers have processed it.
+	finish := func(location string) receipts.JobResponse {
+		var job receipts.JobResponse
+		assert.Eventually(t, func() bool {
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, location, nil))
+			assert.Equal(t, http.StatusOK, response.Code)
+			if err := json.Unmarshal(response.Body.Bytes(), &job); err != nil {
+				assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+			}
+			return job.Status == receipts.JobSucceeded || job.Status == receipts.JobFailed
+		}, 5*time.Second, 10*time.Millisecond)
+		return job
+	}
+
+	accepted := submit("wait=10, respond-async")
+	assert.Equal(t, http.StatusAccepted, accepted.Code)
+	assert.Equal(t, "respond-async", accepted.Header().Get("Preference-Applied"))
+	var queued receipts.JobResponse
+	if err := json.Unmarshal(accepted.Body.Bytes(), &queued); err != nil {
+		assert.Fail(t, "failed to unmarshal", accepted.Body.String(), err)
+	}
+	assert.Equal(t, "/jobs/"+queued.ID, accepted.Header().Get("Location"))
+	assert.Equal(t, receipts.JobQueued, queued.Status)
+
+	job := finish(accepted.Header().Get("Location"))
+	assert.Equal(t, receipts.JobSucceeded, job.Status)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Nil(t, job.Error)
+	assert.NotNil(t, job.FinishedAt)
+	points := httptest.NewRecorder()
+	router.ServeHTTP(points, httptest.NewRequest(http.MethodGet, "/receipts/"+job.ReceiptID+"/points", nil))
+	assert.Equal(t, http.StatusOK, points.Code)
+
+	accepted = submit("respond-async")
+	assert.Equal(t, http.StatusAccepted, accepted.Code)
+	job = finish(accepted.Header().Get("Location"))
+	assert.Equal(t, receipts.JobFailed, job.Status)
+	assert.Empty(t, job.ReceiptID)
+	assert.Equal(t, &duplicate, job.Error)
+
+	synchronous := submit("return=minimal")
+	assert.Equal(t, http.StatusConflict, synchronous.Code)
+	assert.Empty(t, synchronous.Header().Get("Preference-Applied"))
+
+	response := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(`{"retailer": "Target"}`))
+	req.Header.Set("Prefer", "respond-async")
+	router.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/jobs/missing", nil)
+	router.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Equal(t, problem(errors.JobNotFound, "No job found for that id"), readProblem(t, response, req))
+}
+
+func TestHandlerEnqueue(t *testing.T) {
+	body := `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01",` +
+		`"total": "1.25","items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`
+	tests := map[string]struct {
+		workers    int
+		service    mockReceiptService
+		statusCode int
+		retryAfter string
+		queued     bool
+	}{
+		"Queued": {
+			workers:    1,
+			service:    mockReceiptService{EnqueueResult: receipts.Job{ID: "job", Status: receipts.JobQueued}},
+			statusCode: http.StatusAccepted,
+			queued:     true,
+		},
+		"Queue full": {
+			workers:    1,
+			service:    mockReceiptService{EnqueueError: receipts.ErrJobQueueFull},
+			statusCode: http.StatusServiceUnavailable,
+			retryAfter: queueFullRetryAfter,
+			queued:     true,
+		},
+		"No workers": {
+			service:    mockReceiptService{CreateResult: receipts.Receipt{ID: "receipt"}},
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			router := gin.New()
+			Activate(router, &test.service, WithJobWorkers(test.workers))
+
+			response := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+			req.Header.Set("Prefer", "respond-async")
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			assert.Equal(t, test.retryAfter, response.Header().Get("Retry-After"))
+			assert.Equal(t, test.queued, test.service.EnqueueReceipt.Retailer == "Target")
+			if test.statusCode == http.StatusServiceUnavailable {
+				assert.Equal(t, problem(errors.JobQueueFull, "Too many receipts are waiting to be processed"), readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerIdempotency(t *testing.T) {
+	body := `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": "1.25",` +
+		`"items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`
+	otherBody := strings.Replace(body, "Target", "Walgreens", 1)
+
+	router := gin.New()
+	Activate(router, receipts.NewReceiptService(db.NewDB()))
+	process := func(key string, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	first := process("key-1", body)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "", first.Header().Get("Idempotent-Replayed"))
+
+	retry := process("key-1", body)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	reused := process("key-1", otherBody)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	var e errors.AppError
+	assert.NoError(t, json.Unmarshal(reused.Body.Bytes(), &e))
+	assert.Equal(t, errors.IdempotencyKeyReused, e.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, e.Status)
+
+	invalid := process("key-2", "{}")
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	invalidRetry := process("key-2", "{}")
+	assert.Equal(t, http.StatusBadRequest, invalidRetry.Code)
+	assert.Equal(t, "true", invalidRetry.Header().Get("Idempotent-Replayed"))
+
+	withoutKey := process("", otherBody)
+	assert.Equal(t, http.StatusOK, withoutKey.Code)
+	assert.NotEqual(t, first.Body.String(), withoutKey.Body.String())
+
+	tooLarge := process("key-3", strings.Repeat(" ", maxIdempotentBodySize)+body)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.Code)
+	assert.NoError(t, json.Unmarshal(tooLarge.Body.Bytes(), &e))
+	assert.Equal(t, errors.RequestTooLarge, e.Code)
+}
+
+func TestHandlerRedeemIdempotency(t *testing.T) {
+	router := gin.New()
+	Activate(router, receipts.NewReceiptService(db.NewDB()), WithAdminToken("admin"), WithAuthSecret("secret"))
+	send := func(method string, uri string, body string, key string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signToken("secret", "user"))
+		req.Header.Set("X-Admin-Token", "admin")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(response, req)
+		return response
+	}
+	balance := func() int64 {
+		response := send(http.MethodGet, "/users/user/points", "", "")
+		assert.Equal(t, http.StatusOK, response.Code)
+		var userPoints receipts.UserPointsResponse
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &userPoints))
+		return userPoints.Balance
+	}
+
+	created := send(http.MethodPost, "/receipts/process", `{"retailer": "Target","purchaseDate": "2022-01-01",`+
+		`"purchaseTime": "13:01","total": "1.25","items": [{"shortDescription": "Pepsi - 12-oz", "price": "1.25"}]}`, "")
+	assert.Equal(t, http.StatusOK, created.Code)
+	saved := send(http.MethodPut, "/admin/rewards/mug", `{"name": "Mug", "cost": 5, "stock": 5}`, "")
+	assert.Equal(t, http.StatusOK, saved.Code)
+	before := balance()
+
+	first := send(http.MethodPost, "/users/user/redemptions", `{"rewardId": "mug"}`, "redeem-1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	retry := send(http.MethodPost, "/users/user/redemptions", `{"rewardId": "mug"}`, "redeem-1")
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, before-5, balance())
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	now := time.Now()
+	store := newIdempotencyStore(time.Minute)
+	store.now = func() time.Time { return now }
+	hash := sha256.Sum256([]byte("body"))
+
+	original, err := store.begin("key", hash)
+	assert.NoError(t, err)
+	assert.Nil(t, original)
+
+	_, err = store.begin("key", hash)
+	assert.Equal(t, errIdempotencyKeyInFlight, err)
+
+	store.finish("key", http.StatusOK, "application/json", []byte("response"))
+	original, err = store.begin("key", hash)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("response"), original.body)
+
+	now = now.Add(2 * time.Minute)
+	original, err = store.begin("key", hash)
+	assert.NoError(t, err)
+	assert.Nil(t, original)
+
+	store.finish("key", http.StatusInternalServerError, "", nil)
+	original, err = store.begin("key", hash)
+	assert.NoError(t, err)
+	assert.Nil(t, original)
+
+	store.finish("key", http.StatusOK, "application/json", []byte("response"))
+	now = now.Add(2 * time.Minute)
+	_, err = store.begin("other", hash)
+	assert.NoError(t, err)
+	assert.NotContains(t, store.responses, "key")
+	assert.Empty(t, store.expiry)
+
+	router := gin.New()
+	router.POST("/", store.idempotent, func(c *gin.Context) { panic("handler failed") })
+	send := func() {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body"))
+		req.Header.Set("Idempotency-Key", "panic")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Panics(t, send)
+	assert.NotContains(t, store.responses, " POST / panic")
+	assert.Panics(t, send)
+
+	// Responses written as strings are replayed too, and the same key and
+	// body on another endpoint is a different request.
+	path := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.WriteString(c.FullPath())
+	}
+	router.POST("/a", store.idempotent, path)
+	router.POST("/b", store.idempotent, path)
+	for _, test := range []struct{ uri, replayed string }{{"/a", ""}, {"/a", "true"}, {"/b", ""}} {
+		req := httptest.NewRequest(http.MethodPost, test.uri, strings.NewReader("body"))
+		req.Header.Set("Idempotency-Key", "key")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		assert.Equal(t, test.uri, response.Body.String())
+		assert.Equal(t, test.replayed, response.Header().Get("Idempotent-Replayed"), test.uri)
+	}
+}
+
+func TestHandlerAdmin(t *testing.T) {
+	id := uuid.NewString()
+	risk := receipts.Risk{Score: 60, Signals: []receipts.RiskSignal{{Check: "rate", Score: 60, Reason: "12 receipts in the last hour"}}}
+	pending := receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id, Retailer: "Target", Status: receipts.StatusPending, Risk: risk}}
+	pendingResponse := toReceiptResponse(pending)
+	pendingResponse.Risk = &risk
+	approved := receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id, Retailer: "Target", Status: receipts.StatusApproved}, Points: receipts.Points{ID: id, Points: 6}}
+	audit := []receipts.AuditEntry{
+		{ReceiptID: id, To: receipts.StatusPending, Reason: "submitted", At: time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)},
+		{ReceiptID: id, From: receipts.StatusPending, To: receipts.StatusApproved, Reason: "ok", Actor: "admin", At: time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)},
+	}
+	earn := receipts.LedgerEntry{ID: "1", UserID: "user", ReceiptID: id, Type: receipts.LedgerEarn, Points: 6, Reason: "ok", Actor: "admin", At: time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)}
+	adjustment := receipts.LedgerEntry{ID: "2", UserID: "user", Type: receipts.LedgerAdjustment, Points: -2, Reason: "goodwill", Actor: "admin", At: time.Date(2024, 9, 16, 12, 0, 0, 0, time.UTC)}
+
+	tests := map[string]struct {
+		adminToken  string
+		header      string
+		mockService *mockReceiptService
+		method      string
+		uri         string
+		body        string
+		response    interface{}
+		statusCode  int
+		decision    receipts.ReceiptStatus
+	}{
+		"Admin API disabled": {
+			adminToken:  "",
+			header:      "",
+			mockService: &mockReceiptService{},
+			method:      http.MethodGet,
+			uri:         "/admin/reviews",
+			response:    problem(errors.Unauthorized, "A valid X-Admin-Token header is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"Wrong token": {
+			adminToken:  "secret",
+			header:      "guess",
+			mockService: &mockReceiptService{},
+			method:      http.MethodGet,
+			uri:         "/admin/reviews",
+			response:    problem(errors.Unauthorized, "A valid X-Admin-Token header is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"List pending": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{ListResult: receipts.ReceiptPage{Receipts: []receipts.StoredReceipt{pending}}},
+			method:      http.MethodGet,
+			uri:         "/admin/reviews",
+			response:    receipts.ListResponse{Receipts: []receipts.ReceiptResponse{pendingResponse}},
+			statusCode:  http.StatusOK,
+		},
+		"Approve": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{ReviewResult: approved},
+			method:      http.MethodPost,
+			uri:         fmt.Sprintf("/admin/reviews/%s/approve", id),
+			body:        `{"reason": "ok"}`,
+			response:    toReceiptResponse(approved),
+			statusCode:  http.StatusOK,
+			decision:    receipts.StatusApproved,
+		},
+		"Reject": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{ReviewResult: pending},
+			method:      http.MethodPost,
+			uri:         fmt.Sprintf("/admin/reviews/%s/reject", id),
+			body:        `{"reason": "forged"}`,
+			response:    pendingResponse,
+			statusCode:  http.StatusOK,
+			decision:    receipts.StatusRejected,
+		},
+		"Reason missing": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{},
+			method:      http.MethodPost,
+			uri:         fmt.Sprintf("/admin/reviews/%s/reject", id),
+			body:        `{}`,
+			response: func() errors.AppError {
+				p := problem(errors.ReviewInvalid, "1 invalid fields")
+				p.Errors = []errors.FieldError{{Path: "/reason", Code: errors.FieldRequired, Message: "reason is required"}}
+				return p
+			}(),
+			statusCode: http.StatusBadRequest,
+		},
+		"Not pending": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{ReviewError: fmt.Errorf("%w: receipt is approved", receipts.ErrReceiptStatusConflict)},
+			method:      http.MethodPost,
+			uri:         fmt.Sprintf("/admin/reviews/%s/approve", id),
+			body:        `{"reason": "ok"}`,
+			response:    problem(errors.ReceiptStatusConflict, "The receipt status does not allow this change: receipt is approved"),
+			statusCode:  http.StatusConflict,
+			decision:    receipts.StatusApproved,
+		},
+		"Audit trail": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{AuditResult: audit},
+			method:      http.MethodGet,
+			uri:         fmt.Sprintf("/admin/receipts/%s/audit", id),
+			response:    receipts.AuditResponse{Entries: audit},
+			statusCode:  http.StatusOK,
+		},
+		"User ledger": {
+			adminToken: "secret",
+			header:     "secret",
+			mockService: &mockReceiptService{LedgerResult: receipts.UserLedger{
+				User:    receipts.User{ID: "user", Balance: 4},
+				Entries: []receipts.LedgerEntry{earn, adjustment},
+			}},
+			method:     http.MethodGet,
+			uri:        "/admin/users/user/ledger",
+			response:   receipts.LedgerResponse{UserID: "user", Balance: 4, Entries: []receipts.LedgerEntry{earn, adjustment}},
+			statusCode: http.StatusOK,
+		},
+		"Adjust": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{AdjustResult: adjustment},
+			method:      http.MethodPost,
+			uri:         "/admin/users/user/adjustments",
+			body:        `{"points": -2, "reason": "goodwill"}`,
+			response:    adjustment,
+			statusCode:  http.StatusOK,
+		},
+		"Adjustment without points": {
+			adminToken:  "secret",
+			header:      "secret",
+			mockService: &mockReceiptService{},
+			method:      http.MethodPost,
+			uri:         "/admin/users/user/adjustments",
+			body:        `{"points": 0, "reason": "goodwill"}`,
+			response: func() errors.AppError {
+				p := problem(errors.AdjustmentInvalid, "1 invalid fields")
+				p.Errors = []errors.FieldError{{Path: "/points", Code: errors.FieldRequired, Message: "points is required"}}
+				return p
+			}(),
+			statusCode: http.StatusBadRequest,
+		},
+		"Check ledger": {
+			adminToken: "secret",
+			header:     "secret",
+			mockService: &mockReceiptService{CheckResult: receipts.LedgerReport{
+				Entries:       2,
+				Total:         4,
+				Discrepancies: []receipts.LedgerDiscrepancy{{ReceiptID: id, Expected: 6, Posted: 0}},
+			}},
+			method: http.MethodGet,
+			uri:    "/admin/ledger/check",
+			response: receipts.LedgerReport{
+				Entries:       2,
+				Total:         4,
+				Discrepancies: []receipts.LedgerDiscrepancy{{ReceiptID: id, Expected: 6, Posted: 0}},
+			},
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAdminToken(test.adminToken))
+
+			req, err := http.NewRequest(test.method, test.uri, strings.NewReader(test.body))
+			assert.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Admin-Token", test.header)
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			assert.Equal(t, test.decision, test.mockService.ReviewDecision)
+			if test.uri == "/admin/reviews" && test.statusCode == http.StatusOK {
+				assert.Equal(t, receipts.StatusPending, test.mockService.ListQuery.Status)
+			}
+			if test.statusCode == http.StatusOK {
+				body := reflect.New(reflect.TypeOf(test.response))
+				if err := json.Unmarshal(response.Body.Bytes(), body.Interface()); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, body.Elem().Interface())
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerDelete(t *testing.T) {
+	id := uuid.NewString()
+	owned := receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id, UserID: "user-1"}}
+	tests := map[string]struct {
+		mockService *mockReceiptService
+		uri         string
+		header      string
+		token       string
+		response    interface{}
+		statusCode  int
+		actor       string
+	}{
+		"Void": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s?reason=mistake", id),
+			token:       signToken("secret", "user-1"),
+			statusCode:  http.StatusNoContent,
+			actor:       "user-1",
+		},
+		"Void twice": {
+			mockService: &mockReceiptService{GetReceiptError: receipts.ErrReceiptGone},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-1"),
+			response:    problem(errors.ReceiptGone, "The receipt was voided or deleted"),
+			statusCode:  http.StatusGone,
+		},
+		"Void rejected receipt": {
+			mockService: &mockReceiptService{GetReceiptResult: owned, VoidError: receipts.ErrReceiptStatusConflict},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-1"),
+			response:    problem(errors.ReceiptStatusConflict, "The receipt status does not allow this change"),
+			statusCode:  http.StatusConflict,
+		},
+		"Void unknown receipt": {
+			mockService: &mockReceiptService{GetReceiptError: receipts.ErrReceiptNotFound},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-1"),
+			response:    problem(errors.ReceiptNotFound, "No receipt found for that id"),
+			statusCode:  http.StatusNotFound,
+		},
+		"Void receipt of another user": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-2"),
+			response:    problem(errors.Forbidden, "The receipt belongs to another user"),
+			statusCode:  http.StatusForbidden,
+		},
+		"Void anonymous receipt": {
+			mockService: &mockReceiptService{GetReceiptResult: receipts.StoredReceipt{Receipt: receipts.Receipt{ID: id}}},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			token:       signToken("secret", "user-1"),
+			response:    problem(errors.Forbidden, "The receipt belongs to another user"),
+			statusCode:  http.StatusForbidden,
+		},
+		"Void without token": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			response:    problem(errors.Unauthorized, "A bearer token is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"Void as admin": {
+			mockService: &mockReceiptService{},
+			uri:         fmt.Sprintf("/receipts/%s?reason=fraud", id),
+			header:      "secret",
+			statusCode:  http.StatusNoContent,
+			actor:       adminActor,
+		},
+		"Void unknown receipt as admin": {
+			mockService: &mockReceiptService{VoidError: receipts.ErrReceiptNotFound},
+			uri:         fmt.Sprintf("/receipts/%s", id),
+			header:      "secret",
+			response:    problem(errors.ReceiptNotFound, "No receipt found for that id"),
+			statusCode:  http.StatusNotFound,
+		},
+		"Hard delete": {
+			mockService: &mockReceiptService{},
+			uri:         fmt.Sprintf("/receipts/%s?hard=true&reason=fraud", id),
+			header:      "secret",
+			statusCode:  http.StatusNoContent,
+		},
+		"Hard delete without admin token": {
+			mockService: &mockReceiptService{GetReceiptResult: owned},
+			uri:         fmt.Sprintf("/receipts/%s?hard=true", id),
+			token:       signToken("secret", "user-1"),
+			response:    problem(errors.Unauthorized, "A valid X-Admin-Token header is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAdminToken("secret"), WithAuthSecret("secret"))
+
+			req, err := http.NewRequest(http.MethodDelete, test.uri, nil)
+			assert.NoError(t, err)
+			if test.header != "" {
+				req.Header.Set("X-Admin-Token", test.header)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			if test.statusCode == http.StatusNoContent {
+				assert.Empty(t, response.Body.String())
+				assert.Equal(t, id, test.mockService.DeletedID)
+				assert.Equal(t, test.actor, test.mockService.VoidActor)
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+				if test.statusCode != http.StatusConflict && test.header == "" {
+					assert.Empty(t, test.mockService.DeletedID)
+				}
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	token := signToken("secret", "user-1")
+
+	userID, ok := verifyToken("secret", token)
+	assert.True(t, ok)
+	assert.Equal(t, "user-1", userID)
+
+	for _, invalid := range []string{
+		signToken("other", "user-1"),
+		"user-2" + token[len("user-1"):],
+		"user-1",
+		"",
+	} {
+		_, ok := verifyToken("secret", invalid)
+		assert.False(t, ok, invalid)
+	}
+	_, ok = verifyToken("", signToken("", "user-1"))
+	assert.False(t, ok)
+}
+
+func TestHandlerUserPoints(t *testing.T) {
+	purchaseDate, _ := time.Parse("2006-01-02", "2022-01-01")
+	recent := receipts.StoredReceipt{
+		Receipt: receipts.Receipt{ID: uuid.NewString(), UserID: "user-1", Retailer: "Target", PurchaseDate: purchaseDate, Status: receipts.StatusApproved},
+		Points:  receipts.Points{Points: 28},
+	}
+	tests := map[string]struct {
+		mockService *mockReceiptService
+		uri         string
+		token       string
+		response    interface{}
+		statusCode  int
+	}{
+		"Own points": {
+			mockService: &mockReceiptService{UserPointsResult: receipts.UserPoints{
+				User:   receipts.User{ID: "user-1", Balance: 28},
+				Recent: []receipts.StoredReceipt{recent},
+			}},
+			uri:   "/users/user-1/points",
+			token: signToken("secret", "user-1"),
+			response: receipts.UserPointsResponse{
+				UserID:         "user-1",
+				Balance:        28,
+				RecentReceipts: []receipts.ReceiptResponse{toReceiptResponse(recent)},
+			},
+			statusCode: http.StatusOK,
+		},
+		"Anonymous": {
+			mockService: &mockReceiptService{},
+			uri:         "/users/user-1/points",
+			response:    problem(errors.Unauthorized, "A bearer token is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"Invalid token": {
+			mockService: &mockReceiptService{},
+			uri:         "/users/user-1/points",
+			token:       signToken("guess", "user-1"),
+			response:    problem(errors.Unauthorized, "The bearer token is invalid"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"Another user": {
+			mockService: &mockReceiptService{},
+			uri:         "/users/user-2/points",
+			token:       signToken("secret", "user-1"),
+			response:    problem(errors.Forbidden, "The caller may only access their own account"),
+			statusCode:  http.StatusForbidden,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAuthSecret("secret"))
+
+			req, err := http.NewRequest(http.MethodGet, test.uri, nil)
+			assert.NoError(t, err)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			if test.statusCode == http.StatusOK {
+				var u receipts.UserPointsResponse
+				if err := json.Unmarshal(response.Body.Bytes(), &u); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, u)
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerCreateAuthenticated(t *testing.T) {
+	mockService := &mockReceiptService{CreateResult: receipts.Receipt{ID: uuid.NewString()}}
+	router := gin.New()
+	Activate(router, mockService, WithAuthSecret("secret"))
+	body := `{"retailer": "Target","purchaseDate": "2022-01-01","purchaseTime": "13:01","total": "1.25",` +
+		`"items": [{"shortDescription": "Pepsi", "price": "1.25"}]}`
+
+	req, err := http.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+signToken("secret", "user-1"))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "user-1", mockService.CreateReceipt.UserID)
+}
+
+func TestHandlerIssueToken(t *testing.T) {
+	router := gin.New()
+	Activate(router, &mockReceiptService{}, WithAdminToken("admin"), WithAuthSecret("secret"))
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/users/user-1/token", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Admin-Token", "admin")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	var token receipts.TokenResponse
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &token))
+	userID, ok := verifyToken("secret", token.Token)
+	assert.True(t, ok)
+	assert.Equal(t, "user-1", userID)
+
+	req, err = http.NewRequest(http.MethodPost, "/admin/users/not%20valid/token", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Admin-Token", "admin")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, problem(errors.UserInvalid, "user ids are 1 to 64 letters, digits, '-' or '_'"), readProblem(t, response, req))
+}
+
+func TestHandlerUserAccounts(t *testing.T) {
+	createdAt := time.Date(2024, 9, 14, 12, 0, 0, 0, time.UTC)
+	redemption := receipts.Redemption{ID: "1", UserID: "user", RewardID: "mug", Cost: 8, Status: receipts.RedemptionCompleted, CreatedAt: createdAt}
+	cancelled := redemption
+	cancelled.Status = receipts.RedemptionCancelled
+	cancelled.CancelledAt = &createdAt
+	mug := receipts.Reward{ID: "mug", Name: "Mug", Cost: 8, Stock: 3}
+	expiration := receipts.Expiration{Points: 10, ExpiresAt: time.Date(2025, 9, 14, 0, 0, 0, 0, time.UTC)}
+	expiry := receipts.LedgerEntry{ID: "2", UserID: "user", Type: receipts.LedgerExpiry, Points: -4, Reason: "points expired", At: createdAt}
+
+	tests := map[string]struct {
+		mockService *mockReceiptService
+		method      string
+		uri         string
+		body        string
+		token       string
+		admin       bool
+		response    interface{}
+		statusCode  int
+	}{
+		"List rewards": {
+			mockService: &mockReceiptService{RewardsResult: []receipts.Reward{mug}},
+			method:      http.MethodGet,
+			uri:         "/rewards",
+			response:    receipts.RewardsResponse{Rewards: []receipts.Reward{mug}},
+			statusCode:  http.StatusOK,
+		},
+		"Save reward": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/rewards/mug",
+			body:        `{"name": "Mug", "cost": 8, "stock": 3}`,
+			admin:       true,
+			response:    mug,
+			statusCode:  http.StatusOK,
+		},
+		"Save reward without admin token": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/rewards/mug",
+			body:        `{"name": "Mug", "cost": 8, "stock": 3}`,
+			response:    problem(errors.Unauthorized, "A valid X-Admin-Token header is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+		"Invalid reward": {
+			mockService: &mockReceiptService{SaveRewardError: fmt.Errorf("%w: cost must be more than 0", receipts.ErrRewardInvalid)},
+			method:      http.MethodPut,
+			uri:         "/admin/rewards/mug",
+			body:        `{"name": "Mug", "stock": 3}`,
+			admin:       true,
+			response:    problem(errors.RewardInvalid, "The reward is invalid: cost must be more than 0"),
+			statusCode:  http.StatusBadRequest,
+		},
+		"Redeem": {
+			mockService: &mockReceiptService{RedeemResult: redemption},
+			method:      http.MethodPost,
+			uri:         "/users/user/redemptions",
+			body:        `{"rewardId": "mug"}`,
+			token:       signToken("secret", "user"),
+			response:    redemption,
+			statusCode:  http.StatusOK,
+		},
+		"Insufficient points": {
+			mockService: &mockReceiptService{RedeemError: fmt.Errorf("%w: the reward costs 8 points but the balance is 2", receipts.ErrInsufficientPoints)},
+			method:      http.MethodPost,
+			uri:         "/users/user/redemptions",
+			body:        `{"rewardId": "mug"}`,
+			token:       signToken("secret", "user"),
+			response:    problem(errors.PointsInsufficient, "The balance is too low for this reward: the reward costs 8 points but the balance is 2"),
+			statusCode:  http.StatusUnprocessableEntity,
+		},
+		"Out of stock": {
+			mockService: &mockReceiptService{RedeemError: receipts.ErrRewardOutOfStock},
+			method:      http.MethodPost,
+			uri:         "/users/user/redemptions",
+			body:        `{"rewardId": "mug"}`,
+			token:       signToken("secret", "user"),
+			response:    problem(errors.RewardOutOfStock, "The reward is out of stock"),
+			statusCode:  http.StatusConflict,
+		},
+		"Redeem for another user": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPost,
+			uri:         "/users/other/redemptions",
+			body:        `{"rewardId": "mug"}`,
+			token:       signToken("secret", "user"),
+			response:    problem(errors.Forbidden, "The caller may only access their own account"),
+			statusCode:  http.StatusForbidden,
+		},
+		"List redemptions": {
+			mockService: &mockReceiptService{RedemptionsResult: []receipts.Redemption{redemption}},
+			method:      http.MethodGet,
+			uri:         "/users/user/redemptions",
+			token:       signToken("secret", "user"),
+			response:    receipts.RedemptionsResponse{Redemptions: []receipts.Redemption{redemption}},
+			statusCode:  http.StatusOK,
+		},
+		"Cancel": {
+			mockService: &mockReceiptService{CancelResult: cancelled},
+			method:      http.MethodPost,
+			uri:         "/users/user/redemptions/1/cancel",
+			token:       signToken("secret", "user"),
+			response:    cancelled,
+			statusCode:  http.StatusOK,
+		},
+		"Upcoming expirations": {
+			mockService: &mockReceiptService{ExpirationsResult: []receipts.Expiration{expiration}},
+			method:      http.MethodGet,
+			uri:         "/users/user/expirations",
+			token:       signToken("secret", "user"),
+			response:    receipts.ExpirationsResponse{UserID: "user", Expirations: []receipts.Expiration{expiration}},
+			statusCode:  http.StatusOK,
+		},
+		"Upcoming expirations of another user": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodGet,
+			uri:         "/users/other/expirations",
+			token:       signToken("secret", "user"),
+			response:    problem(errors.Forbidden, "The caller may only access their own account"),
+			statusCode:  http.StatusForbidden,
+		},
+		"Run expiry": {
+			mockService: &mockReceiptService{ExpireResult: []receipts.LedgerEntry{expiry}},
+			method:      http.MethodPost,
+			uri:         "/admin/expirations/run",
+			admin:       true,
+			response:    receipts.ExpiryRunResponse{Entries: []receipts.LedgerEntry{expiry}},
+			statusCode:  http.StatusOK,
+		},
+		"Recalculate tiers": {
+			mockService: &mockReceiptService{TierChanges: 2},
+			method:      http.MethodPost,
+			uri:         "/admin/tiers/recalculate",
+			admin:       true,
+			response:    receipts.TierRunResponse{Changed: 2},
+			statusCode:  http.StatusOK,
+		},
+		"Cancel twice": {
+			mockService: &mockReceiptService{CancelError: receipts.ErrRedemptionCancelled},
+			method:      http.MethodPost,
+			uri:         "/users/user/redemptions/1/cancel",
+			token:       signToken("secret", "user"),
+			response:    problem(errors.RedemptionCancelled, "The redemption was already cancelled"),
+			statusCode:  http.StatusConflict,
+		},
+		"Cancel after the window": {
+			mockService: &mockReceiptService{CancelError: receipts.ErrRedemptionFinal},
+			method:      http.MethodPost,
+			uri:         "/users/user/redemptions/1/cancel",
+			token:       signToken("secret", "user"),
+			response:    problem(errors.RedemptionFinal, "The redemption can no longer be cancelled"),
+			statusCode:  http.StatusConflict,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAdminToken("admin"), WithAuthSecret("secret"))
+
+			req, err := http.NewRequest(test.method, test.uri, strings.NewReader(test.body))
+			assert.NoError(t, err)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			if test.admin {
+				req.Header.Set("X-Admin-Token", "admin")
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			if test.statusCode == http.StatusOK {
+				body := reflect.New(reflect.TypeOf(test.response))
+				if err := json.Unmarshal(response.Body.Bytes(), body.Interface()); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, body.Elem().Interface())
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandlerCampaigns(t *testing.T) {
+	campaign := receipts.Campaign{
+		ID:       "gatorade",
+		Name:     "Gatorade March",
+		Item:     "gatorade",
+		StartsAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		Bonus:    200,
+		UserCap:  600,
+	}
+	summary := receipts.CampaignSummary{Campaign: campaign, Spent: 400}
+	tests := map[string]struct {
+		mockService *mockReceiptService
+		method      string
+		uri         string
+		body        string
+		admin       bool
+		response    interface{}
+		statusCode  int
+		saved       receipts.Campaign
+		deleted     string
+	}{
+		"List campaigns": {
+			mockService: &mockReceiptService{CampaignsResult: []receipts.CampaignSummary{summary}},
+			method:      http.MethodGet,
+			uri:         "/admin/campaigns",
+			admin:       true,
+			response:    receipts.CampaignsResponse{Campaigns: []receipts.CampaignSummary{summary}},
+			statusCode:  http.StatusOK,
+		},
+		"No campaigns": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodGet,
+			uri:         "/admin/campaigns",
+			admin:       true,
+			response:    receipts.CampaignsResponse{Campaigns: []receipts.CampaignSummary{}},
+			statusCode:  http.StatusOK,
+		},
+		"Get campaign": {
+			mockService: &mockReceiptService{CampaignsResult: []receipts.CampaignSummary{summary}},
+			method:      http.MethodGet,
+			uri:         "/admin/campaigns/gatorade",
+			admin:       true,
+			response:    summary,
+			statusCode:  http.StatusOK,
+		},
+		"Campaign not found": {
+			mockService: &mockReceiptService{CampaignError: receipts.ErrCampaignNotFound},
+			method:      http.MethodGet,
+			uri:         "/admin/campaigns/missing",
+			admin:       true,
+			response:    problem(errors.CampaignNotFound, "No campaign found for that id"),
+			statusCode:  http.StatusNotFound,
+		},
+		"Save campaign": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/campaigns/gatorade",
+			body:        `{"name": "Gatorade March", "item": "gatorade", "startsAt": "2024-03-01T00:00:00Z", "endsAt": "2024-04-01T00:00:00Z", "bonus": 200, "userCap": 600}`,
+			admin:       true,
+			response:    campaign,
+			statusCode:  http.StatusOK,
+			saved:       campaign,
+		},
+		"Missing window": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/campaigns/gatorade",
+			body:        `{"name": "Gatorade March", "bonus": 200}`,
+			admin:       true,
+			response: func() errors.AppError {
+				p := problem(errors.CampaignInvalid, "2 invalid fields")
+				p.Errors = []errors.FieldError{
+					{Path: "/startsAt", Code: errors.FieldRequired, Message: "startsAt is required"},
+					{Path: "/endsAt", Code: errors.FieldRequired, Message: "endsAt is required"},
+				}
+				return p
+			}(),
+			statusCode: http.StatusBadRequest,
+		},
+		"Invalid campaign": {
+			mockService: &mockReceiptService{SaveCampaignError: fmt.Errorf("%w: endsAt must be after startsAt", receipts.ErrCampaignInvalid)},
+			method:      http.MethodPut,
+			uri:         "/admin/campaigns/gatorade",
+			body:        `{"name": "Gatorade March", "startsAt": "2024-04-01T00:00:00Z", "endsAt": "2024-03-01T00:00:00Z", "bonus": 200}`,
+			admin:       true,
+			response:    problem(errors.CampaignInvalid, "The campaign is invalid: endsAt must be after startsAt"),
+			statusCode:  http.StatusBadRequest,
+			saved:       receipts.Campaign{ID: "gatorade", Name: "Gatorade March", StartsAt: campaign.EndsAt, EndsAt: campaign.StartsAt, Bonus: 200},
+		},
+		"Invalid id": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/campaigns/bad%20id",
+			body:        `{}`,
+			admin:       true,
+			response:    problem(errors.CampaignInvalid, "campaign ids are 1 to 64 letters, digits, '-' or '_'"),
+			statusCode:  http.StatusBadRequest,
+		},
+		"Delete campaign": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodDelete,
+			uri:         "/admin/campaigns/gatorade",
+			admin:       true,
+			statusCode:  http.StatusNoContent,
+			deleted:     "gatorade",
+		},
+		"Not an admin": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodGet,
+			uri:         "/admin/campaigns",
+			response:    problem(errors.Unauthorized, "A valid X-Admin-Token header is required"),
+			statusCode:  http.StatusUnauthorized,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAdminToken("admin"))
+
+			req, err := http.NewRequest(test.method, test.uri, strings.NewReader(test.body))
+			assert.NoError(t, err)
+			if test.admin {
+				req.Header.Set("X-Admin-Token", "admin")
+			}
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			assert.Equal(t, test.saved, test.mockService.SavedCampaign)
+			assert.Equal(t, test.deleted, test.mockService.DeletedCampaign)
+			switch {
+			case test.statusCode == http.StatusNoContent:
+				assert.Empty(t, response.Body.String())
+			case test.statusCode == http.StatusOK:
+				body := reflect.New(reflect.TypeOf(test.response))
+				if err := json.Unmarshal(response.Body.Bytes(), body.Interface()); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, body.Elem().Interface())
+			default:
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		code   errors.Code
+		status int
+	}{
+		"Not found":          {err: receipts.ErrReceiptNotFound, code: errors.ReceiptNotFound, status: http.StatusNotFound},
+		"Wrapped not found":  {err: fmt.Errorf("lookup: %w", receipts.ErrReceiptNotFound), code: errors.ReceiptNotFound, status: http.StatusNotFound},
+		"Wrapped duplicate":  {err: fmt.Errorf("create: %w", receipts.ErrReceiptDuplicate), code: errors.ReceiptDuplicate, status: http.StatusConflict},
+		"Validation error":   {err: &receipts.ValidationError{}, code: errors.ReceiptInvalid, status: http.StatusBadRequest},
+		"Wrapped validation": {err: fmt.Errorf("map: %w", &receipts.ValidationError{}), code: errors.ReceiptInvalid, status: http.StatusBadRequest},
+		"App error":          {err: errors.NewAppError(errors.QueryInvalid, "detail"), code: errors.QueryInvalid, status: http.StatusBadRequest},
+		"Job not running":    {err: fmt.Errorf("finish: %w", receipts.ErrJobStatusConflict), code: errors.JobStatusConflict, status: http.StatusConflict},
+		"Unknown error":      {err: fmt.Errorf("disk full"), code: errors.Internal, status: http.StatusInternalServerError},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			problem := handleError(test.err)
+
+			assert.Equal(t, test.code, problem.Code)
+			assert.Equal(t, test.status, problem.Status)
+		})
+	}
+
+	t.Run("Internal errors do not leak details", func(t *testing.T) {
+		assert.Equal(t, "", handleError(fmt.Errorf("disk full")).Detail)
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	router := gin.New()
+	Activate(router, &mockReceiptService{GetPointsError: receipts.ErrReceiptNotFound})
+
+	response := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/receipts/id/points", nil)
+	req.Header.Set("X-Request-ID", "request-1")
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, "request-1", response.Header().Get("X-Request-ID"))
+	var p errors.AppError
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &p))
+	assert.Equal(t, "request-1", p.RequestID)
+	assert.Equal(t, "/receipts/id/points", p.Instance)
+}
+
+func TestHandlerRecompute(t *testing.T) {
+	rules := receipts.RuleSet{Version: "v2", Rules: []receipts.Rule{
+		{Name: "retailer_name", Type: receipts.RuleAlphanumericCount, Points: 2},
+	}}
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	rc := receipts.Recomputation{
+		ID:       "9a3e2a52-4a3b-4b34-a51b-2f1b5b1e4b0e",
+		Request:  receipts.RecomputeRequest{Version: "v2", PurchasedFrom: &from},
+		Status:   receipts.RecomputationPreview,
+		Receipts: 1,
+		Changed:  1,
+		Delta:    6,
+		Diffs: []receipts.RecomputeDiff{{
+			ReceiptID: "7fb1377b-b223-49d9-a31a-5a02701dd310",
+			Status:    receipts.StatusApproved,
+			Before:    receipts.Points{ID: "7fb1377b-b223-49d9-a31a-5a02701dd310", Points: 6, RuleVersion: "v1"},
+			After:     receipts.Points{ID: "7fb1377b-b223-49d9-a31a-5a02701dd310", Points: 12, RuleVersion: "v2"},
+			Delta:     6,
+		}},
+		CreatedAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := map[string]struct {
+		mockService *mockReceiptService
+		method      string
+		uri         string
+		body        string
+		response    interface{}
+		statusCode  int
+		saved       receipts.RuleSet
+		request     receipts.RecomputeRequest
+		actor       string
+	}{
+		"List rule sets": {
+			mockService: &mockReceiptService{RuleSetsResult: []receipts.RuleSet{rules}},
+			method:      http.MethodGet,
+			uri:         "/admin/rulesets",
+			response:    receipts.RuleSetsResponse{RuleSets: []receipts.RuleSet{rules}},
+			statusCode:  http.StatusOK,
+		},
+		"Save rule set": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/rulesets/v2",
+			body:        `{"rules": [{"name": "retailer_name", "type": "alphanumeric_count", "points": 2}]}`,
+			response:    rules,
+			statusCode:  http.StatusOK,
+			saved:       rules,
+		},
+		"Rule set conflict": {
+			mockService: &mockReceiptService{RuleSetError: fmt.Errorf("%w: v2", receipts.ErrRuleSetConflict)},
+			method:      http.MethodPut,
+			uri:         "/admin/rulesets/v2",
+			body:        `{"rules": [{"name": "retailer_name", "type": "alphanumeric_count", "points": 2}]}`,
+			response:    problem(errors.RuleSetConflict, "The rule set version already has different rules: v2"),
+			statusCode:  http.StatusConflict,
+			saved:       rules,
+		},
+		"Missing rules": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/rulesets/v2",
+			body:        `{}`,
+			response: func() errors.AppError {
+				p := problem(errors.RuleSetInvalid, "1 invalid fields")
+				p.Errors = []errors.FieldError{{Path: "/rules", Code: errors.FieldRequired, Message: "rules is required"}}
+				return p
+			}(),
+			statusCode: http.StatusBadRequest,
+		},
+		"Invalid version": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPut,
+			uri:         "/admin/rulesets/v%202",
+			body:        `{}`,
+			response:    problem(errors.RuleSetInvalid, "versions are 1 to 64 letters, digits, '-' or '_'"),
+			statusCode:  http.StatusBadRequest,
+		},
+		"Preview recomputation": {
+			mockService: &mockReceiptService{RecomputationResult: rc},
+			method:      http.MethodPost,
+			uri:         "/admin/recomputations",
+			body:        `{"version": "v2", "purchaseDateFrom": "2024-03-01"}`,
+			response:    rc,
+			statusCode:  http.StatusCreated,
+			request:     rc.Request,
+		},
+		"Invalid purchase date": {
+			mockService: &mockReceiptService{},
+			method:      http.MethodPost,
+			uri:         "/admin/recomputations",
+			body:        `{"version": "v2", "purchaseDateTo": "03/01/2024"}`,
+			response:    problem(errors.RecomputationInvalid, "The recomputation is invalid: purchaseDateTo must be YYYY-MM-DD"),
+			statusCode:  http.StatusBadRequest,
+		},
+		"Unknown rule set": {
+			mockService: &mockReceiptService{RecomputationError: receipts.ErrRuleSetNotFound},
+			method:      http.MethodPost,
+			uri:         "/admin/recomputations",
+			body:        `{"version": "v3"}`,
+			response:    problem(errors.RuleSetNotFound, "No rule set found for that version"),
+			statusCode:  http.StatusNotFound,
+			request:     receipts.RecomputeRequest{Version: "v3"},
+		},
+		"Get recomputation": {
+			mockService: &mockReceiptService{RecomputationResult: rc},
+			method:      http.MethodGet,
+			uri:         "/admin/recomputations/" + rc.ID,
+			response:    rc,
+			statusCode:  http.StatusOK,
+		},
+		"Recomputation not found": {
+			mockService: &mockReceiptService{RecomputationError: receipts.ErrRecomputationNotFound},
+			method:      http.MethodGet,
+			uri:         "/admin/recomputations/missing",
+			response:    problem(errors.RecomputationNotFound, "No recomputation found for that id"),
+			statusCode:  http.StatusNotFound,
+		},
+		"Commit recomputation": {
+			mockService: &mockReceiptService{RecomputationResult: rc},
+			method:      http.MethodPost,
+			uri:         "/admin/recomputations/" + rc.ID + "/commit",
+			response:    rc,
+			statusCode:  http.StatusOK,
+			actor:       adminActor,
+		},
+		"Stale recomputation": {
+			mockService: &mockReceiptService{RecomputationError: fmt.Errorf("%w: receipt %s", receipts.ErrRecomputationStale, rc.Diffs[0].ReceiptID)},
+			method:      http.MethodPost,
+			uri:         "/admin/recomputations/" + rc.ID + "/commit",
+			response:    problem(errors.RecomputationStale, "The receipts changed since the recomputation was previewed: receipt "+rc.Diffs[0].ReceiptID),
+			statusCode:  http.StatusConflict,
+			actor:       adminActor,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			router := gin.New()
+			Activate(router, test.mockService, WithAdminToken("admin"))
+
+			req, err := http.NewRequest(test.method, test.uri, strings.NewReader(test.body))
+			assert.NoError(t, err)
+			req.Header.Set("X-Admin-Token", "admin")
+
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, test.statusCode, response.Code)
+			assert.Equal(t, test.saved, test.mockService.SavedRuleSet)
+			assert.Equal(t, test.request, test.mockService.RecomputeRequest)
+			assert.Equal(t, test.actor, test.mockService.CommittedActor)
+			if test.statusCode == http.StatusOK || test.statusCode == http.StatusCreated {
+				body := reflect.New(reflect.TypeOf(test.response))
+				if err := json.Unmarshal(response.Body.Bytes(), body.Interface()); err != nil {
+					assert.Fail(t, "failed to unmarshal", response.Body.String(), err)
+				}
+				assert.Equal(t, test.response, body.Elem().Interface())
+			} else {
+				assert.Equal(t, test.response, readProblem(t, response, req))
+			}
+		})
+	}
+}
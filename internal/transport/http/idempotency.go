@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyWindow is how long a response is replayed for the same Idempotency-Key.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// maxIdempotentBodySize is the largest request body read to hash an Idempotency-Key request.
+const maxIdempotentBodySize = 1 << 20
+
+var (
+	errIdempotencyKeyReused   = errors.New("The Idempotency-Key was already used for a different request")
+	errIdempotencyKeyInFlight = errors.New("A request with this Idempotency-Key is still being processed")
+	errRequestTooLarge        = errors.New("A request with an Idempotency-Key may have at most 1 MiB of body")
+)
+
+// idempotentResponse is a request seen under an Idempotency-Key and, once
+// it completed, the response that is replayed for retries.
+type idempotentResponse struct {
+	key         string
+	bodyHash    [sha256.Size]byte
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+}
+
+// expiryQueue orders completed responses by expiry, soonest first, for container/heap.
+type expiryQueue []*idempotentResponse
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(*idempotentResponse)) }
+func (q *expiryQueue) Pop() any {
+	old := *q
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return r
+}
+
+// idempotencyStore remembers responses by Idempotency-Key for a fixed window.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	window    time.Duration
+	responses map[string]*idempotentResponse
+	expiry    expiryQueue
+	now       func() time.Time
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:    window,
+		responses: make(map[string]*idempotentResponse),
+		now:       time.Now,
+	}
+}
+
+// begin reserves key for a request with the given body hash. It returns the
+// completed response if the request is a retry, or an error if the key is
+// being processed or was used for a different body.
+func (s *idempotencyStore) begin(key string, bodyHash [sha256.Size]byte) (*idempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	if r, ok := s.responses[key]; ok {
+		if r.bodyHash != bodyHash {
+			return nil, errIdempotencyKeyReused
+		}
+		if !r.done {
+			return nil, errIdempotencyKeyInFlight
+		}
+		return r, nil
+	}
+
+	s.responses[key] = &idempotentResponse{key: key, bodyHash: bodyHash}
+	return nil, nil
+}
+
+// expire forgets the completed responses whose window has passed, callers must hold s.mu.
+func (s *idempotencyStore) expire() {
+	now := s.now()
+	for len(s.expiry) > 0 && now.After(s.expiry[0].expiresAt) {
+		r := heap.Pop(&s.expiry).(*idempotentResponse)
+		if s.responses[r.key] == r {
+			delete(s.responses, r.key)
+		}
+	}
+}
+
+// release forgets key so the request can be sent again.
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.responses, key)
+}
+
+// finish stores the response for key. Server errors are not stored so the client can retry them.
+func (s *idempotencyStore) finish(key string, status int, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status >= http.StatusInternalServerError {
+		delete(s.responses, key)
+		return
+	}
+	r := s.responses[key]
+	r.done = true
+	r.status = status
+	r.contentType = contentType
+	r.body = body
+	r.expiresAt = s.now().Add(s.window)
+	heap.Push(&s.expiry, r)
+}
+
+// recordingWriter keeps a copy of everything written to the response.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent replays the original response when a request is retried with
+// the same Idempotency-Key header. Requests without the header are not affected.
+func (s *idempotencyStore) idempotent(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	// Keys are chosen by clients, so they are only unique per user and
+	// endpoint. Anonymous callers share one namespace and must send keys
+	// that cannot be guessed, such as UUIDs.
+	key = c.GetString(userIDKey) + " " + c.Request.Method + " " + c.FullPath() + " " + key
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abortWithError(c, errRequestTooLarge)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	original, err := s.begin(key, sha256.Sum256(body))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if original != nil {
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(original.status, original.contentType, original.body)
+		c.Abort()
+		return
+	}
+
+	// The key is released if a handler panics, so retries are not refused as in flight forever.
+	finished := false
+	defer func() {
+		if !finished {
+			s.release(key)
+		}
+	}()
+
+	writer := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	s.finish(key, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+	finished = true
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	stderrors "errors"
+	"fetch_take_home/internal/receipts"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// jobPollInterval is how often idle workers look for queued jobs, such as
+// those left from before a restart, without being woken for them.
+const jobPollInterval = time.Second
+
+// queueFullRetryAfter is the Retry-After, in seconds, of submissions turned
+// away while the job queue is full.
+const queueFullRetryAfter = "5"
+
+// jobPool processes queued jobs through the receipt service in the background.
+type jobPool struct {
+	workers int
+	service receipts.Service
+	// wake signals idle workers that a job was queued.
+	wake chan struct{}
+}
+
+func (p *jobPool) start(service receipts.Service) {
+	p.service = service
+	for i := 0; i < p.workers; i++ {
+		go p.work()
+	}
+}
+
+// notify wakes an idle worker, if there is one.
+func (p *jobPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *jobPool) work() {
+	for {
+		job, err := p.service.ClaimJob()
+		if err != nil {
+			if !stderrors.Is(err, receipts.ErrJobNotFound) {
+				log.WithError(err).Error("Failed to claim job")
+			}
+			select {
+			case <-p.wake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+		p.process(job)
+	}
+}
+
+// process stores the receipt of a job like Create and records the id it
+// was stored under, or the error response Create would have returned.
+// Storing the receipt finishes the job in the same write, so a job
+// interrupted by a restart is only run again if its receipt was not stored.
+func (p *jobPool) process(job receipts.Job) {
+	created, err := p.service.Create(job.Receipt)
+	if err != nil {
+		_, err = p.service.FinishJob(job.ID, "", handleError(err))
+	} else {
+		_, err = p.service.FinishJob(job.ID, created.ID, nil)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"ID": job.ID,
+		}).Error("Failed to finish job")
+	}
+}
+
+// prefersAsync reports whether the request has a Prefer header with the
+// respond-async preference, see RFC 7240.
+func prefersAsync(c *gin.Context) bool {
+	for _, header := range c.Request.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(preference, ";")
+			name, _, _ = strings.Cut(name, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue queues a validated receipt for the workers and responds with the
+// job, or turns it away while the queue is full.
+func (h *Handler) enqueue(c *gin.Context, receipt receipts.Receipt) {
+	job, err := h.ReceiptService.Enqueue(receipt)
+	if stderrors.Is(err, receipts.ErrJobQueueFull) {
+		c.Header("Retry-After", queueFullRetryAfter)
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	h.jobs.notify()
+
+	c.Header("Preference-Applied", "respond-async")
+	c.Header("Location", "/jobs/"+job.ID)
+	c.IndentedJSON(http.StatusAccepted, toJobResponse(job))
+}
+
+// GetJob returns the status of a receipt submitted for background processing.
+func (h *Handler) GetJob(c *gin.Context) {
+	job, err := h.ReceiptService.Job(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, toJobResponse(job))
+}
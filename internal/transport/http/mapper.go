@@ -1,63 +1,90 @@
 package http
 
 import (
+	stderrors "errors"
+	"fetch_take_home/errors"
 	"fetch_take_home/internal/receipts"
-	log "github.com/sirupsen/logrus"
+	"fmt"
 	"strconv"
 	"time"
 )
 
-func toItem(itemDTO receipts.ItemDTO) (receipts.Item, error) {
-	val, err := strconv.ParseFloat(itemDTO.Price, 64)
+func toItem(index int, itemDTO receipts.ItemDTO) (receipts.Item, []errors.FieldError) {
+	price, err := receipts.ParseMoney(itemDTO.Price)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"shortDescription": itemDTO.ShortDescription,
-			"price":            itemDTO.Price,
-		}).Error("Failed to parse item")
-		return receipts.Item{}, receipts.ErrReceiptInvalid
+		return receipts.Item{}, []errors.FieldError{moneyFieldError(fmt.Sprintf("/items/%d/price", index), err)}
 	}
-	cents := int64(val*100 + 0.5)
 
 	return receipts.Item{
 		ShortDescription: itemDTO.ShortDescription,
-		Price:            cents,
+		Price:            price,
 	}, nil
 }
 
+func moneyFieldError(path string, err error) errors.FieldError {
+	code := errors.FieldInvalidFormat
+	if stderrors.Is(err, receipts.ErrMoneyOverflow) {
+		code = errors.FieldTooLarge
+	}
+	return errors.FieldError{Path: path, Code: code, Message: err.Error()}
+}
+
+// toReceipt maps receiptDTO to a receipt. Every field is checked, so the
+// returned *receipts.ValidationError lists all problems instead of the first.
 func toReceipt(receiptDTO receipts.ReceiptDTO) (receipts.Receipt, error) {
+	var fieldErrors []errors.FieldError
+
 	var purchaseDate, purchaseDateError = time.Parse("2006-01-02", receiptDTO.PurchaseDate)
 	if purchaseDateError != nil {
-		log.WithFields(log.Fields{
-			"purchaseDate": receiptDTO.PurchaseDate,
-		}).Error("Failed to parse purchase date")
-		return receipts.Receipt{}, receipts.ErrReceiptInvalid
+		fieldErrors = append(fieldErrors, errors.FieldError{
+			Path:    "/purchaseDate",
+			Code:    errors.FieldInvalidFormat,
+			Message: "purchase date must be a date in YYYY-MM-DD format",
+		})
 	}
 
 	var purchaseTime, purchaseTimeError = time.Parse("15:04", receiptDTO.PurchaseTime)
 	if purchaseTimeError != nil {
-		log.WithFields(log.Fields{
-			"purchaseTime": receiptDTO.PurchaseTime,
-		}).Error("Failed to parse purchase time")
-		return receipts.Receipt{}, receipts.ErrReceiptInvalid
+		fieldErrors = append(fieldErrors, errors.FieldError{
+			Path:    "/purchaseTime",
+			Code:    errors.FieldInvalidFormat,
+			Message: "purchase time must be a 24-hour time in HH:MM format",
+		})
 	}
 
 	var newItems []receipts.Item
-	for _, itemDTO := range receiptDTO.Items {
-		item, itemErr := toItem(itemDTO)
-		if itemErr != nil {
-			return receipts.Receipt{}, itemErr
-		}
+	for i, itemDTO := range receiptDTO.Items {
+		item, itemErrors := toItem(i, itemDTO)
+		fieldErrors = append(fieldErrors, itemErrors...)
 		newItems = append(newItems, item)
 	}
 
-	val, err := strconv.ParseFloat(receiptDTO.Total, 64)
+	total, err := receipts.ParseMoney(receiptDTO.Total)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"total": receiptDTO.Total,
-		}).Error("Failed to parse total")
-		return receipts.Receipt{}, receipts.ErrReceiptInvalid
+		fieldErrors = append(fieldErrors, moneyFieldError("/total", err))
+	}
+
+	var tax, discount, tip receipts.Money
+	for _, line := range []struct {
+		path   string
+		text   string
+		amount *receipts.Money
+	}{
+		{"/tax", receiptDTO.Tax, &tax},
+		{"/discount", receiptDTO.Discount, &discount},
+		{"/tip", receiptDTO.Tip, &tip},
+	} {
+		if line.text == "" {
+			continue
+		}
+		if *line.amount, err = receipts.ParseMoney(line.text); err != nil {
+			fieldErrors = append(fieldErrors, moneyFieldError(line.path, err))
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return receipts.Receipt{}, &receipts.ValidationError{Fields: fieldErrors}
 	}
-	cents := int64(val*100 + 0.5)
 
 	return receipts.Receipt{
 		ID:           "",
@@ -65,6 +92,220 @@ func toReceipt(receiptDTO receipts.ReceiptDTO) (receipts.Receipt, error) {
 		PurchaseDate: purchaseDate,
 		PurchaseTime: purchaseTime,
 		Items:        newItems,
-		Total:        cents,
+		Total:        total,
+		Tax:          tax,
+		Discount:     discount,
+		Tip:          tip,
 	}, nil
 }
+
+func toItemDTO(item receipts.Item) receipts.ItemDTO {
+	return receipts.ItemDTO{
+		ShortDescription: item.ShortDescription,
+		Price:            item.Price.String(),
+	}
+}
+
+func toReceiptResponse(stored receipts.StoredReceipt) receipts.ReceiptResponse {
+	r := stored.Receipt
+	items := make([]receipts.ItemDTO, 0, len(r.Items))
+	for _, item := range r.Items {
+		items = append(items, toItemDTO(item))
+	}
+
+	response := receipts.ReceiptResponse{
+		ID:           r.ID,
+		UserID:       r.UserID,
+		Retailer:     r.Retailer,
+		PurchaseDate: r.PurchaseDate.Format("2006-01-02"),
+		PurchaseTime: r.PurchaseTime.Format("15:04"),
+		Items:        items,
+		Total:        r.Total.String(),
+		Tax:          optionalMoney(r.Tax),
+		Discount:     optionalMoney(r.Discount),
+		Tip:          optionalMoney(r.Tip),
+		Status:       r.Status,
+		Points:       stored.Awarded(),
+		CreatedAt:    r.CreatedAt,
+	}
+	// Only approved receipts show their points, like GET /receipts/:id/points.
+	if r.Status == receipts.StatusApproved {
+		response.Tier = toTierPointsResponse(stored.Points)
+	}
+	if r.Reconciliation.Status != "" {
+		response.Reconciliation = &receipts.ReconciliationResponse{
+			ItemsTotal: r.Reconciliation.ItemsTotal.String(),
+			Expected:   r.Reconciliation.Expected.String(),
+			Difference: r.Reconciliation.Difference.String(),
+			Status:     r.Reconciliation.Status,
+		}
+	}
+	return response
+}
+
+// toReviewResponse returns the receipt together with its risk score, which is
+// only shown to admins so clients cannot learn what the checks look for.
+func toReviewResponse(stored receipts.StoredReceipt) receipts.ReceiptResponse {
+	response := toReceiptResponse(stored)
+	if risk := stored.Receipt.Risk; risk.Score > 0 {
+		response.Risk = &risk
+	}
+	return response
+}
+
+func toSimulationResponse(simulation receipts.Simulation) receipts.SimulationResponse {
+	r, p := simulation.Receipt, simulation.Points
+	return receipts.SimulationResponse{
+		Points:      p.Points,
+		Breakdown:   p.Breakdown,
+		RuleVersion: p.RuleVersion,
+		Status:      r.Status,
+		Reconciliation: &receipts.ReconciliationResponse{
+			ItemsTotal: r.Reconciliation.ItemsTotal.String(),
+			Expected:   r.Reconciliation.Expected.String(),
+			Difference: r.Reconciliation.Difference.String(),
+			Status:     r.Reconciliation.Status,
+		},
+		Tier:        toTierPointsResponse(p),
+		DuplicateOf: r.DuplicateOf,
+	}
+}
+
+// toTierPointsResponse splits points into base points and tier bonus, or
+// returns nil for points awarded without a tier.
+func toTierPointsResponse(p receipts.Points) *receipts.TierPointsResponse {
+	if p.Tier == "" {
+		return nil
+	}
+	return &receipts.TierPointsResponse{Name: p.Tier, BasePoints: p.Base(), Bonus: p.TierBonus}
+}
+
+// optionalMoney formats amount, or returns "" for a line that was not on the receipt.
+func optionalMoney(amount receipts.Money) string {
+	if amount == 0 {
+		return ""
+	}
+	return amount.String()
+}
+
+func toReceiptQuery(queryDTO receipts.ReceiptQueryDTO) (receipts.ReceiptQuery, error) {
+	q := receipts.ReceiptQuery{
+		Retailer:         queryDTO.Retailer,
+		RetailerContains: queryDTO.RetailerContains,
+		UserID:           queryDTO.UserID,
+		SortBy:           receipts.SortField(queryDTO.Sort),
+		Cursor:           queryDTO.Cursor,
+	}
+
+	var err error
+	if queryDTO.PurchaseDateFrom != "" {
+		if q.PurchasedFrom, err = time.Parse("2006-01-02", queryDTO.PurchaseDateFrom); err != nil {
+			return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+		}
+	}
+	if queryDTO.PurchaseDateTo != "" {
+		if q.PurchasedTo, err = time.Parse("2006-01-02", queryDTO.PurchaseDateTo); err != nil {
+			return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+		}
+	}
+	if queryDTO.MinTotal != "" {
+		minTotal, err := receipts.ParseMoney(queryDTO.MinTotal)
+		if err != nil {
+			return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+		}
+		q.MinTotal = &minTotal
+	}
+	if queryDTO.MaxTotal != "" {
+		maxTotal, err := receipts.ParseMoney(queryDTO.MaxTotal)
+		if err != nil {
+			return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+		}
+		q.MaxTotal = &maxTotal
+	}
+	if queryDTO.MinPoints != "" {
+		minPoints, err := strconv.ParseInt(queryDTO.MinPoints, 10, 64)
+		if err != nil {
+			return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+		}
+		q.MinPoints = &minPoints
+	}
+	if queryDTO.Limit != "" {
+		if q.Limit, err = strconv.Atoi(queryDTO.Limit); err != nil || q.Limit <= 0 {
+			return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+		}
+	}
+	switch queryDTO.Order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		return receipts.ReceiptQuery{}, receipts.ErrQueryInvalid
+	}
+
+	return q, nil
+}
+
+func toRecomputeRequest(recomputeDTO receipts.RecomputeDTO) (receipts.RecomputeRequest, error) {
+	req := receipts.RecomputeRequest{Version: recomputeDTO.Version, FromVersion: recomputeDTO.FromVersion}
+	if recomputeDTO.PurchaseDateFrom != "" {
+		from, err := time.Parse("2006-01-02", recomputeDTO.PurchaseDateFrom)
+		if err != nil {
+			return receipts.RecomputeRequest{}, fmt.Errorf("%w: purchaseDateFrom must be YYYY-MM-DD", receipts.ErrRecomputationInvalid)
+		}
+		req.PurchasedFrom = &from
+	}
+	if recomputeDTO.PurchaseDateTo != "" {
+		to, err := time.Parse("2006-01-02", recomputeDTO.PurchaseDateTo)
+		if err != nil {
+			return receipts.RecomputeRequest{}, fmt.Errorf("%w: purchaseDateTo must be YYYY-MM-DD", receipts.ErrRecomputationInvalid)
+		}
+		req.PurchasedTo = &to
+	}
+	return req, nil
+}
+
+func toUserPointsResponse(userPoints receipts.UserPoints) receipts.UserPointsResponse {
+	recent := make([]receipts.ReceiptResponse, 0, len(userPoints.Recent))
+	for _, stored := range userPoints.Recent {
+		recent = append(recent, toReceiptResponse(stored))
+	}
+	return receipts.UserPointsResponse{
+		UserID:         userPoints.User.ID,
+		Balance:        userPoints.User.Balance,
+		Tier:           userPoints.User.Tier,
+		RecentReceipts: recent,
+	}
+}
+
+func toLedgerResponse(userLedger receipts.UserLedger) receipts.LedgerResponse {
+	entries := userLedger.Entries
+	if entries == nil {
+		entries = []receipts.LedgerEntry{}
+	}
+	return receipts.LedgerResponse{
+		UserID:  userLedger.User.ID,
+		Balance: userLedger.User.Balance,
+		Entries: entries,
+	}
+}
+
+func toListResponse(page receipts.ReceiptPage, toResponse func(receipts.StoredReceipt) receipts.ReceiptResponse) receipts.ListResponse {
+	list := make([]receipts.ReceiptResponse, 0, len(page.Receipts))
+	for _, stored := range page.Receipts {
+		list = append(list, toResponse(stored))
+	}
+	return receipts.ListResponse{Receipts: list, NextCursor: page.NextCursor}
+}
+
+func toJobResponse(job receipts.Job) receipts.JobResponse {
+	return receipts.JobResponse{
+		ID:         job.ID,
+		Status:     job.Status,
+		ReceiptID:  job.ReceiptID,
+		Error:      job.Error,
+		Attempts:   job.Attempts,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ListRuleSets returns every registered rule set.
+func (h *Handler) ListRuleSets(c *gin.Context) {
+	ruleSets, err := h.ReceiptService.RuleSets()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if ruleSets == nil {
+		ruleSets = []receipts.RuleSet{}
+	}
+	c.IndentedJSON(http.StatusOK, receipts.RuleSetsResponse{RuleSets: ruleSets})
+}
+
+// SaveRuleSet registers a rule set under a version.
+func (h *Handler) SaveRuleSet(c *gin.Context) {
+	version := c.Param("version")
+	if !idPattern.MatchString(version) {
+		abortWithError(c, errors.NewAppError(errors.RuleSetInvalid, "versions are 1 to 64 letters, digits, '-' or '_'"))
+		return
+	}
+
+	var ruleSetDTO receipts.RuleSetDTO
+	if err := c.ShouldBindJSON(&ruleSetDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.RuleSetInvalid, err))
+		return
+	}
+
+	rules, err := h.ReceiptService.SaveRuleSet(receipts.RuleSet{Version: version, Rules: ruleSetDTO.Rules})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rules)
+}
+
+// PreviewRecompute recomputes the points of a range of receipts under a rule
+// set and returns the diff, without changing any points.
+func (h *Handler) PreviewRecompute(c *gin.Context) {
+	var recomputeDTO receipts.RecomputeDTO
+	if err := c.ShouldBindJSON(&recomputeDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.RecomputationInvalid, err))
+		return
+	}
+
+	req, err := toRecomputeRequest(recomputeDTO)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	rc, err := h.ReceiptService.PreviewRecompute(req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, rc)
+}
+
+// GetRecomputation returns a recomputation report.
+func (h *Handler) GetRecomputation(c *gin.Context) {
+	rc, err := h.ReceiptService.Recomputation(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rc)
+}
+
+// CommitRecompute replaces the points of the receipts of a previewed recomputation.
+func (h *Handler) CommitRecompute(c *gin.Context) {
+	rc, err := h.ReceiptService.CommitRecompute(c.Param("id"), adminActor)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rc)
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ListRewards returns the rewards catalog.
+func (h *Handler) ListRewards(c *gin.Context) {
+	rewards, err := h.ReceiptService.Rewards()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if rewards == nil {
+		rewards = []receipts.Reward{}
+	}
+	c.IndentedJSON(http.StatusOK, receipts.RewardsResponse{Rewards: rewards})
+}
+
+// SaveReward adds a reward to the catalog or replaces it.
+func (h *Handler) SaveReward(c *gin.Context) {
+	id := c.Param("id")
+	if !idPattern.MatchString(id) {
+		abortWithError(c, errors.NewAppError(errors.RewardInvalid, "reward ids are 1 to 64 letters, digits, '-' or '_'"))
+		return
+	}
+
+	var rewardDTO receipts.RewardDTO
+	if err := c.ShouldBindJSON(&rewardDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.RewardInvalid, err))
+		return
+	}
+
+	reward, err := h.ReceiptService.SaveReward(receipts.Reward{
+		ID:    id,
+		Name:  rewardDTO.Name,
+		Cost:  rewardDTO.Cost,
+		Stock: rewardDTO.Stock,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, reward)
+}
+
+// Redeem spends the points of the authenticated user on a reward.
+func (h *Handler) Redeem(c *gin.Context) {
+	id, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	var redemptionDTO receipts.RedemptionDTO
+	if err := c.ShouldBindJSON(&redemptionDTO); err != nil {
+		abortWithError(c, bindingProblem(errors.RewardInvalid, err))
+		return
+	}
+
+	redemption, err := h.ReceiptService.Redeem(id, redemptionDTO.RewardID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, redemption)
+}
+
+// ListRedemptions returns the redemptions of the authenticated user.
+func (h *Handler) ListRedemptions(c *gin.Context) {
+	id, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	redemptions, err := h.ReceiptService.Redemptions(id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if redemptions == nil {
+		redemptions = []receipts.Redemption{}
+	}
+	c.IndentedJSON(http.StatusOK, receipts.RedemptionsResponse{Redemptions: redemptions})
+}
+
+// CancelRedemption refunds a redemption of the authenticated user.
+func (h *Handler) CancelRedemption(c *gin.Context) {
+	id, ok := requireUser(c)
+	if !ok {
+		return
+	}
+
+	redemption, err := h.ReceiptService.CancelRedemption(id, c.Param("redemptionId"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, redemption)
+}
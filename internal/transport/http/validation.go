@@ -0,0 +1,119 @@
+package http
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fetch_take_home/errors"
+	"fetch_take_home/internal/receipts"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var registerTagNameOnce sync.Once
+
+// registerTagName makes validation errors name fields by their JSON name, so
+// their namespace can be turned into a JSON pointer into the request body.
+func registerTagName() {
+	registerTagNameOnce.Do(func() {
+		if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+			v.RegisterTagNameFunc(func(field reflect.StructField) string {
+				name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+				if name == "-" {
+					return ""
+				}
+				return name
+			})
+		}
+	})
+}
+
+var namespaceIndex = regexp.MustCompile(`\[(\d+)\]`)
+
+// toJSONPointer turns a validator namespace such as ReceiptDTO.items[2].price into /items/2/price.
+func toJSONPointer(namespace string) string {
+	_, path, _ := strings.Cut(namespace, ".")
+	path = namespaceIndex.ReplaceAllString(path, ".$1")
+	return "/" + strings.ReplaceAll(path, ".", "/")
+}
+
+// toBindingErrors converts the error returned by ShouldBindJSON into field errors.
+func toBindingErrors(err error) []errors.FieldError {
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case stderrors.As(err, &validationErrors):
+		fieldErrors := make([]errors.FieldError, 0, len(validationErrors))
+		for _, fieldError := range validationErrors {
+			fieldErrors = append(fieldErrors, toFieldError(fieldError))
+		}
+		return fieldErrors
+	case stderrors.As(err, &typeError):
+		return []errors.FieldError{{
+			Path:    "/" + strings.ReplaceAll(typeError.Field, ".", "/"),
+			Code:    errors.FieldInvalidType,
+			Message: fmt.Sprintf("%s must be a %s", typeError.Field, typeError.Type.Kind()),
+		}}
+	default:
+		return []errors.FieldError{{
+			Path:    "",
+			Code:    errors.FieldInvalidFormat,
+			Message: "request body must be a JSON object",
+		}}
+	}
+}
+
+// bindingProblem returns the problem with the given code for a request body
+// that ShouldBindJSON rejected, listing every invalid field.
+func bindingProblem(code errors.Code, err error) *errors.AppError {
+	fieldErrors := toBindingErrors(err)
+	problem := errors.NewAppError(code, fmt.Sprintf("%d invalid fields", len(fieldErrors)))
+	problem.Errors = fieldErrors
+	return problem
+}
+
+func toFieldError(fieldError validator.FieldError) errors.FieldError {
+	path := toJSONPointer(fieldError.Namespace())
+	switch fieldError.Tag() {
+	case "required":
+		return errors.FieldError{Path: path, Code: errors.FieldRequired, Message: fmt.Sprintf("%s is required", fieldError.Field())}
+	case "min":
+		return errors.FieldError{Path: path, Code: errors.FieldTooShort, Message: fmt.Sprintf("%s must have at least %s entries", fieldError.Field(), fieldError.Param())}
+	default:
+		return errors.FieldError{Path: path, Code: errors.FieldInvalid, Message: fmt.Sprintf("%s is invalid", fieldError.Field())}
+	}
+}
+
+// mergeFieldErrors appends the field errors of more whose path is not in fieldErrors yet.
+func mergeFieldErrors(fieldErrors []errors.FieldError, more []errors.FieldError) []errors.FieldError {
+	seen := make(map[string]bool)
+	for _, fieldError := range fieldErrors {
+		seen[fieldError.Path] = true
+	}
+	for _, fieldError := range more {
+		if !seen[fieldError.Path] {
+			fieldErrors = append(fieldErrors, fieldError)
+		}
+	}
+	return fieldErrors
+}
+
+// toValidationError returns the error for a receipt whose binding failed with
+// bindErr. If the body was decoded, the mapper's checks are run as well so
+// that every problem in the receipt is reported at once.
+func toValidationError(receiptDTO receipts.ReceiptDTO, bindErr error) error {
+	fieldErrors := toBindingErrors(bindErr)
+
+	var validationErrors validator.ValidationErrors
+	if stderrors.As(bindErr, &validationErrors) {
+		var mapErr *receipts.ValidationError
+		if _, err := toReceipt(receiptDTO); stderrors.As(err, &mapErr) {
+			fieldErrors = mergeFieldErrors(fieldErrors, mapErr.Fields)
+		}
+	}
+	return &receipts.ValidationError{Fields: fieldErrors}
+}